@@ -0,0 +1,630 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const pageCommandPalette = "pageCommandPalette"
+
+type actionDefinition struct {
+	name  string
+	title string
+}
+
+// actionCatalog lists every action that can be bound or run from the command
+// palette, in palette order.
+var actionCatalog = []actionDefinition{
+	{name: actionQuickOpen, title: "Go to conversation"},
+	{name: actionGoToLink, title: "Go to Teams link"},
+	{name: actionNewChat, title: "New chat"},
+	{name: actionRenameChat, title: "Rename group chat"},
+	{name: actionAddChatMembers, title: "Add people to group chat"},
+	{name: actionRemoveMember, title: "Remove person from group chat"},
+	{name: actionLeaveChat, title: "Leave group chat"},
+	{name: actionToggleMembers, title: "Show/hide member list"},
+	{name: actionSetStatus, title: "Set my status"},
+	{name: actionSwitchAccount, title: "Switch account"},
+	{name: actionMemberChat, title: "Start chat with selected member"},
+	{name: actionMemberMention, title: "Mention selected member"},
+	{name: actionMemberEmail, title: "Copy selected member's email"},
+	{name: actionNextUnread, title: "Next unread conversation"},
+	{name: actionPrevUnread, title: "Previous unread conversation"},
+	{name: actionFocusCompose, title: "Focus compose"},
+	{name: actionReplyMessage, title: "Reply to selected message"},
+	{name: actionReactMessage, title: "React 👍 to selected message"},
+	{name: actionForwardMessage, title: "Forward selected message"},
+	{name: actionYankMessage, title: "Copy selected message"},
+	{name: actionYankPermalink, title: "Copy link to selected message"},
+	{name: actionYankCode, title: "Copy code block from selected message"},
+	{name: actionOpenLink, title: "Open link in selected message"},
+	{name: actionCopyLink, title: "Copy link in selected message"},
+	{name: actionVisualMode, title: "Select messages (visual mode)"},
+	{name: actionSearchMessages, title: "Search messages in chat"},
+	{name: actionSearchNext, title: "Next search match"},
+	{name: actionSearchPrev, title: "Previous search match"},
+	{name: actionExportChat, title: "Export chat to file"},
+	{name: actionToggleFavorite, title: "Toggle favorite"},
+	{name: actionTogglePin, title: "Pin/unpin channel"},
+	{name: actionToggleHide, title: "Hide/unhide team or channel"},
+	{name: actionToggleHidden, title: "Show/hide hidden teams and channels"},
+	{name: actionFavoriteUp, title: "Move favorite up"},
+	{name: actionFavoriteDown, title: "Move favorite down"},
+	{name: actionAssignFolder, title: "Move to folder / edit folder"},
+	{name: actionNewFolder, title: "New folder"},
+	{name: actionFilterTree, title: "Filter conversations"},
+	{name: actionCycleViewMode, title: "Next tree view mode"},
+	{name: actionChooseViewMode, title: "Choose tree view mode"},
+	{name: actionMarkUnread, title: "Mark conversation unread"},
+	{name: actionRefreshTitles, title: "Refresh chat titles"},
+	{name: actionToggleScan, title: "Toggle unread scan"},
+	{name: actionScanNow, title: "Scan for unread now"},
+	{name: actionReloadKeybinds, title: "Reload keybindings"},
+	{name: actionMoveDown, title: "Move selection down"},
+	{name: actionMoveUp, title: "Move selection up"},
+	{name: actionFirstItem, title: "Jump to first item"},
+	{name: actionLastItem, title: "Jump to last item"},
+	{name: actionCommandPalette, title: "Command palette"},
+	{name: actionCompleteCommand, title: "Complete slash command"},
+	{name: actionLeaveCompose, title: "Leave compose"},
+	{name: actionPasteClipboard, title: "Paste clipboard into compose"},
+	{name: actionPopupNext, title: "Next popup item"},
+	{name: actionPopupPrev, title: "Previous popup item"},
+}
+
+// Keybinding scopes. Each pane resolves keys against its own bindings; pending
+// chords are reset when the scope changes.
+const (
+	scopeGlobal   = "global"
+	scopeTree     = "tree"
+	scopeChat     = "chat"
+	scopeCompose  = "compose"
+	scopeSettings = "settings"
+	scopeMembers  = "members"
+	scopePopup    = "popup"
+)
+
+type keybindingScope struct {
+	name    string
+	title   string
+	actions []string
+	// inheritGlobal scopes also resolve the global actions, ahead of their own.
+	inheritGlobal bool
+}
+
+// keybindingScopes lists every scope in Settings & Help order.
+var keybindingScopes = []keybindingScope{
+	{name: scopeGlobal, title: "Global", actions: globalActions},
+	{name: scopeTree, title: "Tree pane", actions: treePaneActions, inheritGlobal: true},
+	{name: scopeChat, title: "Chat pane", actions: chatPaneActions, inheritGlobal: true},
+	{name: scopeCompose, title: "Compose", actions: composePaneActions, inheritGlobal: true},
+	{name: scopeSettings, title: "Settings & Help", actions: settingsPaneActions, inheritGlobal: true},
+	{name: scopeMembers, title: "Member pane", actions: membersPaneActions, inheritGlobal: true},
+	{name: scopePopup, title: "Popups", actions: popupActions},
+}
+
+func findKeybindingScope(name string) (keybindingScope, bool) {
+	for _, scope := range keybindingScopes {
+		if scope.name == name {
+			return scope, true
+		}
+	}
+	return keybindingScope{}, false
+}
+
+// scopeActionList returns the actions resolved in scope, in priority order.
+func scopeActionList(name string) []string {
+	scope, ok := findKeybindingScope(name)
+	if !ok {
+		return nil
+	}
+	if !scope.inheritGlobal {
+		return scope.actions
+	}
+	return append(append([]string(nil), globalActions...), scope.actions...)
+}
+
+func scopeHasAction(name, action string) bool {
+	for _, candidate := range scopeActionList(name) {
+		if candidate == action {
+			return true
+		}
+	}
+	return false
+}
+
+// globalActions are available from every pane.
+var globalActions = []string{
+	actionQuickOpen,
+	actionCommandPalette,
+	actionGoToLink,
+	actionToggleMembers,
+	actionSetStatus,
+	actionNewChat,
+	actionSwitchAccount,
+}
+
+// Actions handled by each pane's input capture, checked in order after the
+// global ones.
+var treePaneActions = []string{
+	actionMoveDown,
+	actionMoveUp,
+	actionFirstItem,
+	actionLastItem,
+	actionNextUnread,
+	actionPrevUnread,
+	actionToggleScan,
+	actionScanNow,
+	actionMarkUnread,
+	actionToggleFavorite,
+	actionTogglePin,
+	actionToggleHide,
+	actionToggleHidden,
+	actionFavoriteUp,
+	actionFavoriteDown,
+	actionAssignFolder,
+	actionNewFolder,
+	actionFilterTree,
+	actionCycleViewMode,
+	actionChooseViewMode,
+	actionRefreshTitles,
+	actionReloadKeybinds,
+	actionFocusCompose,
+	actionSearchMessages,
+	actionExportChat,
+	actionRenameChat,
+	actionAddChatMembers,
+	actionRemoveMember,
+	actionLeaveChat,
+}
+
+var chatPaneActions = []string{
+	actionMoveDown,
+	actionMoveUp,
+	actionFirstItem,
+	actionLastItem,
+	actionVisualMode,
+	actionYankMessage,
+	actionYankPermalink,
+	actionYankCode,
+	actionOpenLink,
+	actionCopyLink,
+	actionNextUnread,
+	actionPrevUnread,
+	actionReplyMessage,
+	actionReactMessage,
+	actionForwardMessage,
+	actionSearchMessages,
+	actionSearchNext,
+	actionSearchPrev,
+	actionExportChat,
+	actionRenameChat,
+	actionAddChatMembers,
+	actionRemoveMember,
+	actionLeaveChat,
+	actionReloadKeybinds,
+}
+
+// composePaneActions only match single keys so typing is never held back by a
+// pending sequence.
+var composePaneActions = []string{
+	actionCompleteCommand,
+	actionLeaveCompose,
+	actionPasteClipboard,
+}
+
+var settingsPaneActions = []string{
+	actionMoveDown,
+	actionMoveUp,
+	actionFirstItem,
+	actionLastItem,
+	actionReloadKeybinds,
+}
+
+var membersPaneActions = []string{
+	actionMoveDown,
+	actionMoveUp,
+	actionFirstItem,
+	actionLastItem,
+	actionMemberChat,
+	actionMemberMention,
+	actionMemberEmail,
+	actionRemoveMember,
+}
+
+var popupActions = []string{
+	actionPopupNext,
+	actionPopupPrev,
+}
+
+// focusedKeyScope returns the scope of the focused pane.
+func (s *AppState) focusedKeyScope() string {
+	switch s.app.GetFocus() {
+	case s.components[TrChat]:
+		return scopeTree
+	case s.components[ViCompose]:
+		return scopeCompose
+	case s.components[ViMembers]:
+		return scopeMembers
+	case s.components[ViChat]:
+		if s.isSettingsMode() {
+			return scopeSettings
+		}
+		return scopeChat
+	}
+	return scopeGlobal
+}
+
+// dispatchPaneAction resolves event, possibly as part of a key sequence, against
+// the bindings of scope and runs the result. Movement actions move the cursor of
+// the focused widget and honor a normal mode count. The event is passed through
+// when nothing matches or the action does not apply.
+func (s *AppState) dispatchPaneAction(scope string, event *tcell.EventKey) *tcell.EventKey {
+	if s.isModalEditing() && s.handleModalKey(scope, event) {
+		return nil
+	}
+	shadowed, action, consumed := s.resolveKeyAction(scope, scopeActionList(scope), event)
+	if shadowed != "" {
+		s.runPaneAction(shadowed, s.takeCount())
+	}
+	if action == "" {
+		if !consumed {
+			s.clearCount()
+			return event
+		}
+		return nil
+	}
+	if s.runPaneAction(action, s.takeCount()) {
+		return nil
+	}
+	return event
+}
+
+// runPaneAction runs action count times when it is a movement, and through
+// runAction otherwise.
+func (s *AppState) runPaneAction(action string, count int) bool {
+	switch action {
+	case actionMoveDown, actionMoveUp, actionFirstItem, actionLastItem:
+		return s.moveCursor(action, count)
+	}
+	return s.runAction(action)
+}
+
+// setComposeStatus appends a short status message to the compose title.
+func (s *AppState) setComposeStatus(message string) {
+	composeView, ok := s.components[ViCompose].(*tview.InputField)
+	if !ok {
+		return
+	}
+	title := s.composeTitleWithScanStatus()
+	if strings.TrimSpace(message) != "" {
+		title += " | " + message
+	}
+	composeView.SetTitle(title)
+}
+
+// runAction executes action against the focused pane, the tree selection and the
+// selected chat message. It must be called on the UI goroutine and returns false
+// when the action does not apply in the current context.
+func (s *AppState) runAction(action string) bool {
+	treeView, _ := s.components[TrChat].(*tview.TreeView)
+	composeView, _ := s.components[ViCompose].(*tview.InputField)
+
+	switch action {
+	case actionMoveDown, actionMoveUp, actionFirstItem, actionLastItem:
+		return s.moveCursor(action, 1)
+	case actionVisualMode:
+		if !s.toggleVisualSelection() {
+			s.setComposeStatus("Focus the chat pane to select messages")
+		}
+		return true
+	case actionYankMessage:
+		s.yankSelectedMessages()
+		return true
+	case actionYankPermalink:
+		s.yankSelectedPermalink()
+		return true
+	case actionYankCode:
+		s.yankSelectedCodeBlock()
+		return true
+	case actionOpenLink:
+		s.openSelectedMessageLink()
+		return true
+	case actionCopyLink:
+		s.copySelectedMessageLink()
+		return true
+	case actionPasteClipboard:
+		return s.pasteIntoCompose()
+	case actionCompleteCommand:
+		return s.app.GetFocus() == composeView && s.completeSlashCommand()
+	case actionLeaveCompose:
+		if composeView == nil || s.app.GetFocus() != composeView {
+			return false
+		}
+		s.resetMentionCycle()
+		s.clearPendingReply()
+		s.updateComposeReplyUI()
+		s.app.SetFocus(treeView)
+		return true
+	case actionNextUnread, actionPrevUnread:
+		if !s.jumpToUnread(action == actionPrevUnread) {
+			s.setComposeStatus("No unread conversations")
+		}
+		return true
+	case actionQuickOpen:
+		s.showQuickOpen()
+		return true
+	case actionCommandPalette:
+		s.showCommandPalette()
+		return true
+	case actionGoToLink:
+		s.promptGoToLink()
+		return true
+	case actionToggleMembers:
+		s.toggleMembersPane()
+		return true
+	case actionSetStatus:
+		s.showStatusPicker()
+		return true
+	case actionSwitchAccount:
+		s.showAccountSwitcher()
+		return true
+	case actionNewChat:
+		s.showNewChat()
+		return true
+	case actionRenameChat:
+		s.promptRenameGroupChat()
+		return true
+	case actionAddChatMembers:
+		s.showAddChatMembers()
+		return true
+	case actionRemoveMember:
+		if s.app.GetFocus() == s.components[ViMembers] {
+			member, ok := s.selectedMember()
+			if !ok {
+				s.setComposeStatus("Select a member first")
+				return true
+			}
+			_, _, node := s.getActiveConversation()
+			if err := s.removeGroupChatMember(node, member.mri, member.displayName); err != nil {
+				s.setComposeStatus(err.Error())
+			}
+			return true
+		}
+		s.showRemoveChatMember()
+		return true
+	case actionLeaveChat:
+		if err := s.leaveGroupChat(s.chatActionNode()); err != nil {
+			s.setComposeStatus(err.Error())
+		}
+		return true
+	case actionMemberChat, actionMemberMention, actionMemberEmail:
+		if !s.isMembersPaneVisible() {
+			s.setComposeStatus("Show the member list first")
+			return true
+		}
+		member, ok := s.selectedMember()
+		if !ok {
+			s.setComposeStatus("Select a member first")
+			return true
+		}
+		switch action {
+		case actionMemberChat:
+			s.startChatWithMember(member)
+		case actionMemberMention:
+			s.mentionMember(member)
+		default:
+			s.copyMemberEmail(member)
+		}
+		return true
+	case actionToggleScan:
+		enabled := s.toggleUnreadScanEnabled()
+		s.logger.WithField("enabled", enabled).Info("unread scan toggle changed")
+		s.setComposeStatus("")
+		return true
+	case actionScanNow:
+		if s.tree.root == nil {
+			return false
+		}
+		if s.markUnreadScanStart() {
+			s.setComposeStatus("")
+			go s.refreshUnreadMarkers(s.tree.root)
+		} else {
+			s.setComposeStatus("Scan already running")
+		}
+		return true
+	case actionMarkUnread:
+		return s.markSelectedNodeUnread()
+	case actionToggleFavorite:
+		if treeView == nil || s.tree.chats == nil {
+			return false
+		}
+		return s.toggleFavoriteForCurrentNode(treeView, s.tree.chats, s.tree.favorites, s.tree.recent)
+	case actionTogglePin:
+		if err := s.togglePinForNode(s.chatActionNode()); err != nil {
+			s.setComposeStatus(err.Error())
+		}
+		return true
+	case actionToggleHide:
+		if treeView == nil || treeView.GetCurrentNode() == nil {
+			return false
+		}
+		if err := s.toggleHideForNode(treeView.GetCurrentNode()); err != nil {
+			s.setComposeStatus(err.Error())
+		}
+		return true
+	case actionToggleHidden:
+		s.toggleShowHidden()
+		return true
+	case actionFavoriteUp, actionFavoriteDown:
+		if action == actionFavoriteUp {
+			return s.moveFavorite(-1)
+		}
+		return s.moveFavorite(1)
+	case actionAssignFolder:
+		if treeView == nil || treeView.GetCurrentNode() == nil {
+			return false
+		}
+		s.showFolderActions(treeView.GetCurrentNode())
+		return true
+	case actionNewFolder:
+		s.promptNewFolder(nil)
+		return true
+	case actionFilterTree:
+		s.openTreeFilter()
+		return true
+	case actionCycleViewMode:
+		s.cycleTreeViewMode()
+		return true
+	case actionChooseViewMode:
+		s.showViewModePicker()
+		return true
+	case actionRefreshTitles:
+		if s.tree.chats == nil {
+			return false
+		}
+		go s.refreshAllChatLabels(s.tree.chats)
+		return true
+	case actionReloadKeybinds:
+		if err := s.reloadKeybindingsConfig(); err != nil {
+			s.setComposeStatus("Keybind reload: " + err.Error())
+		} else {
+			s.setComposeStatus("Keybindings reloaded")
+		}
+		if s.isSettingsMode() {
+			s.renderSettingsHelpItems(s.components[ViChat].(*tview.List))
+		}
+		return true
+	case actionFocusCompose:
+		ids, _, _ := s.getActiveConversation()
+		if len(ids) == 0 || composeView == nil {
+			return false
+		}
+		s.app.SetFocus(composeView)
+		return true
+	case actionReplyMessage:
+		msg, ok := s.selectedChatMessage()
+		if !ok {
+			s.setComposeStatus("Select a message first")
+			return true
+		}
+		s.setPendingReply(&replyTarget{
+			MessageID: strings.TrimSpace(msg.Id),
+			Author:    s.messageAuthor(msg),
+			Preview:   summarizeReplyPreview(msg.Content),
+		})
+		s.updateComposeReplyUI()
+		s.app.SetFocus(composeView)
+		return true
+	case actionReactMessage:
+		msg, ok := s.selectedChatMessage()
+		if !ok || strings.TrimSpace(msg.Id) == "" || strings.TrimSpace(msg.ConversationId) == "" {
+			s.setComposeStatus("Select a message first")
+			return true
+		}
+		go s.reactToMessage(msg, defaultReactionKey)
+		s.setComposeStatus("Reacted 👍")
+		return true
+	case actionForwardMessage:
+		s.forwardSelectedMessage()
+		return true
+	case actionSearchMessages:
+		s.promptSearchMessages()
+		return true
+	case actionSearchNext, actionSearchPrev:
+		s.stepSearchMatch(action == actionSearchPrev)
+		return true
+	case actionExportChat:
+		path, err := s.exportActiveChat()
+		if err != nil {
+			s.setComposeStatus("Export failed: " + err.Error())
+		} else {
+			s.setComposeStatus("Exported to " + path)
+		}
+		return true
+	}
+	return false
+}
+
+// markSelectedNodeUnread marks the chat or channel under the tree cursor unread
+// locally and on the server.
+func (s *AppState) markSelectedNodeUnread() bool {
+	treeView, ok := s.components[TrChat].(*tview.TreeView)
+	if !ok {
+		return false
+	}
+	selected := treeView.GetCurrentNode()
+	if selected == nil {
+		s.setComposeStatus("Select a chat first")
+		return false
+	}
+	if channel, ok := selected.GetReference().(channelRef); ok {
+		channel.isUnread = true
+		s.setManualUnread(channel.channel.Id, true)
+		selected.SetText(channel.treeTitle())
+		selected.SetReference(channel)
+		s.syncConversationCopies(selected)
+		refreshTreeUnreadLabels(treeView.GetRoot())
+		s.setComposeStatus("Marked unread")
+		go s.markConversationUnreadOnServer(channel.channel.DisplayName, []string{channel.channel.Id})
+		return true
+	}
+	ref, ok := selected.GetReference().(conversationRef)
+	if !ok || strings.TrimSpace(ref.chatKey) == "" || ref.chatKey == settingsHelpChatKey {
+		s.setComposeStatus("Select a chat first")
+		return false
+	}
+	ref.isUnread = true
+	s.setManualUnread(ref.chatKey, true)
+	selected.SetText(ref.treeTitle())
+	selected.SetReference(ref)
+	s.syncConversationCopies(selected)
+	refreshTreeUnreadLabels(treeView.GetRoot())
+	s.setComposeStatus("Marked unread")
+	s.logger.WithFields(logrus.Fields{
+		"chat_key": ref.chatKey,
+		"title":    ref.title,
+	}).Debug("marked chat unread manually")
+	go s.markConversationUnreadOnServer(ref.title, ref.ids)
+	return true
+}
+
+// showCommandPalette lists every action with its binding in the focused pane, or
+// in the first scope offering it. The chosen action runs against the pane that
+// was focused when the palette opened.
+func (s *AppState) showCommandPalette() {
+	focused := s.focusedKeyScope()
+	items := make([]pickerItem, 0, len(actionCatalog))
+	for _, def := range actionCatalog {
+		if def.name == actionCommandPalette || scopeHasAction(scopePopup, def.name) {
+			continue
+		}
+		scope := focused
+		if !scopeHasAction(scope, def.name) {
+			for _, candidate := range keybindingScopes {
+				if scopeHasAction(candidate.name, def.name) {
+					scope = candidate.name
+					break
+				}
+			}
+		}
+		items = append(items, pickerItem{
+			label:     def.title,
+			secondary: def.name + " · " + s.formatActionBindingLine(scope, def.name),
+			keywords:  []string{def.name},
+			value:     def.name,
+		})
+	}
+	s.showPicker(pageCommandPalette, "Command palette", items, func(item pickerItem) {
+		action, ok := item.value.(string)
+		if !ok {
+			return
+		}
+		s.logger.WithField("action", action).Debug("running action from command palette")
+		if !s.runAction(action) {
+			s.setComposeStatus(item.label + " is not available here")
+		}
+	})
+}
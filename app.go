@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	teams_api "github.com/fossteams/teams-api"
@@ -17,7 +19,6 @@ import (
 	"github.com/rivo/tview"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/html"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -39,6 +40,7 @@ type AppState struct {
 
 	TeamsState
 	components map[string]tview.Primitive
+	tree       conversationTree
 
 	activeConversationMu    sync.RWMutex
 	activeConversationIDs   []string
@@ -47,11 +49,21 @@ type AppState struct {
 
 	chatFavoritesMu sync.RWMutex
 	chatFavorites   map[string]bool
+	favoriteOrder   []string
 	chatTitlesMu    sync.RWMutex
 	chatTitles      map[string]string
 
 	authRefreshMu sync.Mutex
 
+	tokenMu       sync.RWMutex
+	tokenExpiries map[string]time.Time
+	tokenRetryAt  time.Time
+
+	// account is the profile whose tokens and settings are in use.
+	account         accountProfile
+	accountUnreadMu sync.RWMutex
+	accountUnread   map[string]accountUnread
+
 	settingsPath string
 	settingsKey  string
 	keybindPath  string
@@ -67,25 +79,89 @@ type AppState struct {
 	manualUnreadMu sync.RWMutex
 	manualUnread   map[string]bool
 
+	mutedMu sync.RWMutex
+	muted   map[string]bool
+
+	hiddenMu   sync.RWMutex
+	hidden     map[string]bool
+	showHidden bool
+
+	foldersMu sync.RWMutex
+	folders   []chatFolder
+
+	// pendingChats holds chats created here until the server lists them. Only
+	// touched on the UI goroutine.
+	pendingChats map[string]csa.Chat
+
+	treeFilterMu sync.RWMutex
+	treeFilter   string
+	treeViewMode string
+	activeDays   int
+
+	unreadCountsMu sync.Mutex
+	unreadCounts   map[string]unreadCountEntry
+
+	readHorizonMu   sync.Mutex
+	readHorizonSent map[string]string
+
 	chatMessagesMu sync.RWMutex
 	chatMessages   []csa.ChatMessage
 	chatRowMap     []int
 
+	searchMu      sync.Mutex
+	searchQuery   string
+	searchMatches []int
+	searchPos     int
+
 	replyMu      sync.RWMutex
 	pendingReply *replyTarget
 
 	messageReactionsMu sync.RWMutex
 	messageReactions   map[string]string
 
-	keybindMu        sync.RWMutex
-	keybindings      map[string][]string
-	keybindPreset    string
-	keybindOverrides map[string][]string
-	keybindParseErr  error
+	keybindMu             sync.RWMutex
+	keybindings           map[string]map[string][]string
+	keybindPreset         string
+	keybindOverrides      map[string][]string
+	keybindScopeOverrides map[string]map[string][]string
+	keybindConflicts      map[string]map[string]bool
+	keybindParseErr       error
+	keySequences          map[string]map[string][]keySequence
+	keyLeader             string
+	keySequenceTimeout    time.Duration
+
+	keySeqMu sync.Mutex
+	keySeq   pendingKeys
+
+	linkTargetMu sync.Mutex
+	linkTarget   *messageTarget
+	startupLink  string
+
+	historyMu      sync.Mutex
+	messageHistory map[string][]csa.ChatMessage
+
+	membersMu      sync.RWMutex
+	membersVisible bool
+	membersSource  memberSource
+	membersList    []conversationMember
+	memberCache    map[string][]conversationMember
+	profiles       map[string]models.User
+
+	presenceMu       sync.RWMutex
+	presence         map[string]presenceInfo
+	presenceInterval time.Duration
+	presenceStop     chan struct{}
+
+	modeMu       sync.Mutex
+	editMode     string
+	pendingCount int
+	visualAnchor int
+	visualRows   map[int]string
 
 	settingsMu            sync.RWMutex
 	settingsMode          bool
 	settingsSelection     int
+	settingsCaptureScope  string
 	settingsCaptureAction string
 
 	mentionCycleMu    sync.Mutex
@@ -108,11 +184,77 @@ type AppState struct {
 }
 
 type conversationRef struct {
-	ids        []string
-	title      string
-	chatKey    string
+	ids          []string
+	title        string
+	chatKey      string
+	isFavorite   bool
+	isUnread     bool
+	isMuted      bool
+	unreadCount  int
+	mentionCount int
+	// presenceMri is the other member of a one on one chat, whose presence
+	// is shown as a colored dot.
+	presenceMri string
+	presence    string
+	// folder names the user folder of a copy listed there.
+	folder string
+}
+
+type channelRef struct {
+	channel      csa.Channel
+	teamName     string
+	isUnread     bool
+	isMuted      bool
+	unreadCount  int
+	mentionCount int
+	// isPinned, isFavorite and folder mark the copies listed under Pinned,
+	// Favorites or a user folder, which name their team.
+	isPinned   bool
 	isFavorite bool
-	isUnread   bool
+	folder     string
+}
+
+// conversationTree keeps the structural nodes built by fillMainWindow so actions
+// can reach them outside of the input handlers.
+type conversationTree struct {
+	root      *tview.TreeNode
+	pinned    *tview.TreeNode
+	folders   []*tview.TreeNode
+	teams     *tview.TreeNode
+	chats     *tview.TreeNode
+	favorites *tview.TreeNode
+	recent    *tview.TreeNode
+	// teamNodes and channelNodes hold every team and its channels, including
+	// hidden ones that are not attached to the tree.
+	teamNodes    []*tview.TreeNode
+	channelNodes map[*tview.TreeNode][]*tview.TreeNode
+	// view is the filtered copy shown instead of root while a filter or view
+	// mode is active.
+	view *tview.TreeNode
+}
+
+// groupRef marks structural tree nodes (Teams, Chats, Favorites, Recent and user
+// folders) so their labels can carry aggregated unread badges.
+type groupRef struct {
+	name   string
+	folder bool
+}
+
+func (r conversationRef) treeTitle() string {
+	return formatMutedTreeTitle(formatUnreadTreeTitle(presenceDot(r.presence)+r.title, r.isUnread, r.unreadCount, r.mentionCount), r.isMuted)
+}
+
+// isShortcut reports whether the node is a copy of a channel outside its team.
+func (r channelRef) isShortcut() bool {
+	return r.isPinned || r.isFavorite || r.folder != ""
+}
+
+func (r channelRef) treeTitle() string {
+	name := r.channel.DisplayName
+	if r.isShortcut() && r.teamName != "" {
+		name = r.teamName + " › " + name
+	}
+	return formatMutedTreeTitle(formatUnreadTreeTitle(name, r.isUnread, r.unreadCount, r.mentionCount), r.isMuted)
 }
 
 type replyTarget struct {
@@ -145,6 +287,12 @@ type persistedChatSettings struct {
 	Favorites       map[string]bool   `json:"favorites"`
 	Titles          map[string]string `json:"titles"`
 	UnreadOverrides map[string]bool   `json:"unread_overrides,omitempty"`
+	Muted           map[string]bool   `json:"muted,omitempty"`
+	FavoriteOrder   []string          `json:"favorite_order,omitempty"`
+	Hidden          map[string]bool   `json:"hidden,omitempty"`
+	Folders         []persistedFolder `json:"folders,omitempty"`
+	TreeViewMode    string            `json:"tree_view_mode,omitempty"`
+	ActiveDays      int               `json:"active_days,omitempty"`
 	ChatWordWrap    *bool             `json:"chat_word_wrap,omitempty"`
 	ChatWrapPercent *int              `json:"chat_wrap_percent,omitempty"`
 	ChatWrapChars   *int              `json:"chat_wrap_chars,omitempty"`
@@ -153,12 +301,16 @@ type persistedChatSettings struct {
 }
 
 type keybindingConfigFile struct {
-	Preset   string              `json:"preset"`
-	Bindings map[string][]string `json:"bindings"`
+	Preset            string                         `json:"preset"`
+	Leader            string                         `json:"leader,omitempty"`
+	SequenceTimeoutMs int                            `json:"sequence_timeout_ms,omitempty"`
+	Bindings          map[string][]string            `json:"bindings"`
+	Scopes            map[string]map[string][]string `json:"scopes,omitempty"`
 }
 
 type settingsItem struct {
 	kind   string
+	scope  string
 	action string
 }
 
@@ -180,20 +332,68 @@ const (
 	settingsItemWrapPct      = "chat_wrap_pct"
 	settingsItemComposeColor = "compose_color"
 	settingsItemAuthorColor  = "author_color"
+	settingsItemTreeView     = "tree_view"
+	settingsItemActiveDays   = "active_days"
+	settingsItemKeybindError = "keybind_error"
+	settingsItemScope        = "scope"
 )
 
 const (
-	actionToggleScan     = "toggle_scan"
-	actionScanNow        = "scan_now"
-	actionMarkUnread     = "mark_unread"
-	actionToggleFavorite = "toggle_favorite"
-	actionRefreshTitles  = "refresh_titles"
-	actionReloadKeybinds = "reload_keybindings"
-	actionFocusCompose   = "focus_compose"
-	actionReplyMessage   = "reply_message"
-	actionReactMessage   = "react_message"
-	actionMoveDown       = "move_down"
-	actionMoveUp         = "move_up"
+	actionToggleScan      = "toggle_scan"
+	actionScanNow         = "scan_now"
+	actionMarkUnread      = "mark_unread"
+	actionToggleFavorite  = "toggle_favorite"
+	actionRefreshTitles   = "refresh_titles"
+	actionReloadKeybinds  = "reload_keybindings"
+	actionFocusCompose    = "focus_compose"
+	actionReplyMessage    = "reply_message"
+	actionReactMessage    = "react_message"
+	actionMoveDown        = "move_down"
+	actionMoveUp          = "move_up"
+	actionNextUnread      = "next_unread"
+	actionPrevUnread      = "prev_unread"
+	actionQuickOpen       = "quick_open"
+	actionCommandPalette  = "command_palette"
+	actionExportChat      = "export_chat"
+	actionSearchMessages  = "search_messages"
+	actionSearchNext      = "search_next"
+	actionSearchPrev      = "search_prev"
+	actionForwardMessage  = "forward_message"
+	actionFirstItem       = "first_item"
+	actionLastItem        = "last_item"
+	actionVisualMode      = "visual_mode"
+	actionYankMessage     = "yank_message"
+	actionCompleteCommand = "complete_command"
+	actionLeaveCompose    = "leave_compose"
+	actionPopupNext       = "popup_next"
+	actionPopupPrev       = "popup_prev"
+	actionYankPermalink   = "yank_permalink"
+	actionYankCode        = "yank_code"
+	actionPasteClipboard  = "paste_clipboard"
+	actionOpenLink        = "open_link"
+	actionCopyLink        = "copy_link"
+	actionGoToLink        = "go_to_link"
+	actionToggleMembers   = "toggle_members"
+	actionSetStatus       = "set_status"
+	actionMemberChat      = "member_chat"
+	actionMemberMention   = "member_mention"
+	actionMemberEmail     = "member_copy_email"
+	actionNewChat         = "new_chat"
+	actionRenameChat      = "rename_chat"
+	actionAddChatMembers  = "add_chat_members"
+	actionRemoveMember    = "remove_chat_member"
+	actionLeaveChat       = "leave_chat"
+	actionTogglePin       = "toggle_pin"
+	actionToggleHide      = "toggle_hide"
+	actionToggleHidden    = "toggle_show_hidden"
+	actionFavoriteUp      = "move_favorite_up"
+	actionFavoriteDown    = "move_favorite_down"
+	actionAssignFolder    = "assign_folder"
+	actionNewFolder       = "new_folder"
+	actionFilterTree      = "filter_tree"
+	actionCycleViewMode   = "cycle_view_mode"
+	actionChooseViewMode  = "choose_view_mode"
+	actionSwitchAccount   = "switch_account"
 )
 
 func (s *AppState) createApp() {
@@ -205,17 +405,31 @@ func (s *AppState) createApp() {
 	s.unreadScanEnabled = true
 	s.unreadScanInterval = time.Minute
 	s.unreadScanStop = make(chan struct{})
+	s.presenceInterval = time.Minute
+	s.presenceStop = make(chan struct{})
 	s.manualUnread = map[string]bool{}
+	s.muted = map[string]bool{}
+	s.hidden = map[string]bool{}
+	s.editMode = modeNormal
+	s.visualAnchor = -1
+	s.readHorizonSent = map[string]string{}
+	s.unreadCounts = map[string]unreadCountEntry{}
 	s.messageReactions = map[string]string{}
 	s.chatWordWrap = true
 	s.chatWrapChars = 80
 	s.composeColorName = "slate"
 	s.authorColorName = "blue"
-	s.settingsPath, s.settingsKey = defaultSettingsPaths()
+	s.treeViewMode = treeViewAll
+	s.activeDays = defaultActiveDays
+	s.accountUnread = map[string]accountUnread{}
+	s.settingsPath, s.settingsKey = s.account.settingsPaths()
 	s.keybindPath = defaultKeybindPath()
 	s.keybindPreset = defaultKeybindPreset
-	s.keybindings = defaultKeybindingsForPreset(defaultKeybindPreset)
+	s.keyLeader = defaultKeyLeader
+	s.keySequenceTimeout = defaultSequenceTimeout
 	s.keybindOverrides = map[string][]string{}
+	s.keybindScopeOverrides = map[string]map[string][]string{}
+	s.applyKeybindingsLocked()
 	if err := s.loadKeybindingsConfig(); err != nil {
 		s.keybindParseErr = err
 		s.logger.WithError(err).Warn("unable to load keybinding config")
@@ -231,9 +445,10 @@ func (s *AppState) createApp() {
 
 	frame := tview.NewFrame(s.pages)
 	frame.SetBorder(true)
-	frame.SetTitle("teams-cli")
+	frame.SetTitle(s.accountBadgeTitle())
 	frame.SetBorder(true)
 	frame.SetTitleAlign(tview.AlignCenter)
+	s.components[FrMain] = frame
 
 	s.app.SetRoot(frame, true)
 
@@ -241,6 +456,23 @@ func (s *AppState) createApp() {
 	s.pages.SwitchToPage(PageLogin)
 	s.app.SetFocus(s.pages)
 	s.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if front, _ := s.pages.GetFrontPage(); front != PageMain {
+			return event
+		}
+		// The tree filter bar handles its own keys, like the popups.
+		if s.app.GetFocus() == s.components[ViTreeFilter] {
+			return event
+		}
+		// Tree, chat and settings panes resolve global actions together with
+		// their own bindings so key sequences can be shared.
+		scope := s.focusedKeyScope()
+		if s.conversationsSnapshot() != nil && (scope == scopeCompose || scope == scopeGlobal) {
+			for _, action := range scopeActionList(scope) {
+				if s.bindingMatches(scope, action, event) && s.runAction(action) {
+					return nil
+				}
+			}
+		}
 		switch event.Key() {
 		case tcell.KeyTAB:
 			s.focusNextPane()
@@ -268,6 +500,12 @@ func (s *AppState) focusNextPane() {
 		s.app.SetFocus(chat)
 	case chat:
 		s.app.SetFocus(compose)
+	case compose:
+		if s.isMembersPaneVisible() {
+			s.app.SetFocus(s.components[ViMembers])
+			return
+		}
+		s.app.SetFocus(tree)
 	default:
 		s.app.SetFocus(tree)
 	}
@@ -284,6 +522,12 @@ func (s *AppState) focusPrevPane() {
 		s.app.SetFocus(chat)
 	case chat:
 		s.app.SetFocus(tree)
+	case tree:
+		if s.isMembersPaneVisible() {
+			s.app.SetFocus(s.components[ViMembers])
+			return
+		}
+		s.app.SetFocus(compose)
 	default:
 		s.app.SetFocus(compose)
 	}
@@ -309,18 +553,43 @@ func (s *AppState) createMainView() tview.Primitive {
 	composeView.SetBorder(true)
 	composeView.SetTitle(s.composeTitleWithScanStatus())
 	composeView.SetTitleAlign(tview.AlignCenter)
+	composeView.SetFocusFunc(func() {
+		s.setEditMode(modeInsert)
+	})
+	composeView.SetBlurFunc(func() {
+		s.setEditMode(modeNormal)
+	})
+
+	membersView := tview.NewList().
+		ShowSecondaryText(false).
+		SetHighlightFullLine(true)
+	membersView.SetBorder(true)
+	membersView.SetTitle("Members")
+	membersView.SetTitleAlign(tview.AlignCenter)
+
+	treeFilter := s.newTreeFilterInput()
 
 	s.components[TrChat] = treeView
+	s.components[ViTreeFilter] = treeFilter
 	s.components[ViChat] = chatView
 	s.components[ViCompose] = composeView
+	s.components[ViMembers] = membersView
 
 	chatPane := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(chatView, 0, 1, false).
 		AddItem(composeView, 3, 0, false)
 
+	// The filter bar is sized to one row while the tree is filtered.
+	treePane := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(treeFilter, 0, 0, false).
+		AddItem(treeView, 0, 1, false)
+	s.components[FlTree] = treePane
+
+	// The member pane is added to the right when toggled on.
 	flex := tview.NewFlex().
-		AddItem(treeView, 0, 1, false).
+		AddItem(treePane, 0, 1, false).
 		AddItem(chatPane, 0, 2, false)
+	s.components[FlMain] = flex
 
 	return flex
 }
@@ -346,6 +615,11 @@ func (s *AppState) createLoginPage() tview.Primitive {
 }
 
 func (s *AppState) start() {
+	if err := s.account.useTokens(); err != nil {
+		s.showError(err)
+		return
+	}
+	s.logger.WithField("profile", s.account.displayName()).Info("using account profile")
 	s.logTokenDiagnostics()
 
 	s.logger.Info("initializing Teams client")
@@ -381,6 +655,7 @@ func (s *AppState) logTokenDiagnostics() {
 		s.logger.WithError(err).Error("unable to load skype token")
 	} else {
 		logTokenMeta(s.logger, "skype", skypeSpacesToken)
+		s.recordTokenExpiry("skype", skypeSpacesToken)
 	}
 
 	chatSvcToken, err := api.GetChatSvcAggToken()
@@ -388,13 +663,16 @@ func (s *AppState) logTokenDiagnostics() {
 		s.logger.WithError(err).Error("unable to load chatsvcagg token")
 	} else {
 		logTokenMeta(s.logger, "chatsvcagg", chatSvcToken)
+		s.recordTokenExpiry("chatsvcagg", chatSvcToken)
 	}
 
-	_, err = api.GetSkypeToken()
+	skypeToken, err := api.GetSkypeToken()
 	if err != nil {
 		s.logger.WithError(err).Error("unable to refresh skype token via authz")
 	} else {
 		s.logger.Info("skype token refresh via authz succeeded")
+		logTokenMeta(s.logger, "skypetoken", skypeToken)
+		s.recordTokenExpiry("skypetoken", skypeToken)
 	}
 }
 
@@ -453,7 +731,7 @@ func (s *AppState) showError(err error) {
 	}
 	val.(*tview.TextView).SetText(err.Error())
 
-	is401 := isUnauthorizedError(err)
+	is401 := isUnauthorizedError(err) || errors.Is(err, errProfileSignedOut)
 	if body, ok := s.components[FlErrorBody].(*tview.Flex); ok {
 		if action, ok := s.components[FlErrorAction]; ok {
 			if is401 {
@@ -481,11 +759,11 @@ func (s *AppState) createErrorView() tview.Primitive {
 	p.SetBorder(true)
 	p.SetBorderPadding(1, 1, 1, 1)
 
-	authButton := tview.NewButton("Run teams-token")
+	authButton := tview.NewButton("Sign in again")
 	authButton.SetSelectedFunc(func() {
 		go func() {
 			s.app.QueueUpdateDraw(func() {
-				p.SetText("Refreshing auth by running ./teams-token ...")
+				p.SetText("Refreshing auth (native sign-in or ./teams-token) ...")
 			})
 			err := s.refreshAuthFromTeamsToken()
 			s.app.QueueUpdateDraw(func() {
@@ -493,6 +771,12 @@ func (s *AppState) createErrorView() tview.Primitive {
 					p.SetText(fmt.Sprintf("Unable to refresh auth: %v", err))
 					return
 				}
+				if s.conversationsSnapshot() == nil {
+					// Start-up failed before the main view was built.
+					s.pages.SwitchToPage(PageLogin)
+					go s.start()
+					return
+				}
 				p.SetText("Auth refresh succeeded. Returning to main view.")
 				s.pages.SwitchToPage(PageMain)
 				if tree, ok := s.components[TrChat]; ok {
@@ -529,17 +813,24 @@ func (s *AppState) fillMainWindow() {
 	composeView := s.components[ViCompose].(*tview.InputField)
 	rootNode := tview.NewTreeNode("Conversations")
 	teamsNode := tview.NewTreeNode("Teams")
+	teamsNode.SetReference(groupRef{name: "Teams"})
 	teamsNode.SetColor(tcell.ColorBlue)
 	chatsNode := tview.NewTreeNode("Chats")
+	chatsNode.SetReference(groupRef{name: "Chats"})
 	chatsNode.SetColor(tcell.ColorYellow)
 	favoritesNode := tview.NewTreeNode("Favorites")
+	favoritesNode.SetReference(groupRef{name: "Favorites"})
 	favoritesNode.SetColor(tcell.ColorYellow)
 	recentNode := tview.NewTreeNode("Recent")
+	recentNode.SetReference(groupRef{name: "Recent"})
 	recentNode.SetColor(tcell.ColorYellow)
 
 	var firstNode *tview.TreeNode
 	var mostRecentChatNode *tview.TreeNode
-	for _, t := range s.conversations.Teams {
+	teamNodes := []*tview.TreeNode{}
+	channelNodes := map[*tview.TreeNode][]*tview.TreeNode{}
+	conversations := s.conversationsSnapshot()
+	for _, t := range conversations.Teams {
 		currentTeamTreeNode := tview.NewTreeNode(t.DisplayName)
 		currentTeamTreeNode.SetReference(t)
 		if firstNode == nil {
@@ -547,21 +838,21 @@ func (s *AppState) fillMainWindow() {
 		}
 
 		for _, c := range t.Channels {
-			currentChannelTreeNode := tview.NewTreeNode(c.DisplayName)
-			currentChannelTreeNode.SetReference(c)
-			currentChannelTreeNode.SetColor(tcell.ColorGreen)
+			currentChannelTreeNode := s.newChannelNode(t, c)
 			currentTeamTreeNode.AddChild(currentChannelTreeNode)
+			channelNodes[currentTeamTreeNode] = append(channelNodes[currentTeamTreeNode], currentChannelTreeNode)
 		}
 		currentTeamTreeNode.CollapseAll()
 		currentTeamTreeNode.SetColor(tcell.ColorBlue)
 
 		teamsNode.AddChild(currentTeamTreeNode)
+		teamNodes = append(teamNodes, currentTeamTreeNode)
 	}
 	rootNode.AddChild(teamsNode)
-	s.logger.WithField("teams_count", len(s.conversations.Teams)).Debug("teams tree nodes prepared")
+	s.logger.WithField("teams_count", len(conversations.Teams)).Debug("teams tree nodes prepared")
 
-	chats := append([]csa.Chat(nil), s.conversations.Chats...)
-	chats = ensurePrivateNotesChat(chats, s.conversations.PrivateFeeds)
+	chats := append([]csa.Chat(nil), conversations.Chats...)
+	chats = ensurePrivateNotesChat(chats, conversations.PrivateFeeds)
 	sort.Slice(chats, func(i, j int) bool {
 		ti := chatLastActivity(chats[i])
 		tj := chatLastActivity(chats[j])
@@ -576,7 +867,7 @@ func (s *AppState) fillMainWindow() {
 			continue
 		}
 		chatName := buildChatDisplayName(chat, s.me)
-		candidateIDs := candidateConversationIds(chat, s.conversations.PrivateFeeds)
+		candidateIDs := candidateConversationIds(chat, conversations.PrivateFeeds)
 		chatKey := chatFavoriteKey(chat.Id, candidateIDs)
 		chatName = s.chatDisplayNameForKey(chatKey, chatName)
 		s.logger.WithFields(logrus.Fields{
@@ -588,28 +879,14 @@ func (s *AppState) fillMainWindow() {
 			"last_container":  chat.LastMessage.ContainerId,
 			"last_message_id": chat.LastMessage.Id,
 		}).Debug("prepared chat node")
-		chatNode := tview.NewTreeNode(chatName)
-		chatNode.SetColor(tcell.ColorGreen)
-		isFavorite := s.chatIsFavorite(chat, chatKey)
-		isUnread := !chat.IsRead
-		if override, ok := s.getManualUnreadOverride(chatKey); ok {
-			isUnread = override
-		}
-		chatNode.SetText(formatChatTreeTitle(chatName, isUnread))
-		chatNode.SetReference(conversationRef{
-			ids:        candidateIDs,
-			title:      chatName,
-			chatKey:    chatKey,
-			isFavorite: isFavorite,
-			isUnread:   isUnread,
-		})
+		chatNode := s.newChatNode(chat, chatKey, candidateIDs, chatName)
 		if firstNode == nil {
 			firstNode = chatNode
 		}
 		if mostRecentChatNode == nil {
 			mostRecentChatNode = chatNode
 		}
-		if isFavorite {
+		if s.chatIsFavorite(chat, chatKey) {
 			favoritesNode.AddChild(chatNode)
 			continue
 		}
@@ -632,74 +909,14 @@ func (s *AppState) fillMainWindow() {
 		isUnread:   false,
 	})
 	rootNode.AddChild(settingsNode)
+	refreshTreeUnreadLabels(rootNode)
 	s.logger.WithFields(logrus.Fields{
 		"chat_nodes_count":      len(favoritesNode.GetChildren()) + len(recentNode.GetChildren()),
 		"favorites_nodes_count": len(favoritesNode.GetChildren()),
 		"recent_nodes_count":    len(recentNode.GetChildren()),
 	}).Debug("chat tree nodes prepared")
 
-	treeView.SetSelectedFunc(func(node *tview.TreeNode) {
-		defer func() {
-			if recovered := recover(); recovered != nil {
-				s.logger.WithFields(logrus.Fields{
-					"panic": recovered,
-					"stack": string(debug.Stack()),
-				}).Error("panic in tree selected handler")
-			}
-		}()
-
-		s.logger.WithField("node_text", node.GetText()).Debug("tree node selected")
-		reference := node.GetReference()
-		if reference == nil {
-			node.SetExpanded(!node.IsExpanded())
-			return
-		}
-
-		children := node.GetChildren()
-		if len(children) > 0 {
-			// Collapse if visible, expand if collapsed.
-			node.SetExpanded(!node.IsExpanded())
-			return
-		}
-
-		switch ref := reference.(type) {
-		case csa.Channel:
-			channelRef := ref
-			s.logger.WithFields(logrus.Fields{
-				"target":          "team-channel",
-				"display_name":    channelRef.DisplayName,
-				"conversation_id": channelRef.Id,
-			}).Info("loading conversation")
-			s.components[ViChat].(*tview.List).
-				SetTitle(channelRef.DisplayName).
-				SetBorder(true).
-				SetTitleAlign(tview.AlignCenter)
-			s.setActiveConversation(node, []string{channelRef.Id}, channelRef.DisplayName)
-			go s.loadConversations(&channelRef)
-		case conversationRef:
-			if ref.chatKey == settingsHelpChatKey {
-				s.showSettingsHelpChat(ref.title)
-				return
-			}
-			s.logger.WithFields(logrus.Fields{
-				"target":        "chat",
-				"display_name":  ref.title,
-				"candidate_ids": strings.Join(ref.ids, ","),
-			}).Info("loading conversation")
-			if ref.isUnread {
-				ref.isUnread = false
-				s.setManualUnread(ref.chatKey, false)
-				node.SetText(formatChatTreeTitle(ref.title, false))
-				node.SetReference(ref)
-			}
-			s.components[ViChat].(*tview.List).
-				SetTitle(ref.title).
-				SetBorder(true).
-				SetTitleAlign(tview.AlignCenter)
-			s.setActiveConversation(node, ref.ids, ref.title)
-			go s.loadConversationsByIDs(node, ref.ids, ref.title)
-		}
-	})
+	treeView.SetSelectedFunc(s.handleTreeNodeSelected)
 	treeView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		defer func() {
 			if recovered := recover(); recovered != nil {
@@ -710,77 +927,7 @@ func (s *AppState) fillMainWindow() {
 			}
 		}()
 
-		if s.bindingMatches(actionMoveDown, event) {
-			return tcell.NewEventKey(tcell.KeyDown, 0, event.Modifiers())
-		}
-		if s.bindingMatches(actionMoveUp, event) {
-			return tcell.NewEventKey(tcell.KeyUp, 0, event.Modifiers())
-		}
-		if s.bindingMatches(actionToggleScan, event) {
-			enabled := s.toggleUnreadScanEnabled()
-			s.logger.WithField("enabled", enabled).Info("unread scan toggle changed")
-			s.updateScanStatusTitle()
-			composeView.SetTitle(s.composeTitleWithScanStatus())
-			return nil
-		}
-		if s.bindingMatches(actionScanNow, event) {
-			if s.markUnreadScanStart() {
-				composeView.SetTitle(s.composeTitleWithScanStatus())
-				go s.refreshUnreadMarkers(chatsNode)
-			} else {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Scan already running")
-			}
-			return nil
-		}
-		if s.bindingMatches(actionMarkUnread, event) {
-			selected := treeView.GetCurrentNode()
-			if selected == nil {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Select a chat first")
-				return event
-			}
-			ref, ok := selected.GetReference().(conversationRef)
-			if !ok || strings.TrimSpace(ref.chatKey) == "" || ref.chatKey == settingsHelpChatKey {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Select a chat first")
-				return event
-			}
-			ref.isUnread = true
-			s.setManualUnread(ref.chatKey, true)
-			selected.SetText(formatChatTreeTitle(ref.title, true))
-			selected.SetReference(ref)
-			composeView.SetTitle(s.composeTitleWithScanStatus() + " | Marked unread")
-			s.logger.WithFields(logrus.Fields{
-				"chat_key": ref.chatKey,
-				"title":    ref.title,
-			}).Debug("marked chat unread manually")
-			return nil
-		}
-		if s.bindingMatches(actionToggleFavorite, event) {
-			if s.toggleFavoriteForCurrentNode(treeView, chatsNode, favoritesNode, recentNode) {
-				return nil
-			}
-			return event
-		}
-		if s.bindingMatches(actionRefreshTitles, event) {
-			go s.refreshAllChatLabels(chatsNode)
-			return nil
-		}
-		if s.bindingMatches(actionReloadKeybinds, event) {
-			if err := s.reloadKeybindingsConfig(); err != nil {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybind reload failed")
-			} else {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybindings reloaded")
-			}
-			return nil
-		}
-		if s.bindingMatches(actionFocusCompose, event) {
-			ids, _, _ := s.getActiveConversation()
-			if len(ids) == 0 {
-				return event
-			}
-			s.app.SetFocus(composeView)
-			return nil
-		}
-		return event
+		return s.dispatchPaneAction(scopeTree, event)
 	})
 	chatView := s.components[ViChat].(*tview.List)
 	chatView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
@@ -794,17 +941,11 @@ func (s *AppState) fillMainWindow() {
 		}()
 
 		if s.isSettingsMode() {
-			if s.bindingMatches(actionMoveDown, event) {
-				return tcell.NewEventKey(tcell.KeyDown, 0, event.Modifiers())
-			}
-			if s.bindingMatches(actionMoveUp, event) {
-				return tcell.NewEventKey(tcell.KeyUp, 0, event.Modifiers())
-			}
-			captureAction := s.getSettingsCaptureAction()
+			captureScope, captureAction := s.getSettingsCaptureAction()
 			if captureAction != "" {
 				composeView := s.components[ViCompose].(*tview.InputField)
 				if event.Key() == tcell.KeyEscape {
-					if err := s.resetActionBindingToDefault(captureAction); err != nil {
+					if err := s.resetActionBindingToDefault(captureScope, captureAction); err != nil {
 						composeView.SetTitle(s.composeTitleWithScanStatus() + " | Reset failed")
 					} else {
 						composeView.SetTitle(s.composeTitleWithScanStatus() + " | Reset to preset default")
@@ -818,84 +959,21 @@ func (s *AppState) fillMainWindow() {
 					composeView.SetTitle(s.composeTitleWithScanStatus() + " | Unsupported key (use config for complex)")
 					return nil
 				}
-				if err := s.setActionBinding(captureAction, token); err != nil {
-					composeView.SetTitle(s.composeTitleWithScanStatus() + " | Save binding failed")
+				if err := s.setActionBinding(captureScope, captureAction, token); err != nil {
+					composeView.SetTitle(s.composeTitleWithScanStatus() + " | " + err.Error())
 				} else {
-					composeView.SetTitle(s.composeTitleWithScanStatus() + " | Bound " + captureAction + " -> " + token)
+					composeView.SetTitle(s.composeTitleWithScanStatus() + " | Bound " + captureScope + " " + captureAction + " -> " + token)
 				}
 				s.clearSettingsCaptureAction()
 				s.renderSettingsHelpItems(chatView)
 				return nil
 			}
-			if s.bindingMatches(actionReloadKeybinds, event) {
-				if err := s.reloadKeybindingsConfig(); err != nil {
-					composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybind reload failed")
-				} else {
-					composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybindings reloaded")
-				}
-				s.renderSettingsHelpItems(chatView)
-				return nil
-			}
-			return event
-		}
-
-		if s.bindingMatches(actionMoveDown, event) {
-			return tcell.NewEventKey(tcell.KeyDown, 0, event.Modifiers())
-		}
-		if s.bindingMatches(actionMoveUp, event) {
-			return tcell.NewEventKey(tcell.KeyUp, 0, event.Modifiers())
+			return s.dispatchPaneAction(scopeSettings, event)
 		}
 
-		if s.bindingMatches(actionReplyMessage, event) {
-			current := chatView.GetCurrentItem()
-			if current < 0 {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Select a message first")
-				return nil
-			}
-			msg, ok := s.getCurrentChatMessage(current)
-			if !ok {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Select a message first")
-				return nil
-			}
-			author := strings.TrimSpace(msg.ImDisplayName)
-			if author == "" {
-				author = inferMessageAuthor(msg, s.me)
-			}
-			reply := &replyTarget{
-				MessageID: strings.TrimSpace(msg.Id),
-				Author:    author,
-				Preview:   summarizeReplyPreview(msg.Content),
-			}
-			s.setPendingReply(reply)
-			s.updateComposeReplyUI()
-			s.app.SetFocus(composeView)
-			return nil
-		}
-		if s.bindingMatches(actionReactMessage, event) {
-			current := chatView.GetCurrentItem()
-			if current < 0 {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Select a message first")
-				return nil
-			}
-			msg, ok := s.getCurrentChatMessage(current)
-			if !ok || strings.TrimSpace(msg.Id) == "" || strings.TrimSpace(msg.ConversationId) == "" {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Select a message first")
-				return nil
-			}
-			go s.reactToMessage(msg, defaultReactionKey)
-			composeView.SetTitle(s.composeTitleWithScanStatus() + " | Reacted 👍")
-			return nil
-		}
-		if s.bindingMatches(actionReloadKeybinds, event) {
-			if err := s.reloadKeybindingsConfig(); err != nil {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybind reload failed")
-			} else {
-				composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybindings reloaded")
-			}
-			return nil
-		}
-		return event
+		return s.dispatchPaneAction(scopeChat, event)
 	})
+	s.bindMembersPane()
 	composeView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event == nil {
 			return event
@@ -952,6 +1030,20 @@ func (s *AppState) fillMainWindow() {
 		if messageText == "" {
 			return
 		}
+		if isSlashCommand(messageText) {
+			composeView.SetText("")
+			if err := s.runSlashCommand(messageText); err != nil {
+				if composeView.GetText() == "" {
+					composeView.SetText(messageText)
+				}
+				s.setComposeStatus(err.Error())
+			}
+			return
+		}
+		if strings.HasPrefix(messageText, "//") {
+			messageText = messageText[1:]
+		}
+		messageText = expandComposeNewlines(messageText)
 		ids, title, selectedNode := s.getActiveConversation()
 		if len(ids) == 0 {
 			s.showError(fmt.Errorf("select a conversation before sending a message"))
@@ -966,7 +1058,20 @@ func (s *AppState) fillMainWindow() {
 	})
 
 	treeView.SetRoot(rootNode)
-	if mostRecentChatNode != nil {
+	s.tree = conversationTree{
+		root:         rootNode,
+		teams:        teamsNode,
+		chats:        chatsNode,
+		favorites:    favoritesNode,
+		recent:       recentNode,
+		teamNodes:    teamNodes,
+		channelNodes: channelNodes,
+	}
+	s.renderTeams()
+	s.renderPinnedChannels()
+	s.renderFavoriteChannels()
+	s.renderFolders()
+	if mostRecentChatNode != nil && s.startupLink == "" {
 		treeView.SetCurrentNode(mostRecentChatNode)
 		if ref, ok := mostRecentChatNode.GetReference().(conversationRef); ok {
 			s.components[ViChat].(*tview.List).
@@ -978,17 +1083,104 @@ func (s *AppState) fillMainWindow() {
 		}
 	} else if firstNode != nil {
 		treeView.SetCurrentNode(firstNode)
+		s.keepSelectionAttached()
 	} else {
 		treeView.SetCurrentNode(rootNode)
 	}
+	s.renderTreeView()
 
 	s.pages.SwitchToPage(PageMain)
 	s.app.SetFocus(treeView)
+	if s.keybindParseErr != nil {
+		s.setComposeStatus("Keybinding errors, see Settings & Help")
+	}
+	if s.startupLink != "" {
+		if err := s.openDeepLink(s.startupLink); err != nil {
+			s.setComposeStatus("Open link: " + err.Error())
+		}
+	}
 	s.app.Draw()
-	s.startUnreadScanLoop(chatsNode)
+	s.startUnreadScanLoop(rootNode)
+	s.startPresenceLoop()
+	s.startAccountUnreadLoop()
+	s.startTokenManager()
+	s.updateAccountBadge()
+	if s.isUnreadScanEnabled() && s.markUnreadScanStart() {
+		go s.refreshUnreadMarkers(rootNode)
+	}
 	s.logger.Info("main window ready")
 }
 
+func (s *AppState) handleTreeNodeSelected(node *tview.TreeNode) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.logger.WithFields(logrus.Fields{
+				"panic": recovered,
+				"stack": string(debug.Stack()),
+			}).Error("panic in tree selected handler")
+		}
+	}()
+
+	s.logger.WithField("node_text", node.GetText()).Debug("tree node selected")
+	reference := node.GetReference()
+	if reference == nil {
+		node.SetExpanded(!node.IsExpanded())
+		return
+	}
+
+	children := node.GetChildren()
+	if len(children) > 0 {
+		// Collapse if visible, expand if collapsed.
+		node.SetExpanded(!node.IsExpanded())
+		if group, ok := reference.(groupRef); ok && group.folder && s.tree.view == nil {
+			s.setFolderCollapsed(group.name, !node.IsExpanded())
+		}
+		return
+	}
+
+	switch ref := reference.(type) {
+	case channelRef:
+		channel := ref.channel
+		s.logger.WithFields(logrus.Fields{
+			"target":          "team-channel",
+			"display_name":    channel.DisplayName,
+			"conversation_id": channel.Id,
+		}).Info("loading conversation")
+		s.components[ViChat].(*tview.List).
+			SetTitle(channel.DisplayName).
+			SetBorder(true).
+			SetTitleAlign(tview.AlignCenter)
+		s.setActiveConversation(node, []string{channel.Id}, channel.DisplayName)
+		go s.loadConversations(node, &channel)
+	case conversationRef:
+		if ref.chatKey == settingsHelpChatKey {
+			s.showSettingsHelpChat(ref.title)
+			return
+		}
+		s.logger.WithFields(logrus.Fields{
+			"target":        "chat",
+			"display_name":  ref.title,
+			"candidate_ids": strings.Join(ref.ids, ","),
+		}).Info("loading conversation")
+		if ref.isUnread {
+			ref.isUnread = false
+			ref.unreadCount = 0
+			ref.mentionCount = 0
+			s.setManualUnread(ref.chatKey, false)
+			node.SetText(ref.treeTitle())
+			node.SetReference(ref)
+			s.syncConversationCopies(node)
+			refreshTreeUnreadLabels(s.components[TrChat].(*tview.TreeView).GetRoot())
+		}
+		s.components[ViChat].(*tview.List).
+			SetTitle(ref.title).
+			SetBorder(true).
+			SetTitleAlign(tview.AlignCenter)
+		s.setActiveConversation(node, ref.ids, ref.title)
+		go s.openConversationByIDs(node, ref.ids, ref.title)
+	}
+}
+
 func (s *AppState) setActiveConversation(selectedNode *tview.TreeNode, conversationIDs []string, title string) {
 	ids := normalizeConversationIDs(conversationIDs)
 	s.activeConversationMu.Lock()
@@ -999,6 +1191,13 @@ func (s *AppState) setActiveConversation(selectedNode *tview.TreeNode, conversat
 	s.setSettingsMode(false)
 	s.clearPendingReply()
 	s.updateComposeReplyUI()
+	s.refreshMembersPane()
+}
+
+func (s *AppState) setActiveConversationTitle(title string) {
+	s.activeConversationMu.Lock()
+	s.activeConversationTitle = title
+	s.activeConversationMu.Unlock()
 }
 
 func (s *AppState) showSettingsHelpChat(title string) {
@@ -1036,6 +1235,8 @@ func (s *AppState) renderSettingsHelpItems(chatList *tview.List) {
 		switch item.kind {
 		case settingsItemSpacer:
 			chatList.AddItem(" ", "", 0, nil)
+		case settingsItemKeybindError:
+			chatList.AddItem("[red]Keybinding Errors[-]", s.keybindParseErr.Error(), 0, nil)
 		case settingsItemOpen:
 			chatList.AddItem("Open Keybindings Config", s.keybindPath+" (Enter)", 0, nil)
 		case settingsItemPreset:
@@ -1048,12 +1249,27 @@ func (s *AppState) renderSettingsHelpItems(chatList *tview.List) {
 			chatList.AddItem("Compose Color", s.formatComposeColorLine()+" (Enter to cycle)", 0, nil)
 		case settingsItemAuthorColor:
 			chatList.AddItem("Username Color", s.formatAuthorColorLine()+" (Enter to cycle)", 0, nil)
+		case settingsItemTreeView:
+			chatList.AddItem("Tree View", s.formatTreeViewModeLine()+" (Enter to cycle)", 0, nil)
+		case settingsItemActiveDays:
+			chatList.AddItem("Active Days", s.formatActiveDaysLine()+" (Enter to cycle)", 0, nil)
 		case settingsItemReload:
 			chatList.AddItem("Reload Keybindings", "Reload from config file (Enter/Ctrl+R)", 0, nil)
+		case settingsItemScope:
+			scope, _ := findKeybindingScope(item.scope)
+			chatList.AddItem("[::b]"+scope.title+" bindings[::-]", "scope \""+scope.name+"\"", 0, nil)
 		case settingsItemBinding:
-			chatList.AddItem("Bind "+item.action, s.formatActionBindingLine(item.action)+" (Enter to rebind)", 0, nil)
+			label := "Bind " + item.action
+			if s.hasKeybindConflict(item.scope, item.action) {
+				label = "[red]" + label + " (conflict)[-]"
+			}
+			line := s.formatActionBindingLine(item.scope, item.action)
+			if s.hasScopeOverride(item.scope, item.action) {
+				line += " [" + item.scope + " only]"
+			}
+			chatList.AddItem(label, line+" (Enter to rebind)", 0, nil)
 		default:
-			chatList.AddItem("Help", "Esc in bind mode resets that action to preset default", 0, nil)
+			chatList.AddItem("Help", "Esc in bind mode resets that action to its default in the scope", 0, nil)
 		}
 	}
 	if selection >= 0 && selection < chatList.GetItemCount() {
@@ -1062,7 +1278,11 @@ func (s *AppState) renderSettingsHelpItems(chatList *tview.List) {
 }
 
 func (s *AppState) buildSettingsItems() []settingsItem {
-	return []settingsItem{
+	items := []settingsItem{}
+	if s.keybindParseErr != nil {
+		items = append(items, settingsItem{kind: settingsItemKeybindError})
+	}
+	items = append(items, []settingsItem{
 		{kind: settingsItemOpen},
 		{kind: settingsItemPreset},
 		{kind: settingsItemSpacer},
@@ -1070,23 +1290,18 @@ func (s *AppState) buildSettingsItems() []settingsItem {
 		{kind: settingsItemWrapPct},
 		{kind: settingsItemComposeColor},
 		{kind: settingsItemAuthorColor},
+		{kind: settingsItemTreeView},
+		{kind: settingsItemActiveDays},
 		{kind: settingsItemSpacer},
 		{kind: settingsItemReload},
-		{kind: settingsItemSpacer},
-		{kind: settingsItemBinding, action: actionMoveDown},
-		{kind: settingsItemBinding, action: actionMoveUp},
-		{kind: settingsItemBinding, action: actionFocusCompose},
-		{kind: settingsItemBinding, action: actionToggleFavorite},
-		{kind: settingsItemBinding, action: actionMarkUnread},
-		{kind: settingsItemBinding, action: actionReplyMessage},
-		{kind: settingsItemBinding, action: actionReactMessage},
-		{kind: settingsItemBinding, action: actionRefreshTitles},
-		{kind: settingsItemBinding, action: actionToggleScan},
-		{kind: settingsItemBinding, action: actionScanNow},
-		{kind: settingsItemBinding, action: actionReloadKeybinds},
-		{kind: settingsItemSpacer},
-		{kind: settingsItemInfo},
+	}...)
+	for _, scope := range keybindingScopes {
+		items = append(items, settingsItem{kind: settingsItemSpacer}, settingsItem{kind: settingsItemScope, scope: scope.name})
+		for _, action := range scope.actions {
+			items = append(items, settingsItem{kind: settingsItemBinding, scope: scope.name, action: action})
+		}
 	}
+	return append(items, settingsItem{kind: settingsItemSpacer}, settingsItem{kind: settingsItemInfo})
 }
 
 func (s *AppState) handleSettingsSelection(index int) {
@@ -1098,7 +1313,7 @@ func (s *AppState) handleSettingsSelection(index int) {
 	item := items[index]
 	composeView := s.components[ViCompose].(*tview.InputField)
 	switch item.kind {
-	case settingsItemSpacer, settingsItemInfo:
+	case settingsItemSpacer, settingsItemInfo, settingsItemKeybindError, settingsItemScope:
 		return
 	case settingsItemOpen:
 		err := s.openKeybindConfigInEditor()
@@ -1119,7 +1334,7 @@ func (s *AppState) handleSettingsSelection(index int) {
 		s.renderSettingsHelpItems(s.components[ViChat].(*tview.List))
 	case settingsItemReload:
 		if err := s.reloadKeybindingsConfig(); err != nil {
-			composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybind reload failed")
+			composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybind reload: " + err.Error())
 		} else {
 			composeView.SetTitle(s.composeTitleWithScanStatus() + " | Keybindings reloaded")
 		}
@@ -1164,11 +1379,20 @@ func (s *AppState) handleSettingsSelection(index int) {
 		s.rerenderActiveChatMessages()
 		composeView.SetTitle(s.composeTitleWithScanStatus() + " | Usernames: " + s.formatAuthorColorLine())
 		s.renderSettingsHelpItems(s.components[ViChat].(*tview.List))
+	case settingsItemTreeView:
+		s.cycleTreeViewMode()
+		composeView.SetTitle(s.composeTitleWithScanStatus() + " | View: " + s.formatTreeViewModeLine())
+		s.renderSettingsHelpItems(s.components[ViChat].(*tview.List))
+	case settingsItemActiveDays:
+		s.cycleActiveDays()
+		composeView.SetTitle(s.composeTitleWithScanStatus() + " | Active days: " + s.formatActiveDaysLine())
+		s.renderSettingsHelpItems(s.components[ViChat].(*tview.List))
 	case settingsItemBinding:
 		s.settingsMu.Lock()
+		s.settingsCaptureScope = item.scope
 		s.settingsCaptureAction = item.action
 		s.settingsMu.Unlock()
-		composeView.SetTitle(s.composeTitleWithScanStatus() + " | Press new key or Esc for default")
+		composeView.SetTitle(s.composeTitleWithScanStatus() + " | Press new " + item.scope + " key or Esc for default")
 	}
 }
 
@@ -1203,9 +1427,9 @@ func (s *AppState) formatPresetLine() string {
 	return strings.Join(parts, " ")
 }
 
-func (s *AppState) formatActionBindingLine(action string) string {
+func (s *AppState) formatActionBindingLine(scope, action string) string {
 	s.keybindMu.RLock()
-	keys := append([]string(nil), s.keybindings[action]...)
+	keys := append([]string(nil), s.keybindings[scope][action]...)
 	s.keybindMu.RUnlock()
 	if len(keys) == 0 {
 		return "(none)"
@@ -1450,8 +1674,8 @@ func (s *AppState) promptCustomWrapChars(onDone func(value int, ok bool)) {
 	s.app.SetFocus(input)
 }
 
-func (s *AppState) formatChatMessageText(content string) string {
-	txt := textMessage(content)
+func (s *AppState) formatChatMessageText(message csa.ChatMessage) string {
+	txt := renderMessageText(message)
 	if s.isChatWordWrap() {
 		return txt
 	}
@@ -1529,14 +1753,16 @@ func (s *AppState) setSettingsSelection(index int) {
 	s.settingsMu.Unlock()
 }
 
-func (s *AppState) getSettingsCaptureAction() string {
+// getSettingsCaptureAction returns the scope and action waiting for a new key.
+func (s *AppState) getSettingsCaptureAction() (string, string) {
 	s.settingsMu.RLock()
 	defer s.settingsMu.RUnlock()
-	return s.settingsCaptureAction
+	return s.settingsCaptureScope, s.settingsCaptureAction
 }
 
 func (s *AppState) clearSettingsCaptureAction() {
 	s.settingsMu.Lock()
+	s.settingsCaptureScope = ""
 	s.settingsCaptureAction = ""
 	s.settingsMu.Unlock()
 }
@@ -1569,36 +1795,7 @@ func eventToKeybindToken(event *tcell.EventKey) string {
 	if event == nil {
 		return ""
 	}
-	switch event.Key() {
-	case tcell.KeyUp:
-		return "up"
-	case tcell.KeyDown:
-		return "down"
-	case tcell.KeyLeft:
-		return "left"
-	case tcell.KeyRight:
-		return "right"
-	case tcell.KeyEnter:
-		return "enter"
-	case tcell.KeyEscape:
-		return "esc"
-	case tcell.KeyCtrlN:
-		return "ctrl+n"
-	case tcell.KeyCtrlP:
-		return "ctrl+p"
-	case tcell.KeyCtrlX:
-		return "ctrl+x"
-	case tcell.KeyCtrlR:
-		return "ctrl+r"
-	case tcell.KeyRune:
-		r := event.Rune()
-		if r == 0 {
-			return ""
-		}
-		return string(r)
-	default:
-		return ""
-	}
+	return eventToKeyStroke(event).String()
 }
 
 func (s *AppState) getActiveConversation() ([]string, string, *tview.TreeNode) {
@@ -1653,6 +1850,69 @@ func formatChatTreeTitle(title string, unread bool) string {
 	return "● " + title
 }
 
+// formatUnreadTreeTitle adds the unread marker plus message and mention badges,
+// e.g. "● Design (3) @1". Counts are omitted when they are not known.
+func formatUnreadTreeTitle(title string, unread bool, unreadCount, mentionCount int) string {
+	out := formatChatTreeTitle(title, unread)
+	if !unread {
+		return out
+	}
+	if unreadCount > 0 {
+		out += fmt.Sprintf(" (%d)", unreadCount)
+	}
+	if mentionCount > 0 {
+		out += fmt.Sprintf(" @%d", mentionCount)
+	}
+	return out
+}
+
+// refreshTreeUnreadLabels aggregates unread badges of conversations onto their
+// enclosing team and group nodes. Unread conversations without a known message
+// count contribute one message so that groups never hide activity.
+func refreshTreeUnreadLabels(root *tview.TreeNode) {
+	if root == nil {
+		return
+	}
+	var visit func(node *tview.TreeNode) (int, int, int)
+	visit = func(node *tview.TreeNode) (int, int, int) {
+		switch ref := node.GetReference().(type) {
+		case conversationRef:
+			return unreadContribution(ref.isUnread && !ref.isMuted, ref.unreadCount, ref.mentionCount)
+		case channelRef:
+			return unreadContribution(ref.isUnread && !ref.isMuted, ref.unreadCount, ref.mentionCount)
+		}
+		conversations, messages, mentions := 0, 0, 0
+		for _, child := range node.GetChildren() {
+			c, m, at := visit(child)
+			conversations += c
+			messages += m
+			mentions += at
+		}
+		name := ""
+		switch ref := node.GetReference().(type) {
+		case groupRef:
+			name = ref.name
+		case csa.Team:
+			name = ref.DisplayName
+		}
+		if name != "" {
+			node.SetText(formatUnreadTreeTitle(name, conversations > 0, messages, mentions))
+		}
+		return conversations, messages, mentions
+	}
+	visit(root)
+}
+
+func unreadContribution(unread bool, unreadCount, mentionCount int) (int, int, int) {
+	if !unread {
+		return 0, 0, 0
+	}
+	if unreadCount <= 0 {
+		unreadCount = 1
+	}
+	return 1, unreadCount, mentionCount
+}
+
 func (s *AppState) chatIsFavorite(chat csa.Chat, chatKey string) bool {
 	key := normalizeFavoriteKey(chatKey)
 	if key != "" {
@@ -1737,13 +1997,29 @@ func (s *AppState) toggleFavoriteForCurrentNode(treeView *tview.TreeView, chatsN
 	if selected == nil {
 		return false
 	}
+	if channel, ok := selected.GetReference().(channelRef); ok {
+		s.toggleChannelFavorite(treeView, selected, channel)
+		return true
+	}
 	ref, ok := selected.GetReference().(conversationRef)
 	if !ok || strings.TrimSpace(ref.chatKey) == "" {
 		return false
 	}
+	// A chat in a user folder is a copy; the chat under Favorites or Recent
+	// moves and the copy stays selected.
+	source := selected
+	if ref.folder != "" {
+		if source = s.chatSourceNode(conversationNodeKey(selected)); source == nil {
+			return false
+		}
+		ref = source.GetReference().(conversationRef)
+	}
 	ref.isFavorite = s.toggleChatFavorite(ref.chatKey, ref.isFavorite)
-	selected.SetReference(ref)
-	moveChatNodeToGroup(chatsNode, favoritesNode, recentNode, selected, ref.isFavorite)
+	source.SetReference(ref)
+	moveChatNodeToGroup(chatsNode, favoritesNode, recentNode, source, ref.isFavorite)
+	s.syncConversationCopies(source)
+	refreshTreeUnreadLabels(treeView.GetRoot())
+	s.refreshTreeView()
 	treeView.SetCurrentNode(selected)
 	return true
 }
@@ -1799,6 +2075,52 @@ func chatLastActivity(chat csa.Chat) time.Time {
 	return time.Time{}
 }
 
+func channelLastActivity(channel csa.Channel) time.Time {
+	compose := time.Time(channel.LastMessage.ComposeTime)
+	if !compose.IsZero() {
+		return compose
+	}
+	return time.Time(channel.LastMessage.OriginalArrivalTime)
+}
+
+func consumptionHorizonTime(horizon csa.ConsumptionHorizon) time.Time {
+	if horizon.OriginalArrivalTime > 0 {
+		return time.UnixMilli(int64(horizon.OriginalArrivalTime))
+	}
+	if horizon.TimeStamp > 0 {
+		return time.UnixMilli(int64(horizon.TimeStamp))
+	}
+	return time.Time{}
+}
+
+func latestConsumptionHorizon(horizons ...csa.ConsumptionHorizon) time.Time {
+	latest := time.Time{}
+	for _, horizon := range horizons {
+		if t := consumptionHorizonTime(horizon); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// channelIsUnread compares the channel's last message with the newest known
+// consumption horizon, falling back to the server read flag when neither is set.
+func channelIsUnread(channel csa.Channel) bool {
+	last := channelLastActivity(channel)
+	if last.IsZero() {
+		return false
+	}
+	horizons := []csa.ConsumptionHorizon{channel.UserConsumptionHorizon}
+	if channel.ConsumptionHorizon != nil {
+		horizons = append(horizons, *channel.ConsumptionHorizon)
+	}
+	horizon := latestConsumptionHorizon(horizons...)
+	if horizon.IsZero() {
+		return !channel.IsMessageRead
+	}
+	return last.After(horizon)
+}
+
 func isSelfDisplayName(name string, me *models.User) bool {
 	if me == nil {
 		return false
@@ -1912,7 +2234,7 @@ func (s *AppState) refreshAllChatLabels(chatsNode *tview.TreeNode) {
 		if s.setChatTitle(ref.chatKey, title) {
 			titlesChanged = true
 		}
-		displayTitle := formatChatTreeTitle(ref.title, ref.isUnread)
+		displayTitle := ref.treeTitle()
 		s.app.QueueUpdateDraw(func() {
 			node.SetText(displayTitle)
 			node.SetReference(ref)
@@ -1928,8 +2250,8 @@ func (s *AppState) refreshAllChatLabels(chatsNode *tview.TreeNode) {
 	}).Info("finished refreshing chat titles")
 }
 
-func (s *AppState) startUnreadScanLoop(chatsNode *tview.TreeNode) {
-	if chatsNode == nil {
+func (s *AppState) startUnreadScanLoop(rootNode *tview.TreeNode) {
+	if rootNode == nil {
 		return
 	}
 	interval := s.unreadScanInterval
@@ -1949,7 +2271,7 @@ func (s *AppState) startUnreadScanLoop(chatsNode *tview.TreeNode) {
 					continue
 				}
 				if s.markUnreadScanStart() {
-					s.refreshUnreadMarkers(chatsNode)
+					s.refreshUnreadMarkers(rootNode)
 				}
 			case <-s.unreadScanStop:
 				return
@@ -1970,6 +2292,12 @@ func (s *AppState) composeTitleWithScanStatus() string {
 	if enabled {
 		status = "ON"
 	}
+	if mode := s.editModeLabel(); mode != "" {
+		status += " | " + mode
+	}
+	if token := s.tokenStatus(); token != "" {
+		status += " | " + token
+	}
 	replySuffix := ""
 	if reply := s.getPendingReply(); reply != nil {
 		replySuffix = " | Reply: " + strings.TrimSpace(reply.Author)
@@ -2046,7 +2374,7 @@ func (s *AppState) markUnreadScanDone(changes int) {
 	s.unreadScanMu.Unlock()
 }
 
-func (s *AppState) refreshUnreadMarkers(chatsNode *tview.TreeNode) {
+func (s *AppState) refreshUnreadMarkers(rootNode *tview.TreeNode) {
 	defer func() {
 		if recovered := recover(); recovered != nil {
 			s.logger.WithFields(logrus.Fields{
@@ -2072,9 +2400,10 @@ func (s *AppState) refreshUnreadMarkers(chatsNode *tview.TreeNode) {
 	}
 
 	chats := ensurePrivateNotesChat(conversations.Chats, conversations.PrivateFeeds)
-	unreadByKey := map[string]bool{}
+	unreadByKey := map[string]unreadState{}
 	for _, chat := range chats {
-		key := chatFavoriteKey(chat.Id, candidateConversationIds(chat, conversations.PrivateFeeds))
+		candidateIDs := candidateConversationIds(chat, conversations.PrivateFeeds)
+		key := chatFavoriteKey(chat.Id, candidateIDs)
 		if key == "" {
 			continue
 		}
@@ -2086,28 +2415,82 @@ func (s *AppState) refreshUnreadMarkers(chatsNode *tview.TreeNode) {
 				s.clearManualUnreadOverride(key)
 			}
 		}
-		unreadByKey[key] = unread
+		state := unreadState{unread: unread}
+		if unread && !chat.IsRead {
+			horizon := latestConsumptionHorizon(chat.ConsumptionHorizon, chat.UserConsumptionHorizon)
+			state.count, state.mentions = s.unreadMessageStats(key, buildChatDisplayName(chat, s.me), candidateIDs, horizon, chat.LastMessage.Id)
+		}
+		unreadByKey[key] = state
+	}
+	channelUnreadByKey := map[string]unreadState{}
+	for _, team := range conversations.Teams {
+		for _, channel := range team.Channels {
+			key := normalizeFavoriteKey(channel.Id)
+			if key == "" {
+				continue
+			}
+			serverUnread := channelIsUnread(channel)
+			unread := serverUnread
+			if override, ok := s.getManualUnreadOverride(key); ok {
+				if override == unread {
+					s.clearManualUnreadOverride(key)
+				}
+				unread = override
+			}
+			state := unreadState{unread: unread}
+			if unread && serverUnread {
+				horizons := []csa.ConsumptionHorizon{channel.UserConsumptionHorizon}
+				if channel.ConsumptionHorizon != nil {
+					horizons = append(horizons, *channel.ConsumptionHorizon)
+				}
+				state.count, state.mentions = s.unreadMessageStats(key, channel.DisplayName, []string{channel.Id}, latestConsumptionHorizon(horizons...), channel.LastMessage.Id)
+			}
+			channelUnreadByKey[key] = state
+		}
 	}
 
 	s.app.QueueUpdateDraw(func() {
+		s.reconcileTree(conversations)
 		changed := 0
-		nodes := flattenConversationNodes(chatsNode)
+		nodes := flattenConversationNodes(rootNode)
 		for _, node := range nodes {
 			ref, ok := node.GetReference().(conversationRef)
 			if !ok || strings.TrimSpace(ref.chatKey) == "" {
 				continue
 			}
-			unread, exists := unreadByKey[normalizeFavoriteKey(ref.chatKey)]
-			if !exists || unread == ref.isUnread {
+			state, exists := unreadByKey[normalizeFavoriteKey(ref.chatKey)]
+			if !exists || state.matches(ref.isUnread, ref.unreadCount, ref.mentionCount) {
 				continue
 			}
-			ref.isUnread = unread
-			node.SetText(formatChatTreeTitle(ref.title, unread))
+			ref.isUnread = state.unread
+			ref.unreadCount = state.count
+			ref.mentionCount = state.mentions
+			node.SetText(ref.treeTitle())
 			node.SetReference(ref)
 			changed++
 		}
+		rootNode.Walk(func(node, parent *tview.TreeNode) bool {
+			ref, ok := node.GetReference().(channelRef)
+			if !ok {
+				return true
+			}
+			state, exists := channelUnreadByKey[normalizeFavoriteKey(ref.channel.Id)]
+			if !exists || state.matches(ref.isUnread, ref.unreadCount, ref.mentionCount) {
+				return true
+			}
+			ref.isUnread = state.unread
+			ref.unreadCount = state.count
+			ref.mentionCount = state.mentions
+			node.SetText(ref.treeTitle())
+			node.SetReference(ref)
+			changed++
+			return true
+		})
+		refreshTreeUnreadLabels(rootNode)
+		s.refreshTreeView()
 		s.markUnreadScanDone(changed)
 		s.updateScanStatusTitle()
+		s.updateAccountBadge()
 	})
 }
 
@@ -2150,6 +2533,7 @@ func (s *AppState) getManualUnreadOverride(chatKey string) (bool, bool) {
 
 func (s *AppState) setCurrentChatMessages(messages []csa.ChatMessage) {
 	copied := append([]csa.ChatMessage(nil), messages...)
+	s.resetVisualSelection()
 	s.chatMessagesMu.Lock()
 	s.chatMessages = copied
 	s.chatRowMap = nil
@@ -2299,7 +2683,8 @@ func flattenConversationNodes(root *tview.TreeNode) []*tview.TreeNode {
 
 	var nodes []*tview.TreeNode
 	for _, child := range root.GetChildren() {
-		if _, ok := child.GetReference().(conversationRef); ok {
+		switch child.GetReference().(type) {
+		case conversationRef, channelRef:
 			nodes = append(nodes, child)
 			continue
 		}
@@ -2394,6 +2779,9 @@ func extractConversationID(targetLink string) string {
 	if link == "" {
 		return ""
 	}
+	if deepLink, ok := parseTeamsDeepLink(link); ok {
+		return deepLink.conversationID
+	}
 	lower := strings.ToLower(link)
 
 	if start := strings.Index(lower, "/chat/"); start >= 0 {
@@ -2444,16 +2832,37 @@ func textMessage(input string) string {
 	return output
 }
 
-func (s *AppState) loadConversations(c *csa.Channel) {
-	s.loadConversationsByIDs(nil, []string{c.Id}, c.DisplayName)
-}
-
+func (s *AppState) loadConversations(selectedNode *tview.TreeNode, c *csa.Channel) {
+	s.openConversationByIDs(nil, []string{c.Id}, c.DisplayName)
+	if selectedNode == nil {
+		return
+	}
+	ref, ok := selectedNode.GetReference().(channelRef)
+	if !ok || !ref.isUnread {
+		return
+	}
+	s.setManualUnread(ref.channel.Id, false)
+	s.app.QueueUpdateDraw(func() {
+		ref.isUnread = false
+		ref.unreadCount = 0
+		ref.mentionCount = 0
+		selectedNode.SetText(ref.treeTitle())
+		selectedNode.SetReference(ref)
+		s.syncConversationCopies(selectedNode)
+		refreshTreeUnreadLabels(s.components[TrChat].(*tview.TreeView).GetRoot())
+	})
+}
+
 func (s *AppState) sendMessageAndRefresh(conversationIDs []string, displayName, content string, selectedNode *tview.TreeNode, reply *replyTarget) {
 	err := s.sendMessage(conversationIDs, content, reply)
 	if err != nil {
 		s.showError(err)
 		return
 	}
+	s.refreshAfterSend(conversationIDs, displayName, selectedNode)
+}
+
+func (s *AppState) refreshAfterSend(conversationIDs []string, displayName string, selectedNode *tview.TreeNode) {
 	// The send endpoint may acknowledge before the message is visible in reads.
 	for _, delay := range []time.Duration{0, 300 * time.Millisecond, 1 * time.Second, 2 * time.Second} {
 		if delay > 0 {
@@ -2497,9 +2906,21 @@ func (s *AppState) sendMessage(conversationIDs []string, content string, reply *
 			properties["mentions"] = string(mentionsJSON)
 		}
 	}
+	return s.postMessageHTML(ids, formatOutgoingHTML(mentionContent, reply), properties)
+}
 
+// postMessageHTML posts already formatted HTML content to the first conversation
+// id that accepts it.
+func (s *AppState) postMessageHTML(conversationIDs []string, content string, properties map[string]interface{}) error {
+	ids := normalizeConversationIDs(conversationIDs)
+	if len(ids) == 0 {
+		return fmt.Errorf("no conversation id available")
+	}
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
 	payload := map[string]interface{}{
-		"content":         formatOutgoingHTML(mentionContent, reply),
+		"content":         content,
 		"messagetype":     "RichText/Html",
 		"contenttype":     "text",
 		"clientmessageid": strconv.FormatInt(time.Now().UnixNano(), 10),
@@ -2513,59 +2934,14 @@ func (s *AppState) sendMessage(conversationIDs []string, content string, reply *
 
 	var lastErr error
 	for _, id := range ids {
-		for attempt := 0; attempt < 2; attempt++ {
-			endpoint := csa.MessagesHost + "v1/users/ME/conversations/" + url.QueryEscape(id) + "/messages"
-			req, err := s.teamsClient.ChatSvc().AuthenticatedRequest(http.MethodPost, endpoint, bytes.NewReader(bodyBytes))
-			if err != nil {
-				if attempt == 0 && isUnauthorizedError(err) {
-					if refreshErr := s.refreshAuthFromTeamsToken(); refreshErr == nil {
-						continue
-					} else {
-						lastErr = fmt.Errorf("unauthorized and unable to refresh auth: %v", refreshErr)
-						break
-					}
-				}
-				lastErr = err
-				break
-			}
-			req.Header.Add("Content-Type", "application/json")
-
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				lastErr = err
-				break
-			}
-
-			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
-				_, _ = io.ReadAll(resp.Body)
-				_ = resp.Body.Close()
-				s.logger.WithFields(logrus.Fields{
-					"conversation_id": id,
-					"status_code":     resp.StatusCode,
-				}).Info("message sent")
-				return nil
-			}
-			if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
-				_, _ = io.ReadAll(resp.Body)
-				_ = resp.Body.Close()
-				if refreshErr := s.refreshAuthFromTeamsToken(); refreshErr == nil {
-					continue
-				} else {
-					lastErr = fmt.Errorf("send unauthorized for %s and refresh failed: %v", id, refreshErr)
-					break
-				}
-			}
-
-			body, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			lastErr = fmt.Errorf("send failed for %s: status=%d body=%s", id, resp.StatusCode, strings.TrimSpace(string(body)))
-			s.logger.WithFields(logrus.Fields{
-				"conversation_id": id,
-				"status_code":     resp.StatusCode,
-				"response_body":   strings.TrimSpace(string(body)),
-			}).Warn("message send failed for conversation id")
-			break
+		endpoint := csa.MessagesHost + "v1/users/ME/conversations/" + url.QueryEscape(id) + "/messages"
+		if _, _, err := s.authenticatedRoundTrip(http.MethodPost, endpoint, bodyBytes); err != nil {
+			lastErr = fmt.Errorf("send failed for %s: %v", id, err)
+			s.logger.WithError(err).WithField("conversation_id", id).Warn("message send failed for conversation id")
+			continue
 		}
+		s.logger.WithField("conversation_id", id).Info("message sent")
+		return nil
 	}
 
 	if lastErr == nil {
@@ -2745,42 +3121,29 @@ func buildEmotionsPayload(message csa.ChatMessage, reaction, userMri string) (st
 }
 
 func (s *AppState) sendReactionRequest(method, endpoint string, body []byte) error {
-	var lastErr error
-	for attempt := 0; attempt < 2; attempt++ {
-		req, err := s.teamsClient.ChatSvc().AuthenticatedRequest(method, endpoint, bytes.NewReader(body))
-		if err != nil {
-			if attempt == 0 && isUnauthorizedError(err) {
-				if refreshErr := s.refreshAuthFromTeamsToken(); refreshErr == nil {
-					continue
-				}
-			}
-			return err
-		}
-		req.Header.Set("Content-Type", "application/json")
+	if _, _, err := s.authenticatedRoundTrip(method, endpoint, body); err != nil {
+		return fmt.Errorf("reaction request failed: %v", err)
+	}
+	return nil
+}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			lastErr = err
-			break
-		}
-		respBody, _ := io.ReadAll(resp.Body)
-		_ = resp.Body.Close()
+// chatServiceRequest sends a JSON request to the chat service with the retries
+// of authenticatedRoundTrip.
+func (s *AppState) chatServiceRequest(method, endpoint string, body []byte) error {
+	_, err := s.chatServiceRequestBody(method, endpoint, body)
+	return err
+}
 
-		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusNoContent {
-			return nil
-		}
-		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
-			if refreshErr := s.refreshAuthFromTeamsToken(); refreshErr == nil {
-				continue
-			}
-		}
-		lastErr = fmt.Errorf("%s %s status=%d body=%s", method, endpoint, resp.StatusCode, strings.TrimSpace(string(respBody)))
-		break
-	}
-	if lastErr == nil {
-		lastErr = fmt.Errorf("reaction request failed")
-	}
-	return lastErr
+// chatServiceRequestBody is chatServiceRequest that returns the response body.
+func (s *AppState) chatServiceRequestBody(method, endpoint string, body []byte) ([]byte, error) {
+	respBody, _, err := s.chatServiceRoundTrip(method, endpoint, body)
+	return respBody, err
+}
+
+// chatServiceRoundTrip is chatServiceRequestBody that also returns the response
+// headers, e.g. the Location of a created thread.
+func (s *AppState) chatServiceRoundTrip(method, endpoint string, body []byte) ([]byte, http.Header, error) {
+	return s.authenticatedRoundTrip(method, endpoint, body)
 }
 
 func normalizeConversationIDs(conversationIDs []string) []string {
@@ -3116,7 +3479,8 @@ func uniqueMentions(in []mentionCandidate) []mentionCandidate {
 }
 
 func (s *AppState) mentionCandidatesForConversation(conversationIDs []string) []mentionCandidate {
-	if s.conversations == nil {
+	conversations := s.conversationsSnapshot()
+	if conversations == nil {
 		return s.mentionCandidatesFromCurrentMessages()
 	}
 	ids := map[string]struct{}{}
@@ -3132,9 +3496,10 @@ func (s *AppState) mentionCandidatesForConversation(conversationIDs []string) []
 
 	candidates := []mentionCandidate{}
 	candidates = append(candidates, s.mentionCandidatesFromCurrentMessages()...)
-	for _, chat := range s.conversations.Chats {
+	candidates = append(candidates, s.memberMentionCandidates(conversationIDs)...)
+	for _, chat := range conversations.Chats {
 		matched := false
-		for _, cid := range candidateConversationIds(chat, s.conversations.PrivateFeeds) {
+		for _, cid := range candidateConversationIds(chat, conversations.PrivateFeeds) {
 			if _, ok := ids[normalizeFavoriteKey(cid)]; ok {
 				matched = true
 				break
@@ -3165,10 +3530,11 @@ func (s *AppState) mentionCandidatesGlobal() []mentionCandidate {
 	candidates := []mentionCandidate{}
 	candidates = append(candidates, s.getContactCandidates()...)
 	candidates = append(candidates, s.mentionCandidatesFromCurrentMessages()...)
-	if s.conversations == nil {
+	conversations := s.conversationsSnapshot()
+	if conversations == nil {
 		return uniqueMentions(candidates)
 	}
-	for _, chat := range s.conversations.Chats {
+	for _, chat := range conversations.Chats {
 		for _, member := range chat.Members {
 			if isCurrentUser(member, s.me) {
 				continue
@@ -3217,20 +3583,9 @@ func (s *AppState) fetchContactCandidatesFromAPI() ([]mentionCandidate, error) {
 	}
 	var lastErr error
 	for _, ep := range endpoints {
-		req, err := s.teamsClient.ChatSvc().AuthenticatedRequest(http.MethodGet, ep, nil)
-		if err != nil {
-			lastErr = err
-			continue
-		}
-		resp, err := http.DefaultClient.Do(req)
+		body, _, err := s.authenticatedRoundTrip(http.MethodGet, ep, nil)
 		if err != nil {
-			lastErr = err
-			continue
-		}
-		body, _ := io.ReadAll(resp.Body)
-		_ = resp.Body.Close()
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			lastErr = fmt.Errorf("contacts endpoint %s status=%d", ep, resp.StatusCode)
+			lastErr = fmt.Errorf("contacts endpoint: %v", err)
 			continue
 		}
 		candidates := extractMentionCandidatesFromJSON(body)
@@ -3292,6 +3647,16 @@ func firstString(m map[string]interface{}, keys ...string) string {
 	return ""
 }
 
+// conversationMessagesEndpoint is the newest page of messages of a
+// conversation.
+func conversationMessagesEndpoint(conversationID string) string {
+	query := url.Values{}
+	query.Set("view", "msnp24Equivalent|supportsMessageProperties")
+	query.Set("pageSize", "200")
+	query.Set("startTime", "1")
+	return csa.MessagesHost + "v1/users/ME/conversations/" + url.QueryEscape(conversationID) + "/messages?" + query.Encode()
+}
+
 func (s *AppState) fetchConversationMessages(displayName string, conversationIDs []string) ([]string, []csa.ChatMessage, error) {
 	ids := normalizeConversationIDs(conversationIDs)
 	if len(ids) == 0 {
@@ -3306,31 +3671,22 @@ func (s *AppState) fetchConversationMessages(displayName string, conversationIDs
 			"conversation_id": id,
 			"attempt":         strconv.Itoa(idx + 1),
 		}).Debug("fetching messages")
-		for attempt := 0; attempt < 2; attempt++ {
-			messages, err = s.teamsClient.GetMessages(&csa.Channel{Id: id, DisplayName: displayName})
-			if err == nil {
-				s.logger.WithFields(logrus.Fields{
-					"display_name":    displayName,
-					"conversation_id": id,
-					"attempt":         strconv.Itoa(idx + 1),
-					"messages_count":  len(messages),
-				}).Info("messages loaded")
-				break
-			}
-			if attempt == 0 && isUnauthorizedError(err) {
-				refreshErr := s.refreshAuthFromTeamsToken()
-				if refreshErr == nil {
-					continue
-				}
-				s.logger.WithFields(logrus.Fields{
-					"display_name":    displayName,
-					"conversation_id": id,
-					"refresh_error":   refreshErr.Error(),
-				}).Warn("unable to refresh auth after unauthorized response")
+		var body []byte
+		body, _, err = s.authenticatedRoundTrip(http.MethodGet, conversationMessagesEndpoint(id), nil)
+		if err == nil {
+			var response csa.MessagesResponse
+			if err = json.Unmarshal(body, &response); err != nil {
+				err = fmt.Errorf("invalid messages response: %v", err)
 			}
-			break
+			messages = response.Messages
 		}
 		if err == nil {
+			s.logger.WithFields(logrus.Fields{
+				"display_name":    displayName,
+				"conversation_id": id,
+				"attempt":         strconv.Itoa(idx + 1),
+				"messages_count":  len(messages),
+			}).Info("messages loaded")
 			break
 		}
 		s.logger.WithFields(logrus.Fields{
@@ -3356,7 +3712,19 @@ func (s *AppState) resolveConversationTitle(displayName string, conversationIDs
 	return resolveDMDisplayName(displayName, messages, s.me), nil
 }
 
+// openConversationByIDs loads a conversation the user chose to open and sends
+// the read receipt for it.
+func (s *AppState) openConversationByIDs(selectedNode *tview.TreeNode, conversationIDs []string, displayName string) {
+	s.loadConversationMessages(selectedNode, conversationIDs, displayName, true)
+}
+
+// loadConversationsByIDs reloads a conversation without touching its read
+// state on the server, for the startup auto-load and refreshes.
 func (s *AppState) loadConversationsByIDs(selectedNode *tview.TreeNode, conversationIDs []string, displayName string) {
+	s.loadConversationMessages(selectedNode, conversationIDs, displayName, false)
+}
+
+func (s *AppState) loadConversationMessages(selectedNode *tview.TreeNode, conversationIDs []string, displayName string, markRead bool) {
 	s.logger.WithFields(logrus.Fields{
 		"display_name":  displayName,
 		"incoming_ids":  strings.Join(conversationIDs, ","),
@@ -3376,14 +3744,23 @@ func (s *AppState) loadConversationsByIDs(selectedNode *tview.TreeNode, conversa
 		return
 	}
 
+	if markRead {
+		go s.syncReadHorizon(messages)
+	}
+	messages = s.withMessageHistory(ids, messages)
+
 	displayName = resolveDMDisplayName(displayName, messages, s.me)
 	if selectedNode != nil && strings.TrimSpace(selectedNode.GetText()) != strings.TrimSpace(displayName) {
 		if ref, ok := selectedNode.GetReference().(conversationRef); ok {
 			ref.isUnread = false
+			ref.unreadCount = 0
+			ref.mentionCount = 0
 			s.setManualUnread(ref.chatKey, false)
 			ref.title = displayName
-			selectedNode.SetText(formatChatTreeTitle(ref.title, ref.isUnread))
+			selectedNode.SetText(ref.treeTitle())
 			selectedNode.SetReference(ref)
+			s.syncConversationCopies(selectedNode)
+			refreshTreeUnreadLabels(s.components[TrChat].(*tview.TreeView).GetRoot())
 			if s.setChatTitle(ref.chatKey, displayName) {
 				s.persistEncryptedChatSettings()
 			}
@@ -3434,7 +3811,7 @@ func (s *AppState) loadConversationsByIDs(selectedNode *tview.TreeNode, conversa
 			author = inferMessageAuthor(message, s.me)
 		}
 		if s.isChatWordWrap() {
-			lines := wrapTextLines(textMessage(message.Content), wrapWidth)
+			lines := wrapTextLines(renderMessageText(message), wrapWidth)
 			if len(lines) == 0 {
 				lines = []string{""}
 			}
@@ -3448,7 +3825,7 @@ func (s *AppState) loadConversationsByIDs(selectedNode *tview.TreeNode, conversa
 				rowMap = append(rowMap, msgIdx)
 			}
 		} else {
-			chatList.AddItem(s.formatChatMessageText(message.Content), s.formatMessageSecondary(message, author), 0, nil)
+			chatList.AddItem(s.formatChatMessageText(message), s.formatMessageSecondary(message, author), 0, nil)
 			rowMap = append(rowMap, msgIdx)
 		}
 	}
@@ -3457,6 +3834,7 @@ func (s *AppState) loadConversationsByIDs(selectedNode *tview.TreeNode, conversa
 		chatList.SetCurrentItem(chatList.GetItemCount() - 1)
 	}
 	s.app.Draw()
+	s.applyPendingMessageTarget(selectedNode, ids, displayName)
 }
 
 func inferMessageAuthor(message csa.ChatMessage, me *models.User) string {
@@ -3499,17 +3877,60 @@ func defaultKeybindPath() string {
 
 func defaultKeybindingsForPreset(preset string) map[string][]string {
 	b := map[string][]string{
-		actionToggleScan:     {"m"},
-		actionScanNow:        {"shift+m"},
-		actionMarkUnread:     {"r"},
-		actionToggleFavorite: {"f"},
-		actionRefreshTitles:  {"u"},
-		actionReloadKeybinds: {"ctrl+r"},
-		actionFocusCompose:   {"i"},
-		actionReplyMessage:   {"r"},
-		actionReactMessage:   {"e"},
-		actionMoveDown:       {"down"},
-		actionMoveUp:         {"up"},
+		actionToggleScan:      {"m"},
+		actionScanNow:         {"shift+m"},
+		actionMarkUnread:      {"r"},
+		actionToggleFavorite:  {"f"},
+		actionRefreshTitles:   {"u"},
+		actionReloadKeybinds:  {"ctrl+r"},
+		actionFocusCompose:    {"i"},
+		actionReplyMessage:    {"r"},
+		actionReactMessage:    {"e"},
+		actionMoveDown:        {"down"},
+		actionMoveUp:          {"up"},
+		actionNextUnread:      {"]"},
+		actionPrevUnread:      {"["},
+		actionQuickOpen:       {"ctrl+k"},
+		actionCommandPalette:  {"ctrl+o"},
+		actionExportChat:      {"x"},
+		actionSearchMessages:  {"/"},
+		actionSearchNext:      {"n"},
+		actionSearchPrev:      {"N"},
+		actionForwardMessage:  {"w"},
+		actionFirstItem:       {"home"},
+		actionLastItem:        {"end"},
+		actionVisualMode:      {"V"},
+		actionYankMessage:     {"y"},
+		actionCompleteCommand: {"tab"},
+		actionLeaveCompose:    {"esc"},
+		actionYankPermalink:   {"Y"},
+		actionYankCode:        {"alt+y"},
+		actionPasteClipboard:  {"ctrl+v"},
+		actionOpenLink:        {"o"},
+		actionCopyLink:        {"O"},
+		actionGoToLink:        {"ctrl+l"},
+		actionToggleMembers:   {"ctrl+t"},
+		actionSetStatus:       {"alt+s"},
+		actionMemberChat:      {"c"},
+		actionMemberMention:   {"@"},
+		actionMemberEmail:     {"y"},
+		actionNewChat:         {"alt+n"},
+		actionRenameChat:      {"R"},
+		actionAddChatMembers:  {"A"},
+		actionRemoveMember:    {"D"},
+		actionLeaveChat:       {"L"},
+		actionTogglePin:       {"p"},
+		actionToggleHide:      {"h"},
+		actionToggleHidden:    {"H"},
+		actionFavoriteUp:      {"alt+up"},
+		actionFavoriteDown:    {"alt+down"},
+		actionAssignFolder:    {"F"},
+		actionFilterTree:      {"ctrl+f"},
+		actionCycleViewMode:   {"v"},
+		actionChooseViewMode:  {"alt+v"},
+		actionSwitchAccount:   {"alt+a"},
+		actionPopupNext:       {"down", "ctrl+n", "tab"},
+		actionPopupPrev:       {"up", "ctrl+p", "backtab"},
 	}
 
 	switch strings.ToLower(strings.TrimSpace(preset)) {
@@ -3524,6 +3945,9 @@ func defaultKeybindingsForPreset(preset string) map[string][]string {
 		b[actionMoveUp] = append([]string(nil), b[actionMoveUp]...)
 		b[actionMoveUp] = append(b[actionMoveUp], "k", "K")
 		b[actionFocusCompose] = []string{"i", "c"}
+		b[actionFirstItem] = []string{"home", "g g"}
+		b[actionLastItem] = []string{"end", "G"}
+		b[actionVisualMode] = []string{"v", "V"}
 	case "emacs":
 		b[actionMoveDown] = []string{"down", "ctrl+n"}
 		b[actionMoveUp] = []string{"up", "ctrl+p"}
@@ -3565,7 +3989,14 @@ func (s *AppState) loadKeybindingsConfig() error {
 	if preset == "" {
 		preset = defaultKeybindPreset
 	}
-	base := defaultKeybindingsForPreset(preset)
+	leader := strings.TrimSpace(cfg.Leader)
+	if leader == "" {
+		leader = defaultKeyLeader
+	}
+	timeout := defaultSequenceTimeout
+	if cfg.SequenceTimeoutMs > 0 {
+		timeout = time.Duration(cfg.SequenceTimeoutMs) * time.Millisecond
+	}
 	overrides := map[string][]string{}
 	for action, keys := range cfg.Bindings {
 		if len(keys) == 0 {
@@ -3573,14 +4004,96 @@ func (s *AppState) loadKeybindingsConfig() error {
 		}
 		overrides[action] = append([]string(nil), keys...)
 	}
-	merged := mergeKeybindings(base, overrides)
+	scopeOverrides := map[string]map[string][]string{}
+	problems := []string{}
+	for scope, bindings := range cfg.Scopes {
+		if _, ok := findKeybindingScope(scope); !ok {
+			problems = append(problems, fmt.Sprintf("unknown scope %q", scope))
+			continue
+		}
+		for action, keys := range bindings {
+			if !scopeHasAction(scope, action) {
+				problems = append(problems, fmt.Sprintf("%s: %s is not available in this scope", scope, action))
+				continue
+			}
+			if len(keys) == 0 {
+				continue
+			}
+			if scopeOverrides[scope] == nil {
+				scopeOverrides[scope] = map[string][]string{}
+			}
+			scopeOverrides[scope][action] = append([]string(nil), keys...)
+		}
+	}
 
 	s.keybindMu.Lock()
 	s.keybindPreset = preset
-	s.keybindings = merged
+	s.keyLeader = leader
+	s.keySequenceTimeout = timeout
 	s.keybindOverrides = overrides
+	s.keybindScopeOverrides = scopeOverrides
+	err = s.applyKeybindingsLocked()
 	s.keybindMu.Unlock()
-	return nil
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		scopeErr := fmt.Errorf("invalid keybinding scopes: %s", strings.Join(problems, "; "))
+		if err == nil {
+			return scopeErr
+		}
+		return fmt.Errorf("%v; %v", scopeErr, err)
+	}
+	return err
+}
+
+// effectiveKeybindings resolves the keys of every action in every scope. A
+// scope's own override wins, then a global scope override for inherited global
+// actions, then the flat bindings from the config file, then the preset.
+func effectiveKeybindings(preset string, overrides map[string][]string, scopeOverrides map[string]map[string][]string) map[string]map[string][]string {
+	base := mergeKeybindings(defaultKeybindingsForPreset(preset), overrides)
+	out := map[string]map[string][]string{}
+	for _, scope := range keybindingScopes {
+		bindings := map[string][]string{}
+		for _, action := range scopeActionList(scope.name) {
+			keys := base[action]
+			if scope.name != scopeGlobal && len(scopeOverrides[scopeGlobal][action]) > 0 {
+				keys = scopeOverrides[scopeGlobal][action]
+			}
+			if len(scopeOverrides[scope.name][action]) > 0 {
+				keys = scopeOverrides[scope.name][action]
+			}
+			bindings[action] = append([]string(nil), keys...)
+		}
+		out[scope.name] = bindings
+	}
+	return out
+}
+
+// applyKeybindingsLocked resolves the preset and overrides into per-scope
+// bindings and their parsed key sequences. The caller holds keybindMu (or owns
+// s exclusively during setup).
+func (s *AppState) applyKeybindingsLocked() error {
+	leader := s.keyLeader
+	if strings.TrimSpace(leader) == "" {
+		leader = defaultKeyLeader
+	}
+	bindings := effectiveKeybindings(s.keybindPreset, s.keybindOverrides, s.keybindScopeOverrides)
+	sequences, conflicts, err := compileKeybindings(bindings, leader)
+	s.keybindings = bindings
+	s.keySequences = sequences
+	s.keybindConflicts = conflicts
+	return err
+}
+
+func (s *AppState) hasKeybindConflict(scope, action string) bool {
+	s.keybindMu.RLock()
+	defer s.keybindMu.RUnlock()
+	return s.keybindConflicts[scope][action]
+}
+
+func (s *AppState) hasScopeOverride(scope, action string) bool {
+	s.keybindMu.RLock()
+	defer s.keybindMu.RUnlock()
+	return len(s.keybindScopeOverrides[scope][action]) > 0
 }
 
 func (s *AppState) reloadKeybindingsConfig() error {
@@ -3598,12 +4111,32 @@ func (s *AppState) saveKeybindingsConfig() error {
 		Preset:   s.keybindPreset,
 		Bindings: map[string][]string{},
 	}
+	if s.keyLeader != defaultKeyLeader {
+		cfg.Leader = s.keyLeader
+	}
+	if s.keySequenceTimeout != defaultSequenceTimeout {
+		cfg.SequenceTimeoutMs = int(s.keySequenceTimeout / time.Millisecond)
+	}
 	for action, keys := range s.keybindOverrides {
 		if len(keys) == 0 {
 			continue
 		}
 		cfg.Bindings[action] = append([]string(nil), keys...)
 	}
+	for scope, bindings := range s.keybindScopeOverrides {
+		for action, keys := range bindings {
+			if len(keys) == 0 {
+				continue
+			}
+			if cfg.Scopes == nil {
+				cfg.Scopes = map[string]map[string][]string{}
+			}
+			if cfg.Scopes[scope] == nil {
+				cfg.Scopes[scope] = map[string][]string{}
+			}
+			cfg.Scopes[scope][action] = append([]string(nil), keys...)
+		}
+	}
 	s.keybindMu.RUnlock()
 
 	data, err := json.MarshalIndent(cfg, "", "  ")
@@ -3625,43 +4158,87 @@ func (s *AppState) setKeybindPreset(preset string) error {
 	if preset == "" {
 		preset = defaultKeybindPreset
 	}
-	base := defaultKeybindingsForPreset(preset)
 	s.keybindMu.Lock()
 	s.keybindPreset = preset
 	s.keybindOverrides = map[string][]string{}
-	s.keybindings = base
+	s.keybindScopeOverrides = map[string]map[string][]string{}
+	_ = s.applyKeybindingsLocked()
 	s.keybindMu.Unlock()
 	return s.saveKeybindingsConfig()
 }
 
-func (s *AppState) setActionBinding(action, token string) error {
+// setActionBinding binds token to action in scope only. It refuses keys that
+// another action of the scope already uses.
+func (s *AppState) setActionBinding(scope, action, token string) error {
+	scope = strings.TrimSpace(scope)
 	action = strings.TrimSpace(action)
 	token = strings.TrimSpace(token)
 	if action == "" || token == "" {
 		return fmt.Errorf("action or token is empty")
 	}
+	if !scopeHasAction(scope, action) {
+		return fmt.Errorf("%s is not available in %s", action, scope)
+	}
+	// Global bindings are inherited, so they must be free in those scopes too.
+	checkScopes := []string{scope}
+	if scope == scopeGlobal {
+		for _, candidate := range keybindingScopes {
+			if candidate.inheritGlobal {
+				checkScopes = append(checkScopes, candidate.name)
+			}
+		}
+	}
 	s.keybindMu.Lock()
-	if s.keybindOverrides == nil {
-		s.keybindOverrides = map[string][]string{}
+	for _, checkScope := range checkScopes {
+		if owner := s.keyOwnerLocked(checkScope, token); owner != "" && owner != action {
+			s.keybindMu.Unlock()
+			return fmt.Errorf("%s is already bound to %s in %s", token, owner, checkScope)
+		}
+	}
+	if s.keybindScopeOverrides == nil {
+		s.keybindScopeOverrides = map[string]map[string][]string{}
 	}
-	s.keybindOverrides[action] = []string{token}
-	base := defaultKeybindingsForPreset(s.keybindPreset)
-	s.keybindings = mergeKeybindings(base, s.keybindOverrides)
+	if s.keybindScopeOverrides[scope] == nil {
+		s.keybindScopeOverrides[scope] = map[string][]string{}
+	}
+	s.keybindScopeOverrides[scope][action] = []string{token}
+	_ = s.applyKeybindingsLocked()
 	s.keybindMu.Unlock()
 	return s.saveKeybindingsConfig()
 }
 
-func (s *AppState) resetActionBindingToDefault(action string) error {
+// keyOwnerLocked returns the action of scope bound exactly to token. The
+// caller holds keybindMu.
+func (s *AppState) keyOwnerLocked(scope, token string) string {
+	stroke, err := parseKeyStroke(token)
+	if err != nil {
+		return ""
+	}
+	for _, action := range scopeActionList(scope) {
+		for _, seq := range s.keySequences[scope][action] {
+			if len(seq) == 1 && seq[0] == stroke {
+				return action
+			}
+		}
+	}
+	return ""
+}
+
+// resetActionBindingToDefault drops the scope's override of action so the flat
+// config binding or preset default applies again.
+func (s *AppState) resetActionBindingToDefault(scope, action string) error {
 	action = strings.TrimSpace(action)
 	if action == "" {
 		return fmt.Errorf("action is empty")
 	}
 	s.keybindMu.Lock()
-	if s.keybindOverrides != nil {
-		delete(s.keybindOverrides, action)
+	if s.keybindScopeOverrides[scope] != nil {
+		delete(s.keybindScopeOverrides[scope], action)
+		if len(s.keybindScopeOverrides[scope]) == 0 {
+			delete(s.keybindScopeOverrides, scope)
+		}
 	}
-	base := defaultKeybindingsForPreset(s.keybindPreset)
-	s.keybindings = mergeKeybindings(base, s.keybindOverrides)
+	_ = s.applyKeybindingsLocked()
 	s.keybindMu.Unlock()
 	return s.saveKeybindingsConfig()
 }
@@ -3684,56 +4261,23 @@ func (s *AppState) writeDefaultKeybindingsConfig() error {
 	return os.WriteFile(s.keybindPath, data, 0o600)
 }
 
-func (s *AppState) bindingMatches(action string, event *tcell.EventKey) bool {
+// bindingMatches reports whether event completes a single-key binding of action
+// in scope. Multi-key sequences are resolved by dispatchPaneAction.
+func (s *AppState) bindingMatches(scope, action string, event *tcell.EventKey) bool {
 	if event == nil {
 		return false
 	}
+	stroke := eventToKeyStroke(event)
 	s.keybindMu.RLock()
-	tokens := append([]string(nil), s.keybindings[action]...)
-	s.keybindMu.RUnlock()
-	for _, token := range tokens {
-		if keyTokenMatches(strings.TrimSpace(token), event) {
+	defer s.keybindMu.RUnlock()
+	for _, seq := range s.keySequences[scope][action] {
+		if len(seq) == 1 && seq[0] == stroke {
 			return true
 		}
 	}
 	return false
 }
 
-func keyTokenMatches(token string, event *tcell.EventKey) bool {
-	if token == "" || event == nil {
-		return false
-	}
-	switch strings.ToLower(token) {
-	case "up":
-		return event.Key() == tcell.KeyUp
-	case "down":
-		return event.Key() == tcell.KeyDown
-	case "left":
-		return event.Key() == tcell.KeyLeft
-	case "right":
-		return event.Key() == tcell.KeyRight
-	case "enter":
-		return event.Key() == tcell.KeyEnter
-	case "esc":
-		return event.Key() == tcell.KeyEscape
-	case "ctrl+n":
-		return event.Key() == tcell.KeyCtrlN
-	case "ctrl+p":
-		return event.Key() == tcell.KeyCtrlP
-	case "ctrl+r":
-		return event.Key() == tcell.KeyCtrlR
-	case "ctrl+x":
-		return event.Key() == tcell.KeyCtrlX
-	case "shift+m":
-		return event.Key() == tcell.KeyRune && event.Rune() == 'M'
-	}
-	if len([]rune(token)) == 1 {
-		r := []rune(token)[0]
-		return event.Key() == tcell.KeyRune && event.Rune() == r
-	}
-	return false
-}
-
 func (s *AppState) loadEncryptedChatSettings() error {
 	if strings.TrimSpace(s.settingsPath) == "" || strings.TrimSpace(s.settingsKey) == "" {
 		return nil
@@ -3746,44 +4290,14 @@ func (s *AppState) loadEncryptedChatSettings() error {
 		return err
 	}
 
-	var stored encryptedSettingsFile
-	if err = json.Unmarshal(data, &stored); err != nil {
-		return fmt.Errorf("invalid settings file format: %v", err)
-	}
-	if stored.Version != 1 {
-		return fmt.Errorf("unsupported settings file version: %d", stored.Version)
-	}
-
 	key, err := s.readOrCreateSettingsKey()
 	if err != nil {
 		return err
 	}
-	nonce, err := base64.StdEncoding.DecodeString(stored.Nonce)
-	if err != nil {
-		return fmt.Errorf("invalid settings nonce: %v", err)
-	}
-	ciphertext, err := base64.StdEncoding.DecodeString(stored.Ciphertext)
-	if err != nil {
-		return fmt.Errorf("invalid settings ciphertext: %v", err)
-	}
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-	gcm, err := cipher.NewGCM(block)
+	settings, err := decryptChatSettings(data, key)
 	if err != nil {
 		return err
 	}
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return fmt.Errorf("unable to decrypt settings: %v", err)
-	}
-
-	var settings persistedChatSettings
-	if err = json.Unmarshal(plaintext, &settings); err != nil {
-		return fmt.Errorf("invalid decrypted settings payload: %v", err)
-	}
 
 	s.chatFavoritesMu.Lock()
 	if settings.Favorites == nil {
@@ -3791,6 +4305,7 @@ func (s *AppState) loadEncryptedChatSettings() error {
 	} else {
 		s.chatFavorites = settings.Favorites
 	}
+	s.favoriteOrder = settings.FavoriteOrder
 	s.chatFavoritesMu.Unlock()
 
 	s.chatTitlesMu.Lock()
@@ -3809,6 +4324,29 @@ func (s *AppState) loadEncryptedChatSettings() error {
 	}
 	s.manualUnreadMu.Unlock()
 
+	s.mutedMu.Lock()
+	if settings.Muted == nil {
+		s.muted = map[string]bool{}
+	} else {
+		s.muted = settings.Muted
+	}
+	s.mutedMu.Unlock()
+
+	s.hiddenMu.Lock()
+	if settings.Hidden == nil {
+		s.hidden = map[string]bool{}
+	} else {
+		s.hidden = settings.Hidden
+	}
+	s.hiddenMu.Unlock()
+
+	s.setPersistedFolders(settings.Folders)
+
+	s.treeFilterMu.Lock()
+	s.treeViewMode = normalizeTreeViewMode(settings.TreeViewMode)
+	s.activeDays = settings.ActiveDays
+	s.treeFilterMu.Unlock()
+
 	s.chatWordWrapMu.Lock()
 	if settings.ChatWordWrap == nil {
 		s.chatWordWrap = true
@@ -3832,6 +4370,87 @@ func (s *AppState) loadEncryptedChatSettings() error {
 	return nil
 }
 
+// decryptChatSettings opens an encrypted settings file with its key.
+func decryptChatSettings(data, key []byte) (persistedChatSettings, error) {
+	var settings persistedChatSettings
+	plaintext, err := openEncryptedFile(data, key)
+	if err != nil {
+		return settings, err
+	}
+	if err = json.Unmarshal(plaintext, &settings); err != nil {
+		return settings, fmt.Errorf("invalid decrypted settings payload: %v", err)
+	}
+	return settings, nil
+}
+
+// openEncryptedFile decrypts the contents of an encryptedSettingsFile.
+func openEncryptedFile(data, key []byte) ([]byte, error) {
+	var stored encryptedSettingsFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid settings file format: %v", err)
+	}
+	if stored.Version != 1 {
+		return nil, fmt.Errorf("unsupported settings file version: %d", stored.Version)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(stored.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid settings nonce: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(stored.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid settings ciphertext: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt settings: %v", err)
+	}
+	return plaintext, nil
+}
+
+// sealEncryptedFile encrypts plaintext into the encryptedSettingsFile format.
+func sealEncryptedFile(plaintext, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize encryption cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize encryption mode: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("unable to create encryption nonce: %v", err)
+	}
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+
+	return json.Marshal(encryptedSettingsFile{
+		Version:    1,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	})
+}
+
+// writeFileAtomic replaces path with data through a temporary file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
 func (s *AppState) persistEncryptedChatSettings() {
 	if strings.TrimSpace(s.settingsPath) == "" || strings.TrimSpace(s.settingsKey) == "" {
 		return
@@ -3846,11 +4465,13 @@ func (s *AppState) persistEncryptedChatSettings() {
 		Favorites:       map[string]bool{},
 		Titles:          map[string]string{},
 		UnreadOverrides: map[string]bool{},
+		Muted:           map[string]bool{},
 	}
 	s.chatFavoritesMu.RLock()
 	for k, v := range s.chatFavorites {
 		settings.Favorites[k] = v
 	}
+	settings.FavoriteOrder = append([]string(nil), s.favoriteOrder...)
 	s.chatFavoritesMu.RUnlock()
 	s.chatTitlesMu.RLock()
 	for k, v := range s.chatTitles {
@@ -3864,6 +4485,32 @@ func (s *AppState) persistEncryptedChatSettings() {
 		settings.UnreadOverrides[k] = v
 	}
 	s.manualUnreadMu.RUnlock()
+	s.mutedMu.RLock()
+	for k, v := range s.muted {
+		if v {
+			settings.Muted[k] = true
+		}
+	}
+	s.mutedMu.RUnlock()
+	s.hiddenMu.RLock()
+	for k, v := range s.hidden {
+		if v {
+			if settings.Hidden == nil {
+				settings.Hidden = map[string]bool{}
+			}
+			settings.Hidden[k] = true
+		}
+	}
+	s.hiddenMu.RUnlock()
+	settings.Folders = s.persistedFolders()
+	s.treeFilterMu.RLock()
+	if mode := normalizeTreeViewMode(s.treeViewMode); mode != treeViewAll {
+		settings.TreeViewMode = mode
+	}
+	if s.activeDays > 0 && s.activeDays != defaultActiveDays {
+		settings.ActiveDays = s.activeDays
+	}
+	s.treeFilterMu.RUnlock()
 	wrap := s.isChatWordWrap()
 	settings.ChatWordWrap = &wrap
 	wrapChars := s.getChatWrapPercent()
@@ -3879,44 +4526,14 @@ func (s *AppState) persistEncryptedChatSettings() {
 		return
 	}
 
-	block, err := aes.NewCipher(key)
+	encoded, err := sealEncryptedFile(plaintext, key)
 	if err != nil {
-		s.logger.WithError(err).Warn("unable to initialize encryption cipher")
-		return
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		s.logger.WithError(err).Warn("unable to initialize encryption mode")
-		return
-	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = rand.Read(nonce); err != nil {
-		s.logger.WithError(err).Warn("unable to create encryption nonce")
+		s.logger.WithError(err).Warn("unable to encrypt chat settings")
 		return
 	}
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
 
-	encoded, err := json.Marshal(encryptedSettingsFile{
-		Version:    1,
-		Nonce:      base64.StdEncoding.EncodeToString(nonce),
-		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
-	})
-	if err != nil {
-		s.logger.WithError(err).Warn("unable to encode encrypted settings payload")
-		return
-	}
-
-	if err = os.MkdirAll(filepath.Dir(s.settingsPath), 0o700); err != nil {
-		s.logger.WithError(err).Warn("unable to create settings directory")
-		return
-	}
-	tmpPath := s.settingsPath + ".tmp"
-	if err = os.WriteFile(tmpPath, encoded, 0o600); err != nil {
-		s.logger.WithError(err).Warn("unable to write temporary settings file")
-		return
-	}
-	if err = os.Rename(tmpPath, s.settingsPath); err != nil {
-		s.logger.WithError(err).Warn("unable to finalize encrypted settings file")
+	if err = writeFileAtomic(s.settingsPath, encoded); err != nil {
+		s.logger.WithError(err).Warn("unable to write encrypted settings file")
 	}
 }
 
@@ -3960,9 +4577,24 @@ func (s *AppState) refreshAuthFromTeamsToken() error {
 	s.authRefreshMu.Lock()
 	defer s.authRefreshMu.Unlock()
 
+	// Native sign-in first; the external helper only when it is not
+	// configured or fails.
+	ctx, cancel := context.WithTimeout(context.Background(), authLoginTimeout)
+	nativeErr := s.nativeSignIn(ctx, signInOptions{})
+	cancel()
+	if nativeErr == nil {
+		return s.reconnectTeamsClient()
+	}
+	if !errors.Is(nativeErr, errNativeAuthNotConfigured) {
+		s.logger.WithError(nativeErr).Warn("native sign-in failed, trying teams-token")
+	}
+
 	teamsTokenDir := "teams-token"
 	info, err := os.Stat(teamsTokenDir)
 	if err != nil || !info.IsDir() {
+		if !errors.Is(nativeErr, errNativeAuthNotConfigured) {
+			return fmt.Errorf("native sign-in failed: %v", nativeErr)
+		}
 		return fmt.Errorf("optional %s directory not found", teamsTokenDir)
 	}
 
@@ -4006,18 +4638,27 @@ func (s *AppState) refreshAuthFromTeamsToken() error {
 		}
 	}
 
+	// Named profiles collect the helper's tokens from a scratch home.
+	helperHome := ""
+	if !s.account.isDefault() {
+		if helperHome, err = os.MkdirTemp("", "teams-cli-token-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(helperHome)
+		cmd.Env = tokenHelperEnv(helperHome)
+	}
+
 	output, runErr := cmd.CombinedOutput()
 	if runErr != nil {
 		return fmt.Errorf("teams-token refresh command failed: %v (output: %s)", runErr, strings.TrimSpace(string(output)))
 	}
 	s.logger.Info("teams-token refresh command succeeded")
-
-	newClient, newClientErr := teams_api.New()
-	if newClientErr != nil {
-		return fmt.Errorf("unable to reinitialize Teams client after token refresh: %v", newClientErr)
+	if helperHome != "" {
+		if err = s.account.importTokens(filepath.Join(helperHome, ".config", "fossteams")); err != nil {
+			return err
+		}
 	}
-	s.teamsClient = newClient
-	return nil
+	return s.reconnectTeamsClient()
 }
 
 func fileExists(path string) bool {
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rivo/tview"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuthority   = "https://login.microsoftonline.com"
+	defaultAuthTenant  = "organizations"
+	authFlowDevice     = "device"
+	authFlowBrowser    = "browser"
+	pageAuthPrompt     = "pageAuthPrompt"
+	authLoginTimeout   = 10 * time.Minute
+	deviceCodeGrant    = "urn:ietf:params:oauth:grant-type:device_code"
+	authResponseMaxLen = 1 << 20
+)
+
+// errNativeAuthNotConfigured means no client ID is set, so sign-in is left to
+// the teams-token helper.
+var errNativeAuthNotConfigured = errors.New("native sign-in is not configured")
+
+// authScopes maps each token file teams-api reads to the resource it is issued
+// for. The first one is requested interactively, the others are redeemed with
+// its refresh token.
+var authScopes = []struct {
+	kind     string
+	scope    string
+	optional bool
+}{
+	{kind: "skype", scope: "https://api.spaces.skype.com/.default"},
+	{kind: "chatsvcagg", scope: "https://chatsvcagg.teams.microsoft.com/.default"},
+	{kind: "teams", scope: "https://teams.microsoft.com/.default", optional: true},
+}
+
+var authHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// deviceCodeSlowDown is added to the polling interval on a slow_down answer.
+var deviceCodeSlowDown = 5 * time.Second
+
+// openSignInPage opens the authorize address of the browser flow.
+var openSignInPage = openInBrowser
+
+// authConfig is read from teams-cli-auth.json in the profile directory. The
+// TEAMS_CLI_CLIENT_ID, TEAMS_CLI_TENANT, TEAMS_CLI_AUTHORITY and
+// TEAMS_CLI_AUTH_FLOW variables override it.
+type authConfig struct {
+	ClientID     string `json:"client_id"`
+	Tenant       string `json:"tenant,omitempty"`
+	Authority    string `json:"authority,omitempty"`
+	Flow         string `json:"flow,omitempty"`
+	RedirectPort int    `json:"redirect_port,omitempty"`
+}
+
+// storedAuth is the refresh token kept next to the settings, encrypted with
+// the settings key. It is only reused for the client it was issued to.
+type storedAuth struct {
+	ClientID     string `json:"client_id"`
+	Tenant       string `json:"tenant"`
+	Authority    string `json:"authority"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// oauthToken is a token endpoint response.
+type oauthToken struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type deviceCodeResponse struct {
+	DeviceCode       string `json:"device_code"`
+	UserCode         string `json:"user_code"`
+	VerificationURI  string `json:"verification_uri"`
+	ExpiresIn        int    `json:"expires_in"`
+	Interval         int    `json:"interval"`
+	Message          string `json:"message"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// oauthError is an error returned by the identity provider.
+type oauthError struct {
+	code        string
+	description string
+}
+
+func (e *oauthError) Error() string {
+	if e.description == "" {
+		return e.code
+	}
+	return e.code + ": " + firstLine(e.description)
+}
+
+// signInOptions controls nativeSignIn. fresh ignores the stored refresh token
+// and silent never asks the user.
+type signInOptions struct {
+	flow   string
+	fresh  bool
+	silent bool
+}
+
+func firstLine(text string) string {
+	if idx := strings.IndexAny(text, "\r\n"); idx >= 0 {
+		return text[:idx]
+	}
+	return text
+}
+
+func (p accountProfile) authConfigPath() string {
+	return filepath.Join(p.dir, "teams-cli-auth.json")
+}
+
+func (p accountProfile) authStatePath() string {
+	return filepath.Join(p.dir, "teams-cli-auth.enc")
+}
+
+func normalizeAuthFlow(flow string) string {
+	switch strings.ToLower(strings.TrimSpace(flow)) {
+	case "browser", "pkce", "code", "auth_code":
+		return authFlowBrowser
+	default:
+		return authFlowDevice
+	}
+}
+
+func loadAuthConfig(p accountProfile) (authConfig, error) {
+	cfg := authConfig{}
+	data, err := os.ReadFile(p.authConfigPath())
+	if err == nil {
+		if err = json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid %s: %v", p.authConfigPath(), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return cfg, err
+	}
+	for env, field := range map[string]*string{
+		"TEAMS_CLI_CLIENT_ID": &cfg.ClientID,
+		"TEAMS_CLI_TENANT":    &cfg.Tenant,
+		"TEAMS_CLI_AUTHORITY": &cfg.Authority,
+		"TEAMS_CLI_AUTH_FLOW": &cfg.Flow,
+	} {
+		if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+			*field = v
+		}
+	}
+	cfg.ClientID = strings.TrimSpace(cfg.ClientID)
+	if strings.TrimSpace(cfg.Tenant) == "" {
+		cfg.Tenant = defaultAuthTenant
+	}
+	cfg.Authority = strings.TrimRight(strings.TrimSpace(cfg.Authority), "/")
+	if cfg.Authority == "" {
+		cfg.Authority = defaultAuthority
+	}
+	cfg.Flow = normalizeAuthFlow(cfg.Flow)
+	if cfg.ClientID == "" {
+		return cfg, errNativeAuthNotConfigured
+	}
+	return cfg, nil
+}
+
+func (c authConfig) endpoint(name string) string {
+	return c.Authority + "/" + url.PathEscape(strings.TrimSpace(c.Tenant)) + "/oauth2/v2.0/" + name
+}
+
+// postAuthForm posts form to an identity provider endpoint and decodes the
+// JSON answer into out, which must carry the error fields of an OAuth error.
+func postAuthForm(ctx context.Context, endpoint string, form url.Values, out interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := authHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, authResponseMaxLen))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err = json.Unmarshal(body, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+func requestToken(ctx context.Context, cfg authConfig, form url.Values) (oauthToken, error) {
+	var token oauthToken
+	form.Set("client_id", cfg.ClientID)
+	status, err := postAuthForm(ctx, cfg.endpoint("token"), form, &token)
+	if err != nil {
+		return token, err
+	}
+	if token.Error != "" {
+		return token, &oauthError{code: token.Error, description: token.ErrorDescription}
+	}
+	if status >= 300 || token.AccessToken == "" {
+		return token, fmt.Errorf("token endpoint returned status %d without an access token", status)
+	}
+	return token, nil
+}
+
+func redeemRefreshToken(ctx context.Context, cfg authConfig, refreshToken, scope string) (oauthToken, error) {
+	return requestToken(ctx, cfg, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"scope":         {scope + " offline_access"},
+	})
+}
+
+// deviceCodeLogin runs the device authorization grant: prompt shows the code
+// to enter, then the token endpoint is polled until the user is done.
+func deviceCodeLogin(ctx context.Context, cfg authConfig, scope string, prompt func(string)) (oauthToken, error) {
+	var code deviceCodeResponse
+	_, err := postAuthForm(ctx, cfg.endpoint("devicecode"), url.Values{
+		"client_id": {cfg.ClientID},
+		"scope":     {scope + " offline_access openid profile"},
+	}, &code)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	if code.Error != "" {
+		return oauthToken{}, &oauthError{code: code.Error, description: code.ErrorDescription}
+	}
+	if code.DeviceCode == "" {
+		return oauthToken{}, fmt.Errorf("device code endpoint returned no device code")
+	}
+	message := strings.TrimSpace(code.Message)
+	if message == "" {
+		message = fmt.Sprintf("To sign in, open %s and enter the code %s.", code.VerificationURI, code.UserCode)
+	}
+	prompt(message)
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if code.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return oauthToken{}, fmt.Errorf("device code sign-in: %v", ctx.Err())
+		case <-time.After(interval):
+		}
+		token, err := requestToken(ctx, cfg, url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {code.DeviceCode},
+		})
+		var oerr *oauthError
+		if errors.As(err, &oerr) {
+			switch oerr.code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += deviceCodeSlowDown
+				continue
+			}
+		}
+		return token, err
+	}
+}
+
+func randomURLSafe(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// browserLogin runs the authorization code grant with PKCE. The browser is
+// sent to the authorize endpoint and redirected back to a listener on
+// localhost, whose code is exchanged for tokens.
+func browserLogin(ctx context.Context, cfg authConfig, scope string, prompt func(string)) (oauthToken, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.RedirectPort))
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("unable to listen for the sign-in redirect: %v", err)
+	}
+	redirectURI := fmt.Sprintf("http://localhost:%d/", listener.Addr().(*net.TCPAddr).Port)
+	verifier, err := randomURLSafe(32)
+	if err != nil {
+		listener.Close()
+		return oauthToken{}, err
+	}
+	state, err := randomURLSafe(16)
+	if err != nil {
+		listener.Close()
+		return oauthToken{}, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	fullScope := scope + " offline_access openid profile"
+	authURL := cfg.endpoint("authorize") + "?" + url.Values{
+		"client_id":             {cfg.ClientID},
+		"response_type":         {"code"},
+		"response_mode":         {"query"},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {fullScope},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+
+	type callback struct {
+		code string
+		err  *oauthError
+	}
+	result := make(chan callback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/" || query.Get("state") != state {
+			http.NotFound(w, r)
+			return
+		}
+		cb := callback{code: query.Get("code")}
+		if e := query.Get("error"); e != "" || cb.code == "" {
+			cb.err = &oauthError{code: e, description: query.Get("error_description")}
+			if cb.err.code == "" {
+				cb.err.code = "missing_code"
+			}
+			fmt.Fprintln(w, "Sign-in failed: "+cb.err.Error())
+		} else {
+			fmt.Fprintln(w, "Signed in. You can close this window and return to teams-cli.")
+		}
+		select {
+		case result <- cb:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	if err = openSignInPage(authURL); err != nil {
+		prompt("Open this address in a browser to sign in:\n" + authURL)
+	} else {
+		prompt("Continue signing in in your browser. If it did not open, visit:\n" + authURL)
+	}
+
+	var cb callback
+	select {
+	case <-ctx.Done():
+		return oauthToken{}, fmt.Errorf("browser sign-in: %v", ctx.Err())
+	case cb = <-result:
+	}
+	if cb.err != nil {
+		return oauthToken{}, cb.err
+	}
+	return requestToken(ctx, cfg, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {cb.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+		"scope":         {fullScope},
+	})
+}
+
+func (s *AppState) loadStoredAuth(cfg authConfig) string {
+	data, err := os.ReadFile(s.account.authStatePath())
+	if err != nil {
+		return ""
+	}
+	key, err := s.readOrCreateSettingsKey()
+	if err != nil {
+		return ""
+	}
+	plaintext, err := openEncryptedFile(data, key)
+	if err != nil {
+		s.logger.WithError(err).Warn("unable to read stored sign-in")
+		return ""
+	}
+	var stored storedAuth
+	if err = json.Unmarshal(plaintext, &stored); err != nil {
+		return ""
+	}
+	if stored.ClientID != cfg.ClientID || stored.Tenant != cfg.Tenant || stored.Authority != cfg.Authority {
+		return ""
+	}
+	return stored.RefreshToken
+}
+
+func (s *AppState) saveStoredAuth(cfg authConfig, refreshToken string) error {
+	key, err := s.readOrCreateSettingsKey()
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(storedAuth{
+		ClientID:     cfg.ClientID,
+		Tenant:       cfg.Tenant,
+		Authority:    cfg.Authority,
+		RefreshToken: refreshToken,
+	})
+	if err != nil {
+		return err
+	}
+	encoded, err := sealEncryptedFile(plaintext, key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.account.authStatePath(), encoded)
+}
+
+// nativeSignIn gets fresh tokens for the active profile from the configured
+// client and writes the token-*.jwt files teams-api reads. The stored refresh
+// token is tried first; when it is missing or rejected the user signs in with
+// the device code or browser flow, unless opts.silent is set.
+func (s *AppState) nativeSignIn(ctx context.Context, opts signInOptions) error {
+	cfg, err := loadAuthConfig(s.account)
+	if err != nil {
+		return err
+	}
+	if opts.flow != "" {
+		cfg.Flow = normalizeAuthFlow(opts.flow)
+	}
+
+	primary := authScopes[0]
+	refreshToken := ""
+	if !opts.fresh {
+		refreshToken = s.loadStoredAuth(cfg)
+	}
+	var first oauthToken
+	if refreshToken != "" {
+		first, err = redeemRefreshToken(ctx, cfg, refreshToken, primary.scope)
+		if err != nil {
+			s.logger.WithError(err).Warn("stored sign-in was rejected")
+			refreshToken = ""
+		}
+	}
+	if refreshToken == "" {
+		if opts.silent {
+			return fmt.Errorf("no stored sign-in for profile %s", s.account.displayName())
+		}
+		prompt := func(text string) {
+			s.showAuthPrompt(text)
+		}
+		defer s.hideAuthPrompt()
+		if cfg.Flow == authFlowBrowser {
+			first, err = browserLogin(ctx, cfg, primary.scope, prompt)
+		} else {
+			first, err = deviceCodeLogin(ctx, cfg, primary.scope, prompt)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if first.RefreshToken != "" {
+		refreshToken = first.RefreshToken
+	}
+	if refreshToken == "" {
+		return fmt.Errorf("sign-in returned no refresh token; is offline_access allowed for client %s?", cfg.ClientID)
+	}
+
+	tokens := map[string]string{primary.kind: first.AccessToken}
+	for _, target := range authScopes[1:] {
+		token, err := redeemRefreshToken(ctx, cfg, refreshToken, target.scope)
+		if err != nil {
+			if target.optional {
+				s.logger.WithError(err).WithField("token_name", target.kind).Warn("optional token not issued")
+				continue
+			}
+			return fmt.Errorf("unable to get %s token: %v", target.kind, err)
+		}
+		tokens[target.kind] = token.AccessToken
+		if token.RefreshToken != "" {
+			refreshToken = token.RefreshToken
+		}
+	}
+	for kind, token := range tokens {
+		if err = writeFileAtomic(s.account.tokenPath(kind), []byte(token)); err != nil {
+			return fmt.Errorf("unable to write %s token: %v", kind, err)
+		}
+	}
+	if err = s.saveStoredAuth(cfg, refreshToken); err != nil {
+		s.logger.WithError(err).Warn("unable to store sign-in")
+	}
+	s.logger.WithField("profile", s.account.displayName()).Info("native sign-in wrote tokens")
+	return nil
+}
+
+// showAuthPrompt shows sign-in instructions over the current page, or on
+// stderr when there is no UI.
+func (s *AppState) showAuthPrompt(text string) {
+	if s.pages == nil {
+		fmt.Fprintln(os.Stderr, text)
+		return
+	}
+	s.app.QueueUpdateDraw(func() {
+		view := tview.NewTextView().
+			SetText(text).
+			SetWordWrap(true).
+			SetTextAlign(tview.AlignCenter)
+		view.SetBorder(true).
+			SetTitle("Sign in").
+			SetTitleAlign(tview.AlignCenter).
+			SetBorderPadding(1, 1, 1, 1)
+		modal := tview.NewFlex().
+			AddItem(nil, 0, 1, false).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(nil, 0, 1, false).
+				AddItem(view, 12, 1, false).
+				AddItem(nil, 0, 1, false), 80, 1, false).
+			AddItem(nil, 0, 1, false)
+		s.pages.RemovePage(pageAuthPrompt)
+		s.pages.AddPage(pageAuthPrompt, modal, true, true)
+	})
+}
+
+func (s *AppState) hideAuthPrompt() {
+	if s.pages == nil {
+		return
+	}
+	s.app.QueueUpdateDraw(func() {
+		s.pages.RemovePage(pageAuthPrompt)
+	})
+}
+
+// runLoginCommand signs the profile in without starting the UI.
+func (s *AppState) runLoginCommand(opts loginOptions) error {
+	s.settingsPath, s.settingsKey = s.account.settingsPaths()
+	ctx, cancel := context.WithTimeout(context.Background(), authLoginTimeout)
+	defer cancel()
+	err := s.nativeSignIn(ctx, signInOptions{flow: opts.flow, fresh: true})
+	if errors.Is(err, errNativeAuthNotConfigured) {
+		return fmt.Errorf("%v: set client_id in %s or TEAMS_CLI_CLIENT_ID", err, s.account.authConfigPath())
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed in, tokens written to %s\n", filepath.Dir(s.account.tokenPath("skype")))
+	return nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testClientID = "test-client"
+	testTenant   = "contoso"
+)
+
+// fakeIdentityProvider serves the devicecode and token endpoints of one
+// tenant. token answers every token request; requests are recorded in order.
+type fakeIdentityProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	token  func(form url.Values) (int, interface{})
+
+	mu       sync.Mutex
+	requests []url.Values
+	polledAt []time.Time
+}
+
+func newFakeIdentityProvider(t *testing.T) *fakeIdentityProvider {
+	idp := &fakeIdentityProvider{t: t}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+testTenant+"/oauth2/v2.0/devicecode", func(w http.ResponseWriter, r *http.Request) {
+		form := idp.readForm(r)
+		if form.Get("client_id") != testClientID {
+			writeJSON(w, http.StatusBadRequest, oauthToken{Error: "invalid_client"})
+			return
+		}
+		writeJSON(w, http.StatusOK, deviceCodeResponse{
+			DeviceCode:      "device-123",
+			UserCode:        "ABCD-EFGH",
+			VerificationURI: "https://example.test/devicelogin",
+			ExpiresIn:       60,
+			Interval:        1,
+		})
+	})
+	mux.HandleFunc("/"+testTenant+"/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		form := idp.readForm(r)
+		idp.mu.Lock()
+		idp.polledAt = append(idp.polledAt, time.Now())
+		idp.mu.Unlock()
+		if form.Get("client_id") != testClientID {
+			writeJSON(w, http.StatusBadRequest, oauthToken{Error: "invalid_client"})
+			return
+		}
+		status, body := idp.token(form)
+		writeJSON(w, status, body)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *fakeIdentityProvider) readForm(r *http.Request) url.Values {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		idp.t.Errorf("reading request body: %v", err)
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		idp.t.Errorf("parsing form: %v", err)
+	}
+	idp.mu.Lock()
+	idp.requests = append(idp.requests, form)
+	idp.mu.Unlock()
+	return form
+}
+
+func (idp *fakeIdentityProvider) config() authConfig {
+	return authConfig{ClientID: testClientID, Tenant: testTenant, Authority: idp.server.URL}
+}
+
+func (idp *fakeIdentityProvider) tokenRequests() []url.Values {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	out := []url.Values{}
+	for _, form := range idp.requests {
+		if form.Get("grant_type") != "" {
+			out = append(out, form)
+		}
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// refreshTokenGrant issues "access-<kind>" for each scope in authScopes and
+// rotates the refresh token on every redemption.
+func refreshTokenGrant(form url.Values) (int, interface{}) {
+	if form.Get("grant_type") != "refresh_token" {
+		return http.StatusBadRequest, oauthToken{Error: "unsupported_grant_type"}
+	}
+	for _, target := range authScopes {
+		if form.Get("scope") == target.scope+" offline_access" {
+			return http.StatusOK, oauthToken{
+				AccessToken:  "access-" + target.kind,
+				RefreshToken: "refresh-after-" + target.kind,
+				ExpiresIn:    3600,
+			}
+		}
+	}
+	return http.StatusBadRequest, oauthToken{Error: "invalid_scope"}
+}
+
+func newTestSignInState(t *testing.T, authority string) *AppState {
+	t.Helper()
+	for _, env := range []string{"TEAMS_CLI_CLIENT_ID", "TEAMS_CLI_TENANT", "TEAMS_CLI_AUTHORITY", "TEAMS_CLI_AUTH_FLOW"} {
+		t.Setenv(env, "")
+	}
+	dir := t.TempDir()
+	cfg, err := json.Marshal(authConfig{ClientID: testClientID, Tenant: testTenant, Authority: authority, Flow: authFlowDevice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "teams-cli-auth.json"), cfg, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := &AppState{logger: logger, account: accountProfile{name: "work", dir: dir}}
+	s.settingsPath, s.settingsKey = s.account.settingsPaths()
+	return s
+}
+
+func TestDeviceCodeLoginPollsUntilIssued(t *testing.T) {
+	oldSlowDown := deviceCodeSlowDown
+	deviceCodeSlowDown = 500 * time.Millisecond
+	t.Cleanup(func() { deviceCodeSlowDown = oldSlowDown })
+
+	idp := newFakeIdentityProvider(t)
+	answers := []string{"authorization_pending", "slow_down"}
+	idp.token = func(form url.Values) (int, interface{}) {
+		if form.Get("grant_type") != deviceCodeGrant || form.Get("device_code") != "device-123" {
+			return http.StatusBadRequest, oauthToken{Error: "invalid_grant"}
+		}
+		if len(answers) > 0 {
+			answer := answers[0]
+			answers = answers[1:]
+			return http.StatusBadRequest, oauthToken{Error: answer}
+		}
+		return http.StatusOK, oauthToken{AccessToken: "access-skype", RefreshToken: "refresh-1"}
+	}
+
+	prompts := []string{}
+	token, err := deviceCodeLogin(context.Background(), idp.config(), authScopes[0].scope, func(text string) {
+		prompts = append(prompts, text)
+	})
+	if err != nil {
+		t.Fatalf("deviceCodeLogin: %v", err)
+	}
+	if token.AccessToken != "access-skype" || token.RefreshToken != "refresh-1" {
+		t.Fatalf("token = %+v", token)
+	}
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "ABCD-EFGH") || !strings.Contains(prompts[0], "https://example.test/devicelogin") {
+		t.Fatalf("prompts = %q", prompts)
+	}
+	if got := len(idp.polledAt); got != 3 {
+		t.Fatalf("token endpoint polled %d times, want 3", got)
+	}
+	if gap := idp.polledAt[2].Sub(idp.polledAt[1]); gap < time.Second+deviceCodeSlowDown {
+		t.Fatalf("poll after slow_down came after %v, want at least %v", gap, time.Second+deviceCodeSlowDown)
+	}
+}
+
+func TestDeviceCodeLoginStopsOnError(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	idp.token = func(form url.Values) (int, interface{}) {
+		return http.StatusBadRequest, oauthToken{Error: "expired_token", ErrorDescription: "AADSTS70020: expired\nTrace ID: 1"}
+	}
+	_, err := deviceCodeLogin(context.Background(), idp.config(), authScopes[0].scope, func(string) {})
+	if err == nil || err.Error() != "expired_token: AADSTS70020: expired" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestBrowserLoginExchangesCodeWithVerifier(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	var challenge, redirectURI string
+	idp.token = func(form url.Values) (int, interface{}) {
+		if form.Get("grant_type") != "authorization_code" || form.Get("code") != "code-456" {
+			return http.StatusBadRequest, oauthToken{Error: "invalid_grant"}
+		}
+		if form.Get("redirect_uri") != redirectURI {
+			return http.StatusBadRequest, oauthToken{Error: "invalid_grant", ErrorDescription: "redirect_uri mismatch"}
+		}
+		sum := sha256.Sum256([]byte(form.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			return http.StatusBadRequest, oauthToken{Error: "invalid_grant", ErrorDescription: "code_verifier mismatch"}
+		}
+		return http.StatusOK, oauthToken{AccessToken: "access-skype", RefreshToken: "refresh-1"}
+	}
+
+	oldOpen := openSignInPage
+	t.Cleanup(func() { openSignInPage = oldOpen })
+	openSignInPage = func(link string) error {
+		authURL, err := url.Parse(link)
+		if err != nil {
+			return err
+		}
+		query := authURL.Query()
+		if authURL.Path != "/"+testTenant+"/oauth2/v2.0/authorize" || query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testClientID {
+			t.Errorf("unexpected authorize address %s", link)
+		}
+		challenge = query.Get("code_challenge")
+		redirectURI = query.Get("redirect_uri")
+		go func() {
+			// A redirect with the wrong state is ignored.
+			resp, err := http.Get(redirectURI + "?" + url.Values{"code": {"forged"}, "state": {"other"}}.Encode())
+			if err != nil {
+				t.Errorf("forged redirect: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("forged redirect answered %s", resp.Status)
+			}
+			resp, err = http.Get(redirectURI + "?" + url.Values{"code": {"code-456"}, "state": {query.Get("state")}}.Encode())
+			if err != nil {
+				t.Errorf("redirect: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	token, err := browserLogin(ctx, idp.config(), authScopes[0].scope, func(string) {})
+	if err != nil {
+		t.Fatalf("browserLogin: %v", err)
+	}
+	if token.AccessToken != "access-skype" {
+		t.Fatalf("token = %+v", token)
+	}
+	if got := len(idp.tokenRequests()); got != 1 {
+		t.Fatalf("token endpoint called %d times, want 1", got)
+	}
+}
+
+func TestNativeSignInRedeemsRefreshTokenForEveryScope(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	idp.token = refreshTokenGrant
+	s := newTestSignInState(t, idp.server.URL)
+	cfg, err := loadAuthConfig(s.account)
+	if err != nil {
+		t.Fatalf("loadAuthConfig: %v", err)
+	}
+	if err = s.saveStoredAuth(cfg, "stored-refresh"); err != nil {
+		t.Fatalf("saveStoredAuth: %v", err)
+	}
+
+	if err = s.nativeSignIn(context.Background(), signInOptions{silent: true}); err != nil {
+		t.Fatalf("nativeSignIn: %v", err)
+	}
+
+	requests := idp.tokenRequests()
+	if len(requests) != len(authScopes) {
+		t.Fatalf("token endpoint called %d times, want %d", len(requests), len(authScopes))
+	}
+	for i, target := range authScopes {
+		if got := requests[i].Get("scope"); got != target.scope+" offline_access" {
+			t.Errorf("request %d scope = %q, want %s", i, got, target.scope)
+		}
+		// The stored refresh token goes first, then the latest rotated one.
+		wantRefresh := "stored-refresh"
+		if i > 0 {
+			wantRefresh = "refresh-after-" + authScopes[i-1].kind
+		}
+		if got := requests[i].Get("refresh_token"); got != wantRefresh {
+			t.Errorf("request %d refresh_token = %q, want %q", i, got, wantRefresh)
+		}
+		data, err := os.ReadFile(filepath.Join(s.account.dir, fmt.Sprintf("token-%s.jwt", target.kind)))
+		if err != nil {
+			t.Fatalf("token file for %s: %v", target.kind, err)
+		}
+		if string(data) != "access-"+target.kind {
+			t.Errorf("token-%s.jwt = %q", target.kind, data)
+		}
+	}
+	if got := s.loadStoredAuth(cfg); got != "refresh-after-teams" {
+		t.Errorf("stored refresh token = %q, want the last rotated one", got)
+	}
+}
+
+func TestNativeSignInWithoutRefreshToken(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	idp.token = func(form url.Values) (int, interface{}) {
+		return http.StatusOK, oauthToken{AccessToken: "access-skype"}
+	}
+	s := newTestSignInState(t, idp.server.URL)
+
+	err := s.nativeSignIn(context.Background(), signInOptions{fresh: true})
+	if err == nil || !strings.Contains(err.Error(), "no refresh token") {
+		t.Fatalf("err = %v, want a missing refresh token error", err)
+	}
+	for _, target := range authScopes {
+		if _, err := os.Stat(s.account.tokenPath(target.kind)); !os.IsNotExist(err) {
+			t.Errorf("token-%s.jwt was written: %v", target.kind, err)
+		}
+	}
+}
+
+func TestNativeSignInSilentWithoutStoredAuth(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	idp.token = refreshTokenGrant
+	s := newTestSignInState(t, idp.server.URL)
+
+	if err := s.nativeSignIn(context.Background(), signInOptions{silent: true}); err == nil {
+		t.Fatal("silent sign-in without a stored refresh token succeeded")
+	}
+	if got := len(idp.tokenRequests()); got != 0 {
+		t.Fatalf("token endpoint called %d times, want 0", got)
+	}
+}
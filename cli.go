@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	teams_api "github.com/fossteams/teams-api"
+)
+
+const cliUsage = `Usage:
+  teams-cli [--profile <name>]
+                            start the terminal client; without --profile a
+                            picker asks which account to use when there are
+                            several
+  teams-cli open <url>      start and jump to a Teams chat, channel or message link
+  teams-cli chat new [--topic <topic>] <person>...
+                            create a chat and print its id and link; a person is
+                            an email address, an MRI or a contact name
+  teams-cli login [--device | --browser]
+                            sign in with the client configured in
+                            teams-cli-auth.json and write the token files
+
+Options:
+  --profile <name>          use the account profile in
+                            ~/.config/fossteams/profiles/<name>; "default" is
+                            ~/.config/fossteams`
+
+// cliOptions holds what the command line asked for before the UI starts.
+type cliOptions struct {
+	profile  string
+	openLink string
+	newChat  *newChatOptions
+	login    *loginOptions
+	help     bool
+}
+
+// loginOptions is a "login" command; flow is empty for the configured flow.
+type loginOptions struct {
+	flow string
+}
+
+// newChatOptions is a "chat new" command, run without the UI.
+type newChatOptions struct {
+	topic  string
+	people []string
+}
+
+func parseCommandLine(args []string) (cliOptions, error) {
+	opts := cliOptions{}
+	args, profile, err := extractProfileFlag(args)
+	if err != nil {
+		return opts, err
+	}
+	opts.profile = profile
+	if len(args) == 0 {
+		return opts, nil
+	}
+	switch args[0] {
+	case "-h", "--help", "help":
+		opts.help = true
+		return opts, nil
+	case "open":
+		if len(args) != 2 || strings.TrimSpace(args[1]) == "" {
+			return opts, fmt.Errorf("open expects exactly one Teams link")
+		}
+		if _, ok := parseTeamsDeepLink(args[1]); !ok {
+			return opts, fmt.Errorf("not a Teams chat, channel or message link: %s", args[1])
+		}
+		opts.openLink = strings.TrimSpace(args[1])
+		return opts, nil
+	case "chat":
+		if len(args) < 2 || args[1] != "new" {
+			return opts, fmt.Errorf("chat expects the new subcommand")
+		}
+		newChat, err := parseNewChatArgs(args[2:])
+		if err != nil {
+			return opts, err
+		}
+		opts.newChat = &newChat
+		return opts, nil
+	case "login":
+		login := loginOptions{}
+		for _, arg := range args[1:] {
+			switch arg {
+			case "--device":
+				login.flow = authFlowDevice
+			case "--browser":
+				login.flow = authFlowBrowser
+			default:
+				return opts, fmt.Errorf("unknown login option %q", arg)
+			}
+		}
+		opts.login = &login
+		return opts, nil
+	}
+	return opts, fmt.Errorf("unknown command %q", args[0])
+}
+
+// extractProfileFlag removes --profile from args, wherever it appears, and
+// returns the profile name.
+func extractProfileFlag(args []string) ([]string, string, error) {
+	rest := []string{}
+	profile := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--profile" || arg == "-p":
+			if i+1 >= len(args) || strings.TrimSpace(args[i+1]) == "" {
+				return nil, "", fmt.Errorf("%s expects a profile name", arg)
+			}
+			i++
+			profile = strings.TrimSpace(args[i])
+		case strings.HasPrefix(arg, "--profile="):
+			profile = strings.TrimSpace(strings.TrimPrefix(arg, "--profile="))
+			if profile == "" {
+				return nil, "", fmt.Errorf("--profile expects a profile name")
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if profile != "" {
+		if _, err := profileByName(profile); err != nil {
+			return nil, "", err
+		}
+	}
+	return rest, profile, nil
+}
+
+func parseNewChatArgs(args []string) (newChatOptions, error) {
+	opts := newChatOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--topic" || arg == "-t":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("%s expects a topic", arg)
+			}
+			i++
+			opts.topic = strings.TrimSpace(args[i])
+		case strings.HasPrefix(arg, "--topic="):
+			opts.topic = strings.TrimSpace(strings.TrimPrefix(arg, "--topic="))
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return opts, fmt.Errorf("unknown option %q", arg)
+		case strings.TrimSpace(arg) != "":
+			opts.people = append(opts.people, strings.TrimSpace(arg))
+		}
+	}
+	if len(opts.people) == 0 {
+		return opts, fmt.Errorf("chat new expects at least one person")
+	}
+	return opts, nil
+}
+
+// connectHeadless loads the Teams client and state for commands that run
+// without the UI.
+func (s *AppState) connectHeadless() error {
+	if err := s.account.useTokens(); err != nil {
+		return err
+	}
+	var err error
+	s.teamsClient, err = teams_api.New()
+	if err != nil {
+		return fmt.Errorf("unable to initialize Teams client: %v", err)
+	}
+	s.TeamsState.logger = s.logger
+	return s.TeamsState.init(s.teamsClient)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/rivo/tview"
+	"golang.org/x/net/html"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	pageCodeBlocks = "pageCodeBlocks"
+
+	// Many terminals drop OSC 52 sequences longer than this.
+	osc52MaxEncodedBytes = 100000
+
+	// composeNewlineMarker stands in for line breaks in the single-line compose
+	// field and is turned back into newlines when sending.
+	composeNewlineMarker = "⏎"
+)
+
+// clipboardCommands are tried in order; the first one installed receives the
+// text on stdin.
+var clipboardCommands = [][]string{
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"pbcopy"},
+}
+
+var pasteCommands = [][]string{
+	{"wl-paste", "--no-newline"},
+	{"xclip", "-selection", "clipboard", "-o"},
+	{"xsel", "--clipboard", "--output"},
+	{"pbpaste"},
+}
+
+func isRemoteSession() bool {
+	return strings.TrimSpace(os.Getenv("SSH_TTY")) != "" || strings.TrimSpace(os.Getenv("SSH_CONNECTION")) != ""
+}
+
+// clipboardToolAvailable skips Wayland tools outside a Wayland session, where
+// they fail instead of falling through to X11.
+func clipboardToolAvailable(name string) bool {
+	if !commandExists(name) {
+		return false
+	}
+	if strings.HasPrefix(name, "wl-") && strings.TrimSpace(os.Getenv("WAYLAND_DISPLAY")) == "" {
+		return false
+	}
+	return true
+}
+
+// osc52Sequence asks the terminal to set its clipboard. Inside tmux the
+// sequence is wrapped so tmux passes it on to the outer terminal.
+func osc52Sequence(text string) (string, error) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(text))
+	if len(encoded) > osc52MaxEncodedBytes {
+		return "", fmt.Errorf("text too long for OSC 52")
+	}
+	seq := "\x1b]52;c;" + encoded + "\a"
+	if strings.TrimSpace(os.Getenv("TMUX")) != "" {
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	return seq, nil
+}
+
+func writeOSC52(text string) error {
+	seq, err := osc52Sequence(text)
+	if err != nil {
+		return err
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	_, err = tty.WriteString(seq)
+	return err
+}
+
+func copyWithClipboardTool(text string) error {
+	for _, candidate := range clipboardCommands {
+		if !clipboardToolAvailable(candidate[0]) {
+			continue
+		}
+		cmd := exec.Command(candidate[0], candidate[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %v", candidate[0], err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard tool found (install wl-copy, xclip or xsel)")
+}
+
+// copyToClipboard copies text with a clipboard tool in local sessions and with
+// OSC 52, which also works over SSH, otherwise or when no tool is installed.
+// confirmed is false when the text only went out as OSC 52: the terminal may
+// have ignored it. An error is returned only when neither worked.
+func copyToClipboard(text string) (confirmed bool, err error) {
+	if !isRemoteSession() {
+		toolErr := copyWithClipboardTool(text)
+		if toolErr == nil {
+			return true, nil
+		}
+		if writeOSC52(text) == nil {
+			return false, nil
+		}
+		return false, toolErr
+	}
+	oscErr := writeOSC52(text)
+	if oscErr == nil {
+		return false, nil
+	}
+	if copyWithClipboardTool(text) == nil {
+		return true, nil
+	}
+	return false, oscErr
+}
+
+// copyWithStatus copies text and reports it in the compose title as "Copied
+// <what>", or as sent to the terminal when the copy could not be confirmed.
+func (s *AppState) copyWithStatus(text, what string) {
+	confirmed, err := copyToClipboard(text)
+	switch {
+	case err != nil:
+		s.setComposeStatus("Copy failed: " + err.Error())
+	case confirmed:
+		s.setComposeStatus("Copied " + what)
+	default:
+		s.setComposeStatus("Sent " + what + " to the terminal clipboard (OSC 52)")
+	}
+}
+
+func readClipboard() (string, error) {
+	for _, candidate := range pasteCommands {
+		if !clipboardToolAvailable(candidate[0]) {
+			continue
+		}
+		out, err := exec.Command(candidate[0], candidate[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", candidate[0], err)
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("no clipboard tool found (install wl-paste, xclip or xsel)")
+}
+
+// messagePermalink builds the Teams web link of msg. Channel replies carry the
+// root post in their conversation id and link to it as the parent message.
+func messagePermalink(msg csa.ChatMessage) (string, error) {
+	conversationID := strings.TrimSpace(msg.ConversationId)
+	messageID := strings.TrimSpace(msg.Id)
+	if conversationID == "" || messageID == "" {
+		return "", fmt.Errorf("message has no id")
+	}
+	query := url.Values{}
+	if idx := strings.Index(conversationID, ";messageid="); idx >= 0 {
+		query.Set("parentMessageId", conversationID[idx+len(";messageid="):])
+		conversationID = conversationID[:idx]
+	}
+	if created := time.Time(msg.ComposeTime); !created.IsZero() {
+		query.Set("createdTime", fmt.Sprintf("%d", created.UnixMilli()))
+	}
+	link := "https://teams.microsoft.com/l/message/" + url.PathEscape(conversationID) + "/" + url.PathEscape(messageID)
+	if encoded := query.Encode(); encoded != "" {
+		link += "?" + encoded
+	}
+	return link, nil
+}
+
+// extractCodeBlocks returns the text of every outermost <pre> or <code> element
+// in content, keeping line breaks.
+func extractCodeBlocks(content string) []string {
+	blocks := []string{}
+	var current strings.Builder
+	depth := 0
+	z := html.NewTokenizer(bytes.NewBufferString(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		name, _ := z.TagName()
+		tag := string(name)
+		switch tt {
+		case html.StartTagToken:
+			if tag == "pre" || tag == "code" {
+				depth++
+			} else if depth > 0 && tag == "br" {
+				current.WriteString("\n")
+			}
+		case html.SelfClosingTagToken:
+			if depth > 0 && tag == "br" {
+				current.WriteString("\n")
+			}
+		case html.EndTagToken:
+			if (tag == "pre" || tag == "code") && depth > 0 {
+				depth--
+				if depth == 0 {
+					if block := strings.Trim(current.String(), "\n"); strings.TrimSpace(block) != "" {
+						blocks = append(blocks, block)
+					}
+					current.Reset()
+				}
+			} else if depth > 0 && (tag == "div" || tag == "p") {
+				current.WriteString("\n")
+			}
+		case html.TextToken:
+			if depth > 0 {
+				current.Write(z.Text())
+			}
+		}
+	}
+	return blocks
+}
+
+func (s *AppState) yankSelectedPermalink() {
+	msg, ok := s.selectedChatMessage()
+	if !ok {
+		s.setComposeStatus("Select a message first")
+		return
+	}
+	link, err := messagePermalink(msg)
+	if err != nil {
+		s.setComposeStatus("Copy failed: " + err.Error())
+		return
+	}
+	s.copyWithStatus(link, "message link")
+}
+
+// yankSelectedCodeBlock copies the code block of the selected message, asking
+// which one when there are several.
+func (s *AppState) yankSelectedCodeBlock() {
+	msg, ok := s.selectedChatMessage()
+	if !ok {
+		s.setComposeStatus("Select a message first")
+		return
+	}
+	blocks := extractCodeBlocks(msg.Content)
+	copyBlock := func(block string) {
+		s.copyWithStatus(block, fmt.Sprintf("code block (%d lines)", strings.Count(block, "\n")+1))
+	}
+	switch len(blocks) {
+	case 0:
+		s.setComposeStatus("No code block in message")
+	case 1:
+		copyBlock(blocks[0])
+	default:
+		items := make([]pickerItem, 0, len(blocks))
+		for i, block := range blocks {
+			first := strings.TrimSpace(strings.SplitN(block, "\n", 2)[0])
+			items = append(items, pickerItem{
+				label:     fmt.Sprintf("%d. %s", i+1, first),
+				secondary: fmt.Sprintf("%d lines", strings.Count(block, "\n")+1),
+				value:     block,
+			})
+		}
+		s.showPicker(pageCodeBlocks, "Copy code block", items, func(item pickerItem) {
+			copyBlock(item.value.(string))
+		})
+	}
+}
+
+// pasteIntoCompose appends the clipboard to the compose text. Line breaks are
+// kept as composeNewlineMarker so Enter does not send each line separately.
+func (s *AppState) pasteIntoCompose() bool {
+	composeView, ok := s.components[ViCompose].(*tview.InputField)
+	if !ok || s.app.GetFocus() != composeView {
+		return false
+	}
+	text, err := readClipboard()
+	if err != nil {
+		s.setComposeStatus("Paste failed: " + err.Error())
+		return true
+	}
+	text = strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		s.setComposeStatus("Clipboard is empty")
+		return true
+	}
+	lines := strings.Count(text, "\n") + 1
+	composeView.SetText(composeView.GetText() + strings.ReplaceAll(text, "\n", composeNewlineMarker))
+	if lines > 1 {
+		s.setComposeStatus(fmt.Sprintf("Pasted %d lines", lines))
+	}
+	return true
+}
+
+// expandComposeNewlines turns pasted line break markers back into newlines.
+func expandComposeNewlines(text string) string {
+	return strings.ReplaceAll(text, composeNewlineMarker, "\n")
+}
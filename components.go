@@ -6,6 +6,9 @@ const (
 	BtErrorAuth   = "btErrorAuth"
 	FlErrorBody   = "flErrorBody"
 	FlErrorAction = "flErrorAction"
+	FlMain        = "flMain"
+	FlTree        = "flTree"
+	FrMain        = "frMain"
 )
 
 // Trees
@@ -16,8 +19,10 @@ const (
 // Generic Views
 
 const (
-	ViChat    = "viChat"
-	ViCompose = "viCompose"
+	ViChat       = "viChat"
+	ViCompose    = "viCompose"
+	ViMembers    = "viMembers"
+	ViTreeFilter = "viTreeFilter"
 )
 
 // Pages
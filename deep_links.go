@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/rivo/tview"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	pageGoToLink = "pageGoToLink"
+
+	// maxHistoryPages bounds how far back a linked message is searched.
+	maxHistoryPages = 10
+)
+
+// messageTarget is a linked message to select once its conversation renders.
+type messageTarget struct {
+	ids       []string
+	messageID string
+	fetched   bool
+}
+
+// openDeepLink opens the chat or channel of a Teams link and, for message links,
+// selects the message. Conversations missing from the tree are loaded by id.
+func (s *AppState) openDeepLink(link string) error {
+	deepLink, ok := parseTeamsDeepLink(link)
+	if !ok {
+		return fmt.Errorf("not a Teams chat, channel or message link")
+	}
+	node := s.findConversationNode(deepLink.conversationID)
+	s.openTeamsDeepLink(deepLink, node)
+	return nil
+}
+
+func (s *AppState) openTeamsDeepLink(deepLink teamsDeepLink, node *tview.TreeNode) {
+	ids := []string{deepLink.conversationID}
+	title := "Linked conversation"
+	if node != nil {
+		if nodeIDs, nodeTitle, ok := treeNodeTarget(node); ok {
+			ids = append(append([]string(nil), nodeIDs...), deepLink.conversationID)
+			title = nodeTitle
+		}
+	}
+	if deepLink.messageID != "" {
+		s.linkTargetMu.Lock()
+		s.linkTarget = &messageTarget{ids: ids, messageID: deepLink.messageID}
+		s.linkTargetMu.Unlock()
+		s.setComposeStatus("Looking for linked message")
+	}
+	if node != nil {
+		s.openQuickOpenNode(node)
+		return
+	}
+	s.setSettingsMode(false)
+	s.setActiveConversation(nil, ids, title)
+	go s.openConversationByIDs(nil, ids, title)
+}
+
+func (s *AppState) promptGoToLink() {
+	s.promptText(pageGoToLink, "Go to link", "Teams link: ", "", func(text string, ok bool) {
+		text = strings.TrimSpace(text)
+		if !ok || text == "" {
+			return
+		}
+		if err := s.openDeepLink(text); err != nil {
+			s.setComposeStatus("Go to link: " + err.Error())
+		}
+	})
+}
+
+func idsOverlap(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if normalizeFavoriteKey(x) == normalizeFavoriteKey(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyPendingMessageTarget selects the linked message after ids rendered. When
+// it is not loaded, older pages are fetched once and the conversation reloaded.
+func (s *AppState) applyPendingMessageTarget(selectedNode *tview.TreeNode, ids []string, displayName string) {
+	s.linkTargetMu.Lock()
+	target := s.linkTarget
+	if target == nil || !idsOverlap(target.ids, ids) {
+		s.linkTargetMu.Unlock()
+		return
+	}
+	msgIdx := -1
+	for i, message := range s.currentChatMessages() {
+		if strings.TrimSpace(message.Id) == target.messageID {
+			msgIdx = i
+			break
+		}
+	}
+	if msgIdx >= 0 || target.fetched {
+		s.linkTarget = nil
+		s.linkTargetMu.Unlock()
+		s.app.QueueUpdateDraw(func() {
+			if msgIdx < 0 || !s.selectChatMessageIndex(msgIdx) {
+				s.setComposeStatus("Linked message not found")
+				return
+			}
+			s.app.SetFocus(s.components[ViChat])
+			s.setComposeStatus("")
+		})
+		return
+	}
+	target.fetched = true
+	s.linkTargetMu.Unlock()
+
+	go func() {
+		found, err := s.fetchHistoryUntil(ids, target.messageID)
+		if err != nil {
+			s.logger.WithError(err).WithField("message_id", target.messageID).Warn("unable to fetch older messages")
+		}
+		if !found {
+			s.logger.WithField("message_id", target.messageID).Debug("linked message not in fetched history")
+		}
+		s.loadConversationsByIDs(selectedNode, ids, displayName)
+	}()
+}
+
+// fetchHistoryUntil pages back through the first working conversation id until
+// messageID shows up, keeping every fetched page for later renders.
+func (s *AppState) fetchHistoryUntil(ids []string, messageID string) (bool, error) {
+	var lastErr error
+	for _, id := range normalizeConversationIDs(ids) {
+		endpoint := conversationMessagesEndpoint(id)
+
+		fetched := []csa.ChatMessage{}
+		found := false
+		for page := 0; page < maxHistoryPages && endpoint != ""; page++ {
+			body, err := s.chatServiceRequestBody(http.MethodGet, endpoint, nil)
+			if err != nil {
+				lastErr = err
+				break
+			}
+			var response csa.MessagesResponse
+			if err := json.Unmarshal(body, &response); err != nil {
+				lastErr = fmt.Errorf("invalid messages response: %v", err)
+				break
+			}
+			fetched = append(fetched, response.Messages...)
+			for _, message := range response.Messages {
+				if strings.TrimSpace(message.Id) == messageID {
+					found = true
+				}
+			}
+			if found {
+				break
+			}
+			endpoint = strings.TrimSpace(response.Metadata.BackwardLink)
+		}
+		if len(fetched) > 0 {
+			s.storeMessageHistory(id, fetched)
+			return found, nil
+		}
+	}
+	return false, lastErr
+}
+
+func (s *AppState) storeMessageHistory(conversationID string, messages []csa.ChatMessage) {
+	key := normalizeFavoriteKey(conversationID)
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	if s.messageHistory == nil {
+		s.messageHistory = map[string][]csa.ChatMessage{}
+	}
+	s.messageHistory[key] = mergeMessages(s.messageHistory[key], messages)
+}
+
+// withMessageHistory adds previously fetched older pages of ids to messages.
+func (s *AppState) withMessageHistory(ids []string, messages []csa.ChatMessage) []csa.ChatMessage {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	for _, id := range ids {
+		if history := s.messageHistory[normalizeFavoriteKey(id)]; len(history) > 0 {
+			messages = mergeMessages(messages, history)
+		}
+	}
+	return messages
+}
+
+// mergeMessages combines two message lists without duplicates, oldest first.
+// Entries of current win over older copies of the same message.
+func mergeMessages(current, older []csa.ChatMessage) []csa.ChatMessage {
+	seen := map[string]struct{}{}
+	out := make([]csa.ChatMessage, 0, len(current)+len(older))
+	for _, list := range [][]csa.ChatMessage{current, older} {
+		for _, message := range list {
+			id := strings.TrimSpace(message.Id)
+			if id != "" {
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+			}
+			out = append(out, message)
+		}
+	}
+	sort.Stable(csa.SortMessageByTime(out))
+	return out
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"sort"
+)
+
+func (s *AppState) isChannelFavorite(channelID string) bool {
+	key := normalizeFavoriteKey(channelID)
+	s.chatFavoritesMu.RLock()
+	defer s.chatFavoritesMu.RUnlock()
+	return key != "" && s.chatFavorites[key]
+}
+
+// rebuildChatGroups shows the Favorites and Recent groups under Chats while
+// they have children.
+func (s *AppState) rebuildChatGroups() {
+	if s.tree.chats == nil || s.tree.favorites == nil || s.tree.recent == nil {
+		return
+	}
+	s.tree.chats.ClearChildren()
+	if len(s.tree.favorites.GetChildren()) > 0 {
+		s.tree.chats.AddChild(s.tree.favorites)
+	}
+	if len(s.tree.recent.GetChildren()) > 0 {
+		s.tree.chats.AddChild(s.tree.recent)
+	}
+	s.refreshTreeView()
+}
+
+func (s *AppState) newFavoriteChannelNode(channelID string) *tview.TreeNode {
+	ref, ok := s.channelShortcutRef(channelID)
+	if !ok {
+		return nil
+	}
+	ref.isFavorite = true
+	node := tview.NewTreeNode(ref.treeTitle())
+	node.SetReference(ref)
+	node.SetColor(tcell.ColorGreen)
+	return node
+}
+
+// renderFavoriteChannels adds the favorite channels to Favorites next to the
+// favorite chats and applies the saved order.
+func (s *AppState) renderFavoriteChannels() {
+	if s.tree.favorites == nil {
+		return
+	}
+	for _, teamNode := range s.tree.teamNodes {
+		for _, channelNode := range s.tree.channelNodes[teamNode] {
+			ref, ok := channelNode.GetReference().(channelRef)
+			if !ok || !s.isChannelFavorite(ref.channel.Id) {
+				continue
+			}
+			if node := s.newFavoriteChannelNode(ref.channel.Id); node != nil {
+				s.tree.favorites.AddChild(node)
+			}
+		}
+	}
+	s.sortFavoriteNodes()
+	s.rebuildChatGroups()
+	refreshTreeUnreadLabels(s.tree.root)
+}
+
+// sortFavoriteNodes orders Favorites by the saved order. Favorites without a
+// saved position keep their relative order at the end.
+func (s *AppState) sortFavoriteNodes() {
+	s.chatFavoritesMu.RLock()
+	position := map[string]int{}
+	for i, key := range s.favoriteOrder {
+		position[key] = i
+	}
+	s.chatFavoritesMu.RUnlock()
+	children := append([]*tview.TreeNode(nil), s.tree.favorites.GetChildren()...)
+	rank := func(node *tview.TreeNode) int {
+		if i, ok := position[conversationNodeKey(node)]; ok {
+			return i
+		}
+		return len(position)
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return rank(children[i]) < rank(children[j])
+	})
+	s.tree.favorites.SetChildren(children)
+}
+
+// toggleChannelFavorite stars or unstars a channel. Favorite channels are
+// listed under Favorites as well as under their team.
+func (s *AppState) toggleChannelFavorite(treeView *tview.TreeView, selected *tview.TreeNode, ref channelRef) {
+	channelID := ref.channel.Id
+	favorite := s.toggleChatFavorite(channelID, s.isChannelFavorite(channelID))
+	if favorite {
+		if node := s.newFavoriteChannelNode(channelID); node != nil {
+			s.tree.favorites.AddChild(node)
+		}
+		s.setComposeStatus("Added " + ref.channel.DisplayName + " to favorites")
+	} else {
+		for _, child := range s.tree.favorites.GetChildren() {
+			if other, ok := child.GetReference().(channelRef); ok && other.channel.Id == channelID {
+				s.tree.favorites.RemoveChild(child)
+			}
+		}
+		if ref.isFavorite {
+			selected = s.findConversationNode(channelID)
+		}
+		s.setComposeStatus("Removed " + ref.channel.DisplayName + " from favorites")
+	}
+	s.rebuildChatGroups()
+	refreshTreeUnreadLabels(treeView.GetRoot())
+	if selected != nil {
+		treeView.SetCurrentNode(selected)
+	}
+}
+
+// moveFavorite moves the selected favorite up (delta -1) or down (delta 1) and
+// saves the new order.
+func (s *AppState) moveFavorite(delta int) bool {
+	treeView, ok := s.components[TrChat].(*tview.TreeView)
+	if !ok || s.tree.favorites == nil {
+		return false
+	}
+	selected := treeView.GetCurrentNode()
+	children := append([]*tview.TreeNode(nil), s.tree.favorites.GetChildren()...)
+	idx := -1
+	for i, child := range children {
+		if child == selected {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		s.setComposeStatus("Select a favorite first")
+		return true
+	}
+	target := idx + delta
+	if target < 0 || target >= len(children) {
+		return true
+	}
+	children[idx], children[target] = children[target], children[idx]
+	s.tree.favorites.SetChildren(children)
+	s.refreshTreeView()
+
+	order := make([]string, 0, len(children))
+	for _, child := range children {
+		if key := conversationNodeKey(child); key != "" {
+			order = append(order, key)
+		}
+	}
+	s.chatFavoritesMu.Lock()
+	s.favoriteOrder = order
+	s.chatFavoritesMu.Unlock()
+	s.persistEncryptedChatSettings()
+	treeView.SetCurrentNode(selected)
+	return true
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pageViewMode   = "pageViewMode"
+	pageActiveDays = "pageActiveDays"
+
+	treeViewAll       = "all"
+	treeViewUnread    = "unread"
+	treeViewFavorites = "favorites"
+	treeViewMentions  = "mentions"
+	treeViewActive    = "active"
+
+	defaultActiveDays = 7
+)
+
+// treeViewModes is the order view modes are cycled in.
+var treeViewModes = []string{treeViewAll, treeViewUnread, treeViewFavorites, treeViewMentions, treeViewActive}
+
+var activeDayChoices = []int{1, 3, 7, 14, 30}
+
+func normalizeTreeViewMode(mode string) string {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	for _, known := range treeViewModes {
+		if mode == known {
+			return mode
+		}
+	}
+	return treeViewAll
+}
+
+func treeViewModeTitle(mode string, days int) string {
+	switch mode {
+	case treeViewUnread:
+		return "Unread only"
+	case treeViewFavorites:
+		return "Favorites only"
+	case treeViewMentions:
+		return "Mentions only"
+	case treeViewActive:
+		if days == 1 {
+			return "Active in last day"
+		}
+		return fmt.Sprintf("Active in last %d days", days)
+	}
+	return "All conversations"
+}
+
+func (s *AppState) treeViewState() (filter, mode string, days int) {
+	s.treeFilterMu.RLock()
+	defer s.treeFilterMu.RUnlock()
+	days = s.activeDays
+	if days <= 0 {
+		days = defaultActiveDays
+	}
+	return s.treeFilter, normalizeTreeViewMode(s.treeViewMode), days
+}
+
+func (s *AppState) isTreeFiltered() bool {
+	filter, mode, _ := s.treeViewState()
+	return strings.TrimSpace(filter) != "" || mode != treeViewAll
+}
+
+func (s *AppState) formatTreeViewModeLine() string {
+	_, mode, days := s.treeViewState()
+	return treeViewModeTitle(mode, days)
+}
+
+func (s *AppState) formatActiveDaysLine() string {
+	_, _, days := s.treeViewState()
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// treeMatcher decides which chats and channels a filtered tree shows.
+type treeMatcher struct {
+	s     *AppState
+	terms []string
+	mode  string
+	since time.Time
+	chats map[string]csa.Chat
+	// keep is shown whatever the view mode, so the open conversation does not
+	// vanish once it has been read.
+	keep *tview.TreeNode
+}
+
+func (m treeMatcher) matches(node *tview.TreeNode, team string) bool {
+	var (
+		name             string
+		unread, favorite bool
+		mentions         int
+		activity         time.Time
+	)
+	switch ref := node.GetReference().(type) {
+	case conversationRef:
+		if ref.chatKey == settingsHelpChatKey {
+			return len(m.terms) == 0
+		}
+		name = ref.title
+		unread = ref.isUnread && !ref.isMuted
+		favorite = ref.isFavorite
+		mentions = ref.mentionCount
+		if chat, ok := m.chats[normalizeFavoriteKey(ref.chatKey)]; ok {
+			activity = chatLastActivity(chat)
+		}
+	case channelRef:
+		name = ref.channel.DisplayName
+		if ref.teamName != "" {
+			team = ref.teamName
+		}
+		unread = ref.isUnread && !ref.isMuted
+		favorite = m.s.isChannelFavorite(ref.channel.Id)
+		mentions = ref.mentionCount
+		activity = channelLastActivity(ref.channel)
+	default:
+		return false
+	}
+
+	if node != m.keep || len(m.terms) > 0 {
+		switch m.mode {
+		case treeViewUnread:
+			if !unread {
+				return false
+			}
+		case treeViewFavorites:
+			if !favorite {
+				return false
+			}
+		case treeViewMentions:
+			if mentions == 0 {
+				return false
+			}
+		case treeViewActive:
+			if activity.Before(m.since) {
+				return false
+			}
+		}
+	}
+	text := strings.ToLower(team + " " + name)
+	for _, term := range m.terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the part of the subtree below node that matches. Chats and
+// channels are shared with the full tree; groups and teams are copied so the
+// full tree keeps its children.
+func (m treeMatcher) filter(node *tview.TreeNode, team string, root bool) *tview.TreeNode {
+	switch ref := node.GetReference().(type) {
+	case conversationRef, channelRef:
+		if m.matches(node, team) {
+			return node
+		}
+		return nil
+	case csa.Team:
+		team = ref.DisplayName
+	}
+	children := []*tview.TreeNode{}
+	for _, child := range node.GetChildren() {
+		if match := m.filter(child, team, false); match != nil {
+			children = append(children, match)
+		}
+	}
+	if len(children) == 0 && !root {
+		return nil
+	}
+	clone := tview.NewTreeNode(node.GetText()).
+		SetReference(node.GetReference()).
+		SetColor(node.GetColor())
+	clone.SetChildren(children)
+	return clone
+}
+
+// treeNodeIdentity names the conversation, group or team behind node so the
+// selection can move between the full and the filtered tree.
+func treeNodeIdentity(node *tview.TreeNode) string {
+	if node == nil {
+		return ""
+	}
+	if key := conversationNodeKey(node); key != "" {
+		return "conversation:" + key
+	}
+	switch ref := node.GetReference().(type) {
+	case groupRef:
+		return "group:" + ref.name
+	case csa.Team:
+		return "team:" + ref.Id
+	}
+	return ""
+}
+
+// equivalentTreeNode returns node when it is part of the tree below root,
+// otherwise the first node of the same conversation, group or team, or root.
+func equivalentTreeNode(root, node *tview.TreeNode) *tview.TreeNode {
+	if node == nil {
+		return root
+	}
+	identity := treeNodeIdentity(node)
+	var same *tview.TreeNode
+	found := false
+	root.Walk(func(other, parent *tview.TreeNode) bool {
+		if other == node {
+			found = true
+			return false
+		}
+		if same == nil && identity != "" && treeNodeIdentity(other) == identity {
+			same = other
+		}
+		return true
+	})
+	switch {
+	case found:
+		return node
+	case same != nil:
+		return same
+	}
+	return root
+}
+
+// renderTreeView shows the full tree, or a copy of it reduced to the chats and
+// channels matching the filter text and view mode. Actions keep working on the
+// full tree in s.tree.
+func (s *AppState) renderTreeView() {
+	treeView, ok := s.components[TrChat].(*tview.TreeView)
+	if !ok || s.tree.root == nil {
+		return
+	}
+	s.updateTreeFilterBar()
+	current := treeView.GetCurrentNode()
+	if !s.isTreeFiltered() {
+		if s.tree.view == nil {
+			return
+		}
+		s.tree.view = nil
+		treeView.SetRoot(s.tree.root)
+		refreshTreeUnreadLabels(s.tree.root)
+		treeView.SetCurrentNode(equivalentTreeNode(s.tree.root, current))
+		return
+	}
+
+	filter, mode, days := s.treeViewState()
+	_, _, active := s.getActiveConversation()
+	matcher := treeMatcher{
+		s:     s,
+		terms: strings.Fields(strings.ToLower(filter)),
+		mode:  mode,
+		since: time.Now().AddDate(0, 0, -days),
+		chats: s.chatsByKey(),
+		keep:  active,
+	}
+	s.tree.view = matcher.filter(s.tree.root, "", true)
+	treeView.SetRoot(s.tree.view)
+	refreshTreeUnreadLabels(s.tree.view)
+	treeView.SetCurrentNode(equivalentTreeNode(s.tree.view, current))
+}
+
+// refreshTreeView brings a filtered tree up to date after the full tree
+// changed.
+func (s *AppState) refreshTreeView() {
+	if s.tree.view != nil || s.isTreeFiltered() {
+		s.renderTreeView()
+	}
+}
+
+// revealTreeNode clears the filter and view mode when node is hidden by them.
+func (s *AppState) revealTreeNode(node *tview.TreeNode) {
+	if node == nil || s.tree.view == nil {
+		return
+	}
+	visible := false
+	s.tree.view.Walk(func(other, parent *tview.TreeNode) bool {
+		visible = visible || other == node
+		return !visible
+	})
+	if visible {
+		return
+	}
+	s.treeFilterMu.Lock()
+	s.treeFilter = ""
+	s.treeViewMode = treeViewAll
+	s.treeFilterMu.Unlock()
+	if input, ok := s.components[ViTreeFilter].(*tview.InputField); ok {
+		input.SetText("")
+	}
+	s.persistEncryptedChatSettings()
+	s.renderTreeView()
+}
+
+func (s *AppState) setTreeViewMode(mode string) {
+	mode = normalizeTreeViewMode(mode)
+	s.treeFilterMu.Lock()
+	s.treeViewMode = mode
+	s.treeFilterMu.Unlock()
+	s.persistEncryptedChatSettings()
+	s.renderTreeView()
+	s.setComposeStatus("View: " + s.formatTreeViewModeLine())
+}
+
+func (s *AppState) cycleTreeViewMode() {
+	_, mode, _ := s.treeViewState()
+	next := treeViewModes[0]
+	for i, known := range treeViewModes {
+		if known == mode {
+			next = treeViewModes[(i+1)%len(treeViewModes)]
+		}
+	}
+	s.setTreeViewMode(next)
+}
+
+func (s *AppState) setActiveDays(days int) {
+	if days <= 0 {
+		days = defaultActiveDays
+	}
+	s.treeFilterMu.Lock()
+	s.activeDays = days
+	s.treeFilterMu.Unlock()
+	s.persistEncryptedChatSettings()
+	s.refreshTreeView()
+}
+
+func (s *AppState) cycleActiveDays() {
+	_, _, days := s.treeViewState()
+	next := activeDayChoices[0]
+	for i, choice := range activeDayChoices {
+		if choice == days && i+1 < len(activeDayChoices) {
+			next = activeDayChoices[i+1]
+		}
+	}
+	s.setActiveDays(next)
+}
+
+// showViewModePicker lists the view modes. The active mode asks for the number
+// of days.
+func (s *AppState) showViewModePicker() {
+	_, current, days := s.treeViewState()
+	items := []pickerItem{}
+	for _, mode := range treeViewModes {
+		item := pickerItem{label: treeViewModeTitle(mode, days), value: mode}
+		if mode == treeViewActive {
+			item.label = "Active in last N days…"
+			item.secondary = s.formatActiveDaysLine()
+		}
+		if mode == current {
+			item.secondary = strings.TrimSpace(item.secondary + " current")
+		}
+		items = append(items, item)
+	}
+	s.showPicker(pageViewMode, "Tree view", items, func(item pickerItem) {
+		mode := item.value.(string)
+		if mode != treeViewActive {
+			s.setTreeViewMode(mode)
+			return
+		}
+		s.promptText(pageActiveDays, "Active in last N days", "Days: ", strconv.Itoa(days), func(text string, ok bool) {
+			if !ok {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil || n <= 0 {
+				s.setComposeStatus("Days must be a positive number")
+				return
+			}
+			s.treeFilterMu.Lock()
+			s.activeDays = n
+			s.treeFilterMu.Unlock()
+			s.setTreeViewMode(treeViewActive)
+		})
+	})
+}
+
+// newTreeFilterInput builds the filter bar above the tree. It is only shown
+// while the tree is filtered.
+func (s *AppState) newTreeFilterInput() *tview.InputField {
+	input := tview.NewInputField().SetFieldWidth(0)
+	input.SetFieldBackgroundColor(s.composeFieldColor())
+	input.SetChangedFunc(func(text string) {
+		s.treeFilterMu.Lock()
+		s.treeFilter = text
+		s.treeFilterMu.Unlock()
+		s.renderTreeView()
+	})
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		// Up and Down move the tree selection while typing.
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			if treeView, ok := s.components[TrChat].(*tview.TreeView); ok {
+				treeView.InputHandler()(event, func(p tview.Primitive) {})
+			}
+			return nil
+		}
+		return event
+	})
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEscape:
+			input.SetText("")
+		case tcell.KeyEnter, tcell.KeyTab, tcell.KeyBacktab:
+		default:
+			return
+		}
+		s.app.SetFocus(s.components[TrChat])
+		s.updateTreeFilterBar()
+	})
+	return input
+}
+
+// updateTreeFilterBar shows the filter bar while a filter or view mode is
+// active, or while it is being typed in.
+func (s *AppState) updateTreeFilterBar() {
+	input, ok := s.components[ViTreeFilter].(*tview.InputField)
+	pane, paneOK := s.components[FlTree].(*tview.Flex)
+	if !ok || !paneOK {
+		return
+	}
+	_, mode, days := s.treeViewState()
+	label := "Filter: "
+	if mode != treeViewAll {
+		label = treeViewModeTitle(mode, days) + " | Filter: "
+	}
+	input.SetLabel(label)
+	height := 0
+	if s.isTreeFiltered() || s.app.GetFocus() == input {
+		height = 1
+	}
+	pane.ResizeItem(input, height, 0)
+}
+
+func (s *AppState) openTreeFilter() {
+	input, ok := s.components[ViTreeFilter].(*tview.InputField)
+	if !ok {
+		return
+	}
+	s.app.SetFocus(input)
+	s.updateTreeFilterBar()
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"strings"
+)
+
+const (
+	pageFolderPicker = "pageFolderPicker"
+	pageFolderName   = "pageFolderName"
+	pageFolderDelete = "pageFolderDelete"
+
+	// Folder picker entries besides folders.
+	folderPickNew    = "new"
+	folderPickRemove = "remove"
+	folderPickRename = "rename"
+	folderPickDelete = "delete"
+)
+
+// pickerAction is a picker entry that is not a folder name.
+type pickerAction string
+
+// chatFolder is a user folder of chats and channels, listed by conversation key.
+type chatFolder struct {
+	name      string
+	items     []string
+	collapsed bool
+}
+
+// persistedFolder is a chatFolder in the encrypted settings.
+type persistedFolder struct {
+	Name      string   `json:"name"`
+	Items     []string `json:"items,omitempty"`
+	Collapsed bool     `json:"collapsed,omitempty"`
+}
+
+func folderNameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func (s *AppState) folderIndexLocked(name string) int {
+	for i, folder := range s.folders {
+		if folderNameKey(folder.name) == folderNameKey(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// folderOf returns the folder a conversation is assigned to.
+func (s *AppState) folderOf(key string) string {
+	key = normalizeFavoriteKey(key)
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+	for _, folder := range s.folders {
+		for _, item := range folder.items {
+			if item == key {
+				return folder.name
+			}
+		}
+	}
+	return ""
+}
+
+func (s *AppState) persistedFolders() []persistedFolder {
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+	out := make([]persistedFolder, 0, len(s.folders))
+	for _, folder := range s.folders {
+		out = append(out, persistedFolder{
+			Name:      folder.name,
+			Items:     append([]string(nil), folder.items...),
+			Collapsed: folder.collapsed,
+		})
+	}
+	return out
+}
+
+func (s *AppState) setPersistedFolders(folders []persistedFolder) {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+	s.folders = nil
+	for _, folder := range folders {
+		if strings.TrimSpace(folder.Name) == "" || s.folderIndexLocked(folder.Name) >= 0 {
+			continue
+		}
+		items := []string{}
+		for _, item := range folder.Items {
+			if key := normalizeFavoriteKey(item); key != "" {
+				items = append(items, key)
+			}
+		}
+		s.folders = append(s.folders, chatFolder{
+			name:      strings.TrimSpace(folder.Name),
+			items:     items,
+			collapsed: folder.Collapsed,
+		})
+	}
+}
+
+func (s *AppState) createFolder(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("folder name must not be empty")
+	}
+	s.foldersMu.Lock()
+	if s.folderIndexLocked(name) >= 0 {
+		s.foldersMu.Unlock()
+		return fmt.Errorf("folder %s already exists", name)
+	}
+	s.folders = append(s.folders, chatFolder{name: name})
+	s.foldersMu.Unlock()
+	s.persistEncryptedChatSettings()
+	s.renderFolders()
+	return nil
+}
+
+func (s *AppState) renameFolder(oldName, newName string) error {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return fmt.Errorf("folder name must not be empty")
+	}
+	s.foldersMu.Lock()
+	idx := s.folderIndexLocked(oldName)
+	if other := s.folderIndexLocked(newName); other >= 0 && other != idx {
+		s.foldersMu.Unlock()
+		return fmt.Errorf("folder %s already exists", newName)
+	}
+	if idx < 0 {
+		s.foldersMu.Unlock()
+		return fmt.Errorf("no folder %s", oldName)
+	}
+	s.folders[idx].name = newName
+	s.foldersMu.Unlock()
+	s.persistEncryptedChatSettings()
+	s.renderFolders()
+	return nil
+}
+
+func (s *AppState) deleteFolder(name string) {
+	s.foldersMu.Lock()
+	if idx := s.folderIndexLocked(name); idx >= 0 {
+		s.folders = append(s.folders[:idx], s.folders[idx+1:]...)
+	}
+	s.foldersMu.Unlock()
+	s.persistEncryptedChatSettings()
+	s.renderFolders()
+}
+
+// assignFolder moves a conversation into folder, or out of every folder when
+// folder is empty. A conversation is in at most one folder.
+func (s *AppState) assignFolder(key, folder string) {
+	key = normalizeFavoriteKey(key)
+	if key == "" {
+		return
+	}
+	s.foldersMu.Lock()
+	for i := range s.folders {
+		items := s.folders[i].items[:0]
+		for _, item := range s.folders[i].items {
+			if item != key {
+				items = append(items, item)
+			}
+		}
+		s.folders[i].items = items
+	}
+	if idx := s.folderIndexLocked(folder); idx >= 0 {
+		s.folders[idx].items = append(s.folders[idx].items, key)
+	}
+	s.foldersMu.Unlock()
+	s.persistEncryptedChatSettings()
+	s.renderFolders()
+}
+
+func (s *AppState) setFolderCollapsed(name string, collapsed bool) {
+	s.foldersMu.Lock()
+	idx := s.folderIndexLocked(name)
+	if idx < 0 || s.folders[idx].collapsed == collapsed {
+		s.foldersMu.Unlock()
+		return
+	}
+	s.folders[idx].collapsed = collapsed
+	s.foldersMu.Unlock()
+	s.persistEncryptedChatSettings()
+}
+
+// folderForNode returns the folder name of a folder group node.
+func folderForNode(node *tview.TreeNode) (string, bool) {
+	if node == nil {
+		return "", false
+	}
+	group, ok := node.GetReference().(groupRef)
+	if !ok || !group.folder {
+		return "", false
+	}
+	return group.name, true
+}
+
+// chatSourceNode returns the node of a chat under Favorites or Recent.
+func (s *AppState) chatSourceNode(key string) *tview.TreeNode {
+	for _, group := range []*tview.TreeNode{s.tree.favorites, s.tree.recent} {
+		if group == nil {
+			continue
+		}
+		for _, child := range group.GetChildren() {
+			if conversationNodeKey(child) == key {
+				return child
+			}
+		}
+	}
+	return nil
+}
+
+// folderItemNode builds the copy of a chat or channel listed in a folder.
+func (s *AppState) folderItemNode(key, folder string) *tview.TreeNode {
+	if source := s.chatSourceNode(key); source != nil {
+		ref, ok := source.GetReference().(conversationRef)
+		if !ok {
+			return nil
+		}
+		ref.folder = folder
+		node := tview.NewTreeNode(ref.treeTitle())
+		node.SetReference(ref)
+		node.SetColor(tcell.ColorGreen)
+		return node
+	}
+	for _, teamNode := range s.tree.teamNodes {
+		for _, channelNode := range s.tree.channelNodes[teamNode] {
+			channel, ok := channelNode.GetReference().(channelRef)
+			if !ok || normalizeFavoriteKey(channel.channel.Id) != key {
+				continue
+			}
+			ref, ok := s.channelShortcutRef(channel.channel.Id)
+			if !ok {
+				return nil
+			}
+			ref.folder = folder
+			node := tview.NewTreeNode(ref.treeTitle())
+			node.SetReference(ref)
+			node.SetColor(tcell.ColorGreen)
+			return node
+		}
+	}
+	return nil
+}
+
+// renderFolders rebuilds the user folders below Pinned. Conversations that are
+// no longer loaded are skipped but stay assigned.
+func (s *AppState) renderFolders() {
+	if s.tree.root == nil {
+		return
+	}
+	treeView, _ := s.components[TrChat].(*tview.TreeView)
+	// A selected folder stays selected by position so it survives a rename; a
+	// selected folder entry stays selected, or moves to the conversation's
+	// other node once it leaves the folder.
+	selectedKey, selectedFolder := "", -1
+	if treeView != nil && treeView.GetCurrentNode() != nil {
+		current := treeView.GetCurrentNode()
+		for i, folderNode := range s.tree.folders {
+			if folderNode == current {
+				selectedFolder = i
+			}
+			for _, child := range folderNode.GetChildren() {
+				if child == current {
+					selectedKey = conversationNodeKey(current)
+				}
+			}
+		}
+	}
+
+	s.foldersMu.RLock()
+	folders := make([]chatFolder, len(s.folders))
+	for i, folder := range s.folders {
+		folders[i] = chatFolder{name: folder.name, items: append([]string(nil), folder.items...), collapsed: folder.collapsed}
+	}
+	s.foldersMu.RUnlock()
+
+	var selected *tview.TreeNode
+	s.tree.folders = nil
+	for _, folder := range folders {
+		folderNode := tview.NewTreeNode(folder.name)
+		folderNode.SetReference(groupRef{name: folder.name, folder: true})
+		folderNode.SetColor(tcell.ColorYellow)
+		for _, key := range folder.items {
+			node := s.folderItemNode(key, folder.name)
+			if node == nil {
+				continue
+			}
+			folderNode.AddChild(node)
+			if key == selectedKey {
+				selected = node
+			}
+		}
+		folderNode.SetExpanded(!folder.collapsed)
+		s.tree.folders = append(s.tree.folders, folderNode)
+	}
+	s.arrangeRoot()
+	refreshTreeUnreadLabels(s.tree.root)
+	s.refreshTreeView()
+
+	s.activeConversationMu.Lock()
+	if s.activeConversationNode != nil {
+		switch active := s.activeConversationNode.GetReference().(type) {
+		case conversationRef:
+			if active.folder != "" {
+				s.activeConversationNode = s.findConversationNode(active.chatKey)
+			}
+		case channelRef:
+			if active.folder != "" {
+				s.activeConversationNode = s.findConversationNode(active.channel.Id)
+			}
+		}
+	}
+	s.activeConversationMu.Unlock()
+
+	switch {
+	case treeView == nil:
+		return
+	case selectedFolder >= 0 && len(s.tree.folders) > 0:
+		if selectedFolder >= len(s.tree.folders) {
+			selectedFolder = len(s.tree.folders) - 1
+		}
+		selected = s.tree.folders[selectedFolder]
+	case selectedFolder >= 0:
+		selected = s.tree.root
+	case selectedKey == "":
+		return
+	case selected == nil:
+		selected = s.findConversationNode(selectedKey)
+	}
+	if selected == nil {
+		selected = s.tree.root
+	}
+	treeView.SetCurrentNode(selected)
+}
+
+// arrangeRoot orders the top level of the tree: Pinned while it has channels,
+// the user folders, then Teams, Chats and Settings & Help.
+func (s *AppState) arrangeRoot() {
+	children := []*tview.TreeNode{}
+	if s.tree.pinned != nil && len(s.tree.pinned.GetChildren()) > 0 {
+		children = append(children, s.tree.pinned)
+	}
+	children = append(children, s.tree.folders...)
+	for _, child := range s.tree.root.GetChildren() {
+		if child == s.tree.pinned {
+			continue
+		}
+		if _, ok := folderForNode(child); ok {
+			continue
+		}
+		children = append(children, child)
+	}
+	s.tree.root.SetChildren(children)
+}
+
+func (s *AppState) folderNames() []string {
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+	names := make([]string, 0, len(s.folders))
+	for _, folder := range s.folders {
+		names = append(names, folder.name)
+	}
+	return names
+}
+
+func (s *AppState) promptNewFolder(onCreated func(name string)) {
+	s.promptText(pageFolderName, "New folder", "Name: ", "", func(text string, ok bool) {
+		if !ok {
+			return
+		}
+		if err := s.createFolder(text); err != nil {
+			s.setComposeStatus(err.Error())
+			return
+		}
+		s.setComposeStatus("Created folder " + strings.TrimSpace(text))
+		if onCreated != nil {
+			onCreated(strings.TrimSpace(text))
+		}
+	})
+}
+
+func (s *AppState) promptRenameFolder(name string) {
+	s.promptText(pageFolderName, "Rename folder", "Name: ", name, func(text string, ok bool) {
+		if !ok {
+			return
+		}
+		if err := s.renameFolder(name, text); err != nil {
+			s.setComposeStatus(err.Error())
+			return
+		}
+		s.setComposeStatus("Renamed folder to " + strings.TrimSpace(text))
+	})
+}
+
+// showFolderActions runs on the selected tree node: a chat or channel is moved
+// between folders, a folder can be renamed or deleted.
+func (s *AppState) showFolderActions(node *tview.TreeNode) {
+	if name, ok := folderForNode(node); ok {
+		s.showFolderMenu(name)
+		return
+	}
+	key := conversationNodeKey(node)
+	if key == "" || key == settingsHelpChatKey {
+		s.setComposeStatus("Select a chat, channel or folder first")
+		return
+	}
+	current := s.folderOf(key)
+	items := []pickerItem{}
+	for _, name := range s.folderNames() {
+		if folderNameKey(name) == folderNameKey(current) {
+			continue
+		}
+		items = append(items, pickerItem{label: name, secondary: "folder", value: name})
+	}
+	if current != "" {
+		items = append(items, pickerItem{label: "Remove from " + current, value: pickerAction(folderPickRemove)})
+	}
+	items = append(items, pickerItem{label: "New folder…", value: pickerAction(folderPickNew)})
+	s.showPicker(pageFolderPicker, "Move to folder", items, func(item pickerItem) {
+		switch value := item.value.(type) {
+		case string:
+			s.assignFolder(key, value)
+			s.setComposeStatus("Moved to " + value)
+		case pickerAction:
+			if value == folderPickRemove {
+				s.assignFolder(key, "")
+				s.setComposeStatus("Removed from " + current)
+				return
+			}
+			s.promptNewFolder(func(name string) {
+				s.assignFolder(key, name)
+			})
+		}
+	})
+}
+
+func (s *AppState) showFolderMenu(name string) {
+	items := []pickerItem{
+		{label: "Rename folder", value: pickerAction(folderPickRename)},
+		{label: "Delete folder", secondary: "its chats and channels stay in the tree", value: pickerAction(folderPickDelete)},
+		{label: "New folder…", value: pickerAction(folderPickNew)},
+	}
+	s.showPicker(pageFolderPicker, name, items, func(item pickerItem) {
+		switch item.value.(pickerAction) {
+		case folderPickRename:
+			s.promptRenameFolder(name)
+		case folderPickDelete:
+			s.confirmAction(pageFolderDelete, "Delete folder "+name+"?", func() {
+				s.deleteFolder(name)
+				s.setComposeStatus("Deleted folder " + name)
+			})
+		case folderPickNew:
+			s.promptNewFolder(nil)
+		}
+	})
+}
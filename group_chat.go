@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func threadPropertyEndpoint(threadID, name string) string {
+	return csa.MessagesHost + "v1/threads/" + url.QueryEscape(threadID) + "/properties?name=" + url.QueryEscape(name)
+}
+
+// threadMember is a member entry of a chat service thread.
+type threadMember struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+}
+
+// selfMri is the MRI of the signed in user.
+func (s *AppState) selfMri() string {
+	if s.me == nil {
+		return ""
+	}
+	if mri := strings.TrimSpace(s.me.Mri); mri != "" {
+		return mri
+	}
+	if oid := strings.TrimSpace(s.me.ObjectId); oid != "" {
+		return "8:orgid:" + oid
+	}
+	return ""
+}
+
+// createChatThread creates a chat between the signed in user and memberMris and
+// returns its id. A single other member without a topic gives a one on one
+// chat, for which the server returns the existing chat when there is one.
+func (s *AppState) createChatThread(memberMris []string, topic string) (string, error) {
+	self := s.selfMri()
+	if self == "" {
+		return "", fmt.Errorf("own profile is not loaded")
+	}
+	members := []threadMember{{ID: self, Role: "Admin"}}
+	seen := map[string]struct{}{strings.ToLower(self): {}}
+	for _, mri := range memberMris {
+		mri = strings.TrimSpace(mri)
+		if _, ok := seen[strings.ToLower(mri)]; ok || mri == "" {
+			continue
+		}
+		seen[strings.ToLower(mri)] = struct{}{}
+		members = append(members, threadMember{ID: mri, Role: "Admin"})
+	}
+	if len(members) < 2 {
+		return "", fmt.Errorf("add at least one other member")
+	}
+	topic = strings.TrimSpace(topic)
+	properties := map[string]string{"threadType": "chat"}
+	if len(members) == 2 && topic == "" {
+		properties["fixedRoster"] = "true"
+		properties["uniquerosterthread"] = "true"
+	} else {
+		properties["uniquerosterthread"] = "false"
+		if topic != "" {
+			properties["topic"] = topic
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"members":    members,
+		"properties": properties,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to encode chat: %v", err)
+	}
+	respBody, header, err := s.chatServiceRoundTrip(http.MethodPost, csa.MessagesHost+"v1/threads", body)
+	if err != nil {
+		return "", err
+	}
+	if id := threadIDFromLocation(header.Get("Location")); id != "" {
+		return id, nil
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(respBody, &created) == nil && strings.TrimSpace(created.ID) != "" {
+		return strings.TrimSpace(created.ID), nil
+	}
+	return "", fmt.Errorf("server did not return the new chat id")
+}
+
+func threadIDFromLocation(location string) string {
+	idx := strings.LastIndex(location, "/threads/")
+	if idx < 0 {
+		return ""
+	}
+	id := location[idx+len("/threads/"):]
+	if end := strings.IndexAny(id, "?/"); end >= 0 {
+		id = id[:end]
+	}
+	id, err := url.PathUnescape(id)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(id)
+}
+
+// addChatNode puts a chat that is not in the tree yet at the top of Recent and
+// registers it with the loaded conversations so member lookups find it. An
+// existing node of the chat is returned unchanged.
+func (s *AppState) addChatNode(chat csa.Chat) *tview.TreeNode {
+	if node := s.findConversationNode(chat.Id); node != nil {
+		return node
+	}
+	if conversations := s.conversationsSnapshot(); conversations != nil {
+		s.setChats(append(append([]csa.Chat(nil), conversations.Chats...), chat))
+	}
+	key := normalizeFavoriteKey(chat.Id)
+	if s.pendingChats == nil {
+		s.pendingChats = map[string]csa.Chat{}
+	}
+	s.pendingChats[key] = chat
+	ref := conversationRef{
+		ids:         []string{chat.Id},
+		title:       s.chatDisplayNameForKey(key, buildChatDisplayName(chat, s.me)),
+		chatKey:     key,
+		presenceMri: oneOnOnePartnerMri(chat, s.me),
+	}
+	ref.presence = s.presenceOf(ref.presenceMri).availability
+	node := tview.NewTreeNode(ref.treeTitle())
+	node.SetReference(ref)
+	node.SetColor(tcell.ColorGreen)
+	moveChatNodeToGroup(s.tree.chats, s.tree.favorites, s.tree.recent, node, false)
+	if s.tree.recent != nil {
+		// moveChatNodeToGroup appends, but Recent is sorted newest first.
+		children := s.tree.recent.GetChildren()
+		s.tree.recent.SetChildren(append([]*tview.TreeNode{node}, children[:len(children)-1]...))
+	}
+	refreshTreeUnreadLabels(s.tree.root)
+	s.refreshTreeView()
+	return node
+}
+
+func (s *AppState) setChatTopicOnServer(threadID, topic string) error {
+	body, err := json.Marshal(map[string]string{"topic": topic})
+	if err != nil {
+		return fmt.Errorf("unable to encode topic: %v", err)
+	}
+	return s.chatServiceRequest(http.MethodPut, threadPropertyEndpoint(threadID, "topic"), body)
+}
+
+// groupChatForNode resolves the loaded group chat behind a tree node. One on one
+// chats, channels and Private Notes are rejected.
+func (s *AppState) groupChatForNode(node *tview.TreeNode) (conversationRef, csa.Chat, error) {
+	if node == nil {
+		return conversationRef{}, csa.Chat{}, fmt.Errorf("open a group chat first")
+	}
+	ref, ok := node.GetReference().(conversationRef)
+	if !ok || ref.chatKey == settingsHelpChatKey {
+		return conversationRef{}, csa.Chat{}, fmt.Errorf("open a group chat first")
+	}
+	chat, ok := s.chatsByKey()[normalizeFavoriteKey(ref.chatKey)]
+	if !ok || chat.IsOneOnOne || isPrivateNotesChat(chat) {
+		return conversationRef{}, csa.Chat{}, fmt.Errorf("only group chats can be changed")
+	}
+	return ref, chat, nil
+}
+
+// renameGroupChat sets the topic of the group chat behind node on the server and
+// updates the tree label and persisted title once that succeeded.
+func (s *AppState) renameGroupChat(node *tview.TreeNode, topic string) error {
+	topic = strings.TrimSpace(topic)
+	if topic == "" {
+		return fmt.Errorf("topic must not be empty")
+	}
+	ref, chat, err := s.groupChatForNode(node)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.setChatTopicOnServer(chat.Id, topic); err != nil {
+			s.logger.WithError(err).WithField("chat_id", chat.Id).Warn("unable to rename group chat")
+			s.app.QueueUpdateDraw(func() {
+				s.setComposeStatus("Rename failed")
+			})
+			return
+		}
+		s.logger.WithFields(logrus.Fields{
+			"chat_id": chat.Id,
+			"topic":   topic,
+		}).Info("group chat renamed")
+		s.app.QueueUpdateDraw(func() {
+			current, ok := node.GetReference().(conversationRef)
+			if !ok {
+				current = ref
+			}
+			current.title = topic
+			node.SetText(current.treeTitle())
+			node.SetReference(current)
+			s.syncConversationCopies(node)
+			if s.setChatTitle(current.chatKey, topic) {
+				s.persistEncryptedChatSettings()
+			}
+			if _, _, active := s.getActiveConversation(); active == node {
+				s.components[ViChat].(*tview.List).SetTitle(topic)
+				s.setActiveConversationTitle(topic)
+			}
+			s.setComposeStatus("Renamed to " + topic)
+		})
+	}()
+	return nil
+}
+
+const (
+	pageRenameChat   = "pageRenameChat"
+	pageRemoveMember = "pageRemoveMember"
+	pageConfirmChat  = "pageConfirmChat"
+)
+
+func threadMemberEndpoint(threadID, mri string) string {
+	return csa.MessagesHost + "v1/threads/" + url.QueryEscape(threadID) + "/members/" + url.QueryEscape(mri)
+}
+
+// chatActionNode is the chat that chat management acts on: the selected tree
+// node while the tree has focus, otherwise the open conversation.
+func (s *AppState) chatActionNode() *tview.TreeNode {
+	if treeView, ok := s.components[TrChat].(*tview.TreeView); ok && s.app.GetFocus() == treeView {
+		return treeView.GetCurrentNode()
+	}
+	_, _, node := s.getActiveConversation()
+	return node
+}
+
+func (s *AppState) promptRenameGroupChat() {
+	node := s.chatActionNode()
+	if name, ok := folderForNode(node); ok {
+		s.promptRenameFolder(name)
+		return
+	}
+	ref, _, err := s.groupChatForNode(node)
+	if err != nil {
+		s.setComposeStatus(err.Error())
+		return
+	}
+	s.promptText(pageRenameChat, "Rename group chat", "Topic: ", ref.title, func(text string, ok bool) {
+		if !ok {
+			return
+		}
+		if err := s.renameGroupChat(node, text); err != nil {
+			s.setComposeStatus(err.Error())
+		}
+	})
+}
+
+// updateLoadedChat applies change to a copy of the loaded chat id, publishes it
+// and returns the result.
+func (s *AppState) updateLoadedChat(id string, change func(chat *csa.Chat)) (csa.Chat, bool) {
+	conversations := s.conversationsSnapshot()
+	if conversations == nil {
+		return csa.Chat{}, false
+	}
+	for i := range conversations.Chats {
+		if conversations.Chats[i].Id == id {
+			chats := append([]csa.Chat(nil), conversations.Chats...)
+			chat := &chats[i]
+			chat.Members = append(chat.Members[:0:0], chat.Members...)
+			change(chat)
+			s.setChats(chats)
+			return *chat, true
+		}
+	}
+	return csa.Chat{}, false
+}
+
+// applyChatRoster refreshes everything derived from the members of chat after
+// they changed: the generated title of chats without a topic, the member cache
+// and the member pane.
+func (s *AppState) applyChatRoster(node *tview.TreeNode, chat csa.Chat) {
+	key := normalizeFavoriteKey(chat.Id)
+	if ref, ok := node.GetReference().(conversationRef); ok && strings.TrimSpace(chat.Title) == "" {
+		ref.title = s.chatDisplayNameForKey(key, buildChatDisplayName(chat, s.me))
+		node.SetText(ref.treeTitle())
+		node.SetReference(ref)
+		s.syncConversationCopies(node)
+	}
+	s.membersMu.Lock()
+	delete(s.memberCache, key)
+	s.membersMu.Unlock()
+	s.refreshMembersPane()
+}
+
+// addGroupChatMembers adds people to the group chat behind node.
+func (s *AppState) addGroupChatMembers(node *tview.TreeNode, people []mentionCandidate) error {
+	_, chat, err := s.groupChatForNode(node)
+	if err != nil {
+		return err
+	}
+	if len(people) == 0 {
+		return fmt.Errorf("nobody to add")
+	}
+	s.setComposeStatus("Adding members")
+	go func() {
+		added := []csa.ChatMember{}
+		var failed error
+		for _, person := range people {
+			mri := candidateMri(person)
+			body, _ := json.Marshal(map[string]string{"role": "Admin"})
+			if err := s.chatServiceRequest(http.MethodPut, threadMemberEndpoint(chat.Id, mri), body); err != nil {
+				s.logger.WithError(err).WithFields(logrus.Fields{
+					"chat_id": chat.Id,
+					"mri":     mri,
+				}).Warn("unable to add chat member")
+				failed = err
+				continue
+			}
+			added = append(added, csa.ChatMember{
+				Mri:          mri,
+				ObjectId:     person.ObjectID,
+				FriendlyName: person.DisplayName,
+				Role:         csa.ChatMemberAdmin,
+			})
+		}
+		s.app.QueueUpdateDraw(func() {
+			if len(added) > 0 {
+				updated, _ := s.updateLoadedChat(chat.Id, func(c *csa.Chat) {
+					c.Members = append(c.Members, added...)
+				})
+				s.applyChatRoster(node, updated)
+			}
+			switch {
+			case failed != nil && len(added) == 0:
+				s.setComposeStatus("Add members failed")
+			case failed != nil:
+				s.setComposeStatus(fmt.Sprintf("Added %d of %d members", len(added), len(people)))
+			default:
+				s.setComposeStatus(fmt.Sprintf("Added %d member(s)", len(added)))
+			}
+		})
+	}()
+	return nil
+}
+
+func (s *AppState) showAddChatMembers() {
+	node := s.chatActionNode()
+	ref, chat, err := s.groupChatForNode(node)
+	if err != nil {
+		s.setComposeStatus(err.Error())
+		return
+	}
+	exclude := map[string]bool{}
+	for _, member := range chat.Members {
+		exclude[strings.ToLower(strings.TrimSpace(member.Mri))] = true
+	}
+	s.loadPeopleCandidates(peoplePicker{
+		title:   "Add to " + ref.title,
+		confirm: "Add members",
+		exclude: exclude,
+		onDone: func(people []mentionCandidate) {
+			if err := s.addGroupChatMembers(node, people); err != nil {
+				s.setComposeStatus(err.Error())
+			}
+		},
+	})
+}
+
+// removeGroupChatMember removes the member with mri from the group chat behind
+// node once the user confirmed it.
+func (s *AppState) removeGroupChatMember(node *tview.TreeNode, mri, name string) error {
+	ref, chat, err := s.groupChatForNode(node)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(mri, s.selfMri()) {
+		return fmt.Errorf("use leave to remove yourself")
+	}
+	s.confirmAction(pageConfirmChat, fmt.Sprintf("Remove %s from %s?", name, ref.title), func() {
+		s.setComposeStatus("Removing " + name)
+		go func() {
+			if err := s.chatServiceRequest(http.MethodDelete, threadMemberEndpoint(chat.Id, mri), nil); err != nil {
+				s.logger.WithError(err).WithFields(logrus.Fields{
+					"chat_id": chat.Id,
+					"mri":     mri,
+				}).Warn("unable to remove chat member")
+				s.app.QueueUpdateDraw(func() {
+					s.setComposeStatus("Remove member failed")
+				})
+				return
+			}
+			s.app.QueueUpdateDraw(func() {
+				updated, _ := s.updateLoadedChat(chat.Id, func(c *csa.Chat) {
+					members := c.Members[:0]
+					for _, member := range c.Members {
+						if !strings.EqualFold(member.Mri, mri) {
+							members = append(members, member)
+						}
+					}
+					c.Members = members
+				})
+				s.applyChatRoster(node, updated)
+				s.setComposeStatus("Removed " + name)
+			})
+		}()
+	})
+	return nil
+}
+
+func (s *AppState) showRemoveChatMember() {
+	node := s.chatActionNode()
+	ref, chat, err := s.groupChatForNode(node)
+	if err != nil {
+		s.setComposeStatus(err.Error())
+		return
+	}
+	items := []pickerItem{}
+	for _, member := range chat.Members {
+		if isCurrentUser(member, s.me) {
+			continue
+		}
+		name := strings.TrimSpace(member.FriendlyName)
+		if name == "" {
+			name = member.Mri
+		}
+		items = append(items, pickerItem{label: name, value: csa.ChatMember{Mri: member.Mri, FriendlyName: name}})
+	}
+	s.showPicker(pageRemoveMember, "Remove from "+ref.title, items, func(item pickerItem) {
+		member, ok := item.value.(csa.ChatMember)
+		if !ok {
+			return
+		}
+		if err := s.removeGroupChatMember(node, member.Mri, member.FriendlyName); err != nil {
+			s.setComposeStatus(err.Error())
+		}
+	})
+}
+
+// leaveGroupChat removes the signed in user from the group chat behind node
+// after confirmation and drops the chat from the tree and persisted settings.
+func (s *AppState) leaveGroupChat(node *tview.TreeNode) error {
+	ref, chat, err := s.groupChatForNode(node)
+	if err != nil {
+		return err
+	}
+	s.confirmAction(pageConfirmChat, fmt.Sprintf("Leave %s?\n\nYou will no longer get its messages.", ref.title), func() {
+		s.setComposeStatus("Leaving " + ref.title)
+		go func() {
+			if err := s.chatServiceRequest(http.MethodDelete, threadMemberEndpoint(chat.Id, s.selfMri()), nil); err != nil {
+				s.logger.WithError(err).WithField("chat_id", chat.Id).Warn("unable to leave chat")
+				s.app.QueueUpdateDraw(func() {
+					s.setComposeStatus("Leave failed")
+				})
+				return
+			}
+			s.logger.WithField("chat_id", chat.Id).Info("left group chat")
+			s.app.QueueUpdateDraw(func() {
+				s.removeChatNode(node, chat.Id)
+				s.setComposeStatus("Left " + ref.title)
+			})
+		}()
+	})
+	return nil
+}
+
+// removeChatNode drops a chat from the tree, the loaded conversations and the
+// persisted favorites, titles and folders. An open chat is closed.
+func (s *AppState) removeChatNode(node *tview.TreeNode, chatID string) {
+	key := normalizeFavoriteKey(chatID)
+	_, _, active := s.getActiveConversation()
+	wasActive := active == node || conversationNodeKey(active) == key
+	if conversations := s.conversationsSnapshot(); conversations != nil {
+		chats := make([]csa.Chat, 0, len(conversations.Chats))
+		for _, chat := range conversations.Chats {
+			if chat.Id != chatID {
+				chats = append(chats, chat)
+			}
+		}
+		s.setChats(chats)
+	}
+	// node may be a copy in a user folder; the chat is removed everywhere.
+	if source := s.chatSourceNode(key); source != nil {
+		s.tree.favorites.RemoveChild(source)
+		s.tree.recent.RemoveChild(source)
+		s.rebuildChatGroups()
+	}
+	treeView, _ := s.components[TrChat].(*tview.TreeView)
+	if treeView != nil && conversationNodeKey(treeView.GetCurrentNode()) == key {
+		treeView.SetCurrentNode(s.tree.chats)
+	}
+
+	s.chatFavoritesMu.Lock()
+	delete(s.chatFavorites, key)
+	s.chatFavoritesMu.Unlock()
+	s.chatTitlesMu.Lock()
+	delete(s.chatTitles, key)
+	s.chatTitlesMu.Unlock()
+	s.assignFolder(key, "")
+	refreshTreeUnreadLabels(s.tree.root)
+
+	if wasActive {
+		s.closeActiveConversation()
+	}
+}
+
+// closeActiveConversation empties the chat pane after its conversation has
+// gone.
+func (s *AppState) closeActiveConversation() {
+	chatList := s.components[ViChat].(*tview.List)
+	chatList.Clear()
+	chatList.SetTitle("")
+	s.setCurrentChatMessages(nil)
+	s.setActiveConversation(nil, nil, "")
+	s.app.SetFocus(s.components[TrChat])
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func (s *AppState) isHidden(id string) bool {
+	key := normalizeFavoriteKey(id)
+	s.hiddenMu.RLock()
+	defer s.hiddenMu.RUnlock()
+	return key != "" && s.hidden[key]
+}
+
+func (s *AppState) setHidden(id string, hidden bool) {
+	key := normalizeFavoriteKey(id)
+	if key == "" {
+		return
+	}
+	s.hiddenMu.Lock()
+	if s.hidden == nil {
+		s.hidden = map[string]bool{}
+	}
+	if hidden {
+		s.hidden[key] = true
+	} else {
+		delete(s.hidden, key)
+	}
+	s.hiddenMu.Unlock()
+}
+
+func (s *AppState) isShowingHidden() bool {
+	s.hiddenMu.RLock()
+	defer s.hiddenMu.RUnlock()
+	return s.showHidden
+}
+
+// renderTeams attaches the teams and channels that are not hidden to the tree.
+// While hidden ones are shown they are grayed out instead.
+func (s *AppState) renderTeams() {
+	if s.tree.teams == nil {
+		return
+	}
+	showHidden := s.isShowingHidden()
+	teams := []*tview.TreeNode{}
+	for _, teamNode := range s.tree.teamNodes {
+		team, ok := teamNode.GetReference().(csa.Team)
+		if !ok {
+			continue
+		}
+		teamHidden := s.isHidden(team.Id)
+		if teamHidden && !showHidden {
+			continue
+		}
+		channels := []*tview.TreeNode{}
+		for _, channelNode := range s.tree.channelNodes[teamNode] {
+			ref, ok := channelNode.GetReference().(channelRef)
+			if !ok {
+				continue
+			}
+			hidden := s.isHidden(ref.channel.Id)
+			if hidden && !showHidden {
+				continue
+			}
+			if hidden || teamHidden {
+				channelNode.SetColor(tcell.ColorGray)
+			} else {
+				channelNode.SetColor(tcell.ColorGreen)
+			}
+			channels = append(channels, channelNode)
+		}
+		teamNode.SetChildren(channels)
+		if teamHidden {
+			teamNode.SetColor(tcell.ColorGray)
+		} else {
+			teamNode.SetColor(tcell.ColorBlue)
+		}
+		teams = append(teams, teamNode)
+	}
+	s.tree.teams.SetChildren(teams)
+	refreshTreeUnreadLabels(s.tree.root)
+	s.keepSelectionAttached()
+	s.refreshTreeView()
+}
+
+// keepSelectionAttached moves the tree selection to its team, or to the Teams
+// or Chats group, when its node was detached from the tree.
+func (s *AppState) keepSelectionAttached() {
+	treeView, ok := s.components[TrChat].(*tview.TreeView)
+	// A filtered tree moves the selection itself when it is rendered.
+	if !ok || s.tree.root == nil || s.tree.view != nil {
+		return
+	}
+	current := treeView.GetCurrentNode()
+	if current == nil {
+		return
+	}
+	attached := false
+	s.tree.root.Walk(func(node, parent *tview.TreeNode) bool {
+		if node == current {
+			attached = true
+		}
+		return !attached
+	})
+	if attached {
+		return
+	}
+	if _, ok := current.GetReference().(conversationRef); ok {
+		treeView.SetCurrentNode(s.tree.chats)
+		return
+	}
+	for _, teamNode := range s.tree.teamNodes {
+		for _, channelNode := range s.tree.channelNodes[teamNode] {
+			if channelNode != current {
+				continue
+			}
+			if team, ok := teamNode.GetReference().(csa.Team); ok && (!s.isHidden(team.Id) || s.isShowingHidden()) {
+				treeView.SetCurrentNode(teamNode)
+				return
+			}
+		}
+	}
+	treeView.SetCurrentNode(s.tree.teams)
+}
+
+// toggleHideForNode hides or unhides the team or channel behind node. Hidden
+// channels stay reachable through Pinned, Favorites and quick open.
+func (s *AppState) toggleHideForNode(node *tview.TreeNode) error {
+	var id, name string
+	switch ref := node.GetReference().(type) {
+	case csa.Team:
+		id, name = ref.Id, ref.DisplayName
+	case channelRef:
+		id, name = ref.channel.Id, ref.channel.DisplayName
+	default:
+		return fmt.Errorf("only teams and channels can be hidden")
+	}
+	hidden := !s.isHidden(id)
+	s.setHidden(id, hidden)
+	s.persistEncryptedChatSettings()
+	s.renderTeams()
+	switch {
+	case !hidden:
+		s.setComposeStatus("Unhid " + name)
+	case s.isShowingHidden():
+		s.setComposeStatus("Hid " + name)
+	default:
+		s.setComposeStatus("Hid " + name + " (" + s.formatActionBindingLine(scopeTree, actionToggleHidden) + " shows hidden)")
+	}
+	return nil
+}
+
+func (s *AppState) toggleShowHidden() {
+	s.hiddenMu.Lock()
+	s.showHidden = !s.showHidden
+	show := s.showHidden
+	s.hiddenMu.Unlock()
+	s.renderTeams()
+	if show {
+		s.setComposeStatus("Showing hidden teams and channels")
+	} else {
+		s.setComposeStatus("Hiding hidden teams and channels")
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultKeyLeader       = "space"
+	defaultSequenceTimeout = time.Second
+	leaderToken            = "<leader>"
+)
+
+// keyStroke is a single normalized key press. Printable keys use tcell.KeyRune
+// with the rune carrying shift; ctrl+letter is stored as the lower case rune
+// with ModCtrl so it compares equal to the event tcell reports.
+type keyStroke struct {
+	key  tcell.Key
+	r    rune
+	mods tcell.ModMask
+}
+
+// keySequence is one binding: a single stroke or a chord like "g g".
+type keySequence []keyStroke
+
+var namedKeys = map[string]tcell.Key{
+	"up":        tcell.KeyUp,
+	"down":      tcell.KeyDown,
+	"left":      tcell.KeyLeft,
+	"right":     tcell.KeyRight,
+	"enter":     tcell.KeyEnter,
+	"esc":       tcell.KeyEscape,
+	"tab":       tcell.KeyTab,
+	"backtab":   tcell.KeyBacktab,
+	"backspace": tcell.KeyBackspace2,
+	"delete":    tcell.KeyDelete,
+	"insert":    tcell.KeyInsert,
+	"home":      tcell.KeyHome,
+	"end":       tcell.KeyEnd,
+	"pgup":      tcell.KeyPgUp,
+	"pgdn":      tcell.KeyPgDn,
+}
+
+var namedKeyAliases = map[string]string{
+	"escape":   "esc",
+	"return":   "enter",
+	"del":      "delete",
+	"ins":      "insert",
+	"pageup":   "pgup",
+	"pagedown": "pgdn",
+	"bs":       "backspace",
+}
+
+func keyName(key tcell.Key) string {
+	if key >= tcell.KeyF1 && key <= tcell.KeyF64 {
+		return "f" + strconv.Itoa(int(key-tcell.KeyF1)+1)
+	}
+	for name, k := range namedKeys {
+		if k == key {
+			return name
+		}
+	}
+	return ""
+}
+
+// eventToKeyStroke normalizes a tcell event. Control codes that are typeable on
+// their own (Tab, Enter, Esc, Backspace) stay named keys unless ctrl was held.
+func eventToKeyStroke(event *tcell.EventKey) keyStroke {
+	mods := event.Modifiers()
+	key := event.Key()
+	switch {
+	case key == tcell.KeyRune:
+		// Shift is already part of the rune.
+		return keyStroke{key: tcell.KeyRune, r: event.Rune(), mods: mods &^ tcell.ModShift}
+	case key == tcell.KeyBackspace:
+		return keyStroke{key: tcell.KeyBackspace2, mods: mods &^ tcell.ModCtrl}
+	case (key == tcell.KeyTab || key == tcell.KeyEnter || key == tcell.KeyEscape) && mods&tcell.ModCtrl == 0:
+		return keyStroke{key: key, mods: mods}
+	case key == tcell.KeyCtrlSpace:
+		return keyStroke{key: tcell.KeyRune, r: ' ', mods: mods | tcell.ModCtrl}
+	case key >= tcell.KeyCtrlA && key <= tcell.KeyCtrlZ:
+		return keyStroke{key: tcell.KeyRune, r: rune('a' + int(key-tcell.KeyCtrlA)), mods: (mods | tcell.ModCtrl) &^ tcell.ModShift}
+	case key >= tcell.KeyCtrlLeftSq && key <= tcell.KeyCtrlUnderscore:
+		return keyStroke{key: tcell.KeyRune, r: rune('[' + int(key-tcell.KeyCtrlLeftSq)), mods: mods | tcell.ModCtrl}
+	}
+	return keyStroke{key: key, mods: mods}
+}
+
+func (k keyStroke) String() string {
+	var b strings.Builder
+	if k.mods&tcell.ModCtrl != 0 {
+		b.WriteString("ctrl+")
+	}
+	if k.mods&tcell.ModAlt != 0 {
+		b.WriteString("alt+")
+	}
+	if k.mods&tcell.ModMeta != 0 {
+		b.WriteString("meta+")
+	}
+	if k.mods&tcell.ModShift != 0 {
+		b.WriteString("shift+")
+	}
+	if k.key == tcell.KeyRune {
+		if k.r == ' ' {
+			b.WriteString("space")
+		} else {
+			b.WriteRune(k.r)
+		}
+		return b.String()
+	}
+	name := keyName(k.key)
+	if name == "" {
+		return ""
+	}
+	b.WriteString(name)
+	return b.String()
+}
+
+func (q keySequence) String() string {
+	parts := make([]string, 0, len(q))
+	for _, stroke := range q {
+		parts = append(parts, stroke.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (q keySequence) hasPrefix(prefix []keyStroke) bool {
+	if len(prefix) > len(q) {
+		return false
+	}
+	for i := range prefix {
+		if q[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseKeyStroke parses tokens like "j", "G", "ctrl+k", "alt+shift+f5", "space"
+// or the legacy "shift+m".
+func parseKeyStroke(token string) (keyStroke, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return keyStroke{}, fmt.Errorf("empty key")
+	}
+	if len([]rune(token)) == 1 {
+		return keyStroke{key: tcell.KeyRune, r: []rune(token)[0]}, nil
+	}
+
+	parts := strings.Split(token, "+")
+	base := parts[len(parts)-1]
+	modifiers := parts[:len(parts)-1]
+	if base == "" && len(parts) >= 2 && parts[len(parts)-2] == "" {
+		// "alt++" binds the plus key.
+		base = "+"
+		modifiers = parts[:len(parts)-2]
+	}
+
+	var mods tcell.ModMask
+	for _, m := range modifiers {
+		switch strings.ToLower(strings.TrimSpace(m)) {
+		case "ctrl", "c":
+			mods |= tcell.ModCtrl
+		case "alt", "a", "m":
+			mods |= tcell.ModAlt
+		case "meta":
+			mods |= tcell.ModMeta
+		case "shift", "s":
+			mods |= tcell.ModShift
+		default:
+			return keyStroke{}, fmt.Errorf("unknown modifier %q in %q", m, token)
+		}
+	}
+
+	var stroke keyStroke
+	lower := strings.ToLower(base)
+	if alias, ok := namedKeyAliases[lower]; ok {
+		lower = alias
+	}
+	switch {
+	case len([]rune(base)) == 1:
+		stroke = keyStroke{key: tcell.KeyRune, r: []rune(base)[0]}
+	case lower == "space":
+		stroke = keyStroke{key: tcell.KeyRune, r: ' '}
+	case len(lower) > 1 && lower[0] == 'f' && isDigits(lower[1:]):
+		n, _ := strconv.Atoi(lower[1:])
+		if n < 1 || n > 64 {
+			return keyStroke{}, fmt.Errorf("function key out of range in %q", token)
+		}
+		stroke = keyStroke{key: tcell.KeyF1 + tcell.Key(n-1)}
+	default:
+		key, ok := namedKeys[lower]
+		if !ok {
+			return keyStroke{}, fmt.Errorf("unknown key %q in %q", base, token)
+		}
+		stroke = keyStroke{key: key}
+	}
+
+	if stroke.key == tcell.KeyRune {
+		if mods&tcell.ModShift != 0 {
+			if !unicode.IsLetter(stroke.r) {
+				return keyStroke{}, fmt.Errorf("shift only combines with letters or named keys in %q", token)
+			}
+			if mods&tcell.ModCtrl != 0 {
+				return keyStroke{}, fmt.Errorf("terminals cannot distinguish ctrl+shift+letter in %q", token)
+			}
+			stroke.r = unicode.ToUpper(stroke.r)
+			mods &^= tcell.ModShift
+		}
+		if mods&tcell.ModCtrl != 0 {
+			stroke.r = unicode.ToLower(stroke.r)
+			if !isCtrlRune(stroke.r) {
+				return keyStroke{}, fmt.Errorf("ctrl does not combine with %q in %q", string(stroke.r), token)
+			}
+		}
+	}
+	if stroke.key == tcell.KeyBacktab {
+		mods &^= tcell.ModShift
+	}
+	if stroke.key == tcell.KeyTab && mods == tcell.ModShift {
+		stroke.key, mods = tcell.KeyBacktab, 0
+	}
+	stroke.mods = mods
+	return stroke, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isCtrlRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || r == ' ' || (r >= '[' && r <= '_')
+}
+
+// parseKeySequence parses a space separated binding such as "g g" or
+// "<leader> f". leader is the already parsed leader stroke.
+func parseKeySequence(binding string, leader keyStroke) (keySequence, error) {
+	fields := strings.Fields(binding)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty binding")
+	}
+	seq := make(keySequence, 0, len(fields))
+	for _, field := range fields {
+		if strings.EqualFold(field, leaderToken) {
+			seq = append(seq, leader)
+			continue
+		}
+		stroke, err := parseKeyStroke(field)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, stroke)
+	}
+	return seq, nil
+}
+
+// compileKeybindings parses the bindings of every scope. Invalid bindings are
+// dropped and reported together so the remaining ones keep working. Two actions
+// bound to the same keys in one scope are reported as conflicts; the action
+// listed first in the scope keeps the keys. conflicts holds the losing actions
+// per scope.
+func compileKeybindings(bindings map[string]map[string][]string, leader string) (sequences map[string]map[string][]keySequence, conflicts map[string]map[string]bool, err error) {
+	sequences = map[string]map[string][]keySequence{}
+	conflicts = map[string]map[string]bool{}
+	problems := []string{}
+	leaderStroke, err := parseKeyStroke(leader)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("leader %q: %v", leader, err))
+		leaderStroke, _ = parseKeyStroke(defaultKeyLeader)
+	}
+	parsed := map[string]keySequence{}
+	for _, scope := range keybindingScopes {
+		scoped := map[string][]keySequence{}
+		owners := map[string]string{}
+		for _, action := range scopeActionList(scope.name) {
+			for _, binding := range bindings[scope.name][action] {
+				cacheKey := action + "\x00" + binding
+				seq, ok := parsed[cacheKey]
+				if !ok {
+					var parseErr error
+					seq, parseErr = parseKeySequence(binding, leaderStroke)
+					if parseErr != nil {
+						// Inherited bindings would repeat the error in every scope.
+						problems = appendUnique(problems, fmt.Sprintf("%s %q: %v", action, binding, parseErr))
+						continue
+					}
+					parsed[cacheKey] = seq
+				}
+				name := seq.String()
+				if owner, taken := owners[name]; taken && owner != action {
+					problems = append(problems, fmt.Sprintf("%s: %q is bound to both %s and %s", scope.name, name, owner, action))
+					if conflicts[scope.name] == nil {
+						conflicts[scope.name] = map[string]bool{}
+					}
+					conflicts[scope.name][action] = true
+					continue
+				}
+				owners[name] = action
+				scoped[action] = append(scoped[action], seq)
+			}
+		}
+		sequences[scope.name] = scoped
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return sequences, conflicts, fmt.Errorf("invalid keybindings: %s", strings.Join(problems, "; "))
+	}
+	return sequences, conflicts, nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+type pendingKeys struct {
+	scope   string
+	strokes []keyStroke
+	exact   string
+	at      time.Time
+	gen     int
+}
+
+func (s *AppState) getSequenceTimeout() time.Duration {
+	s.keybindMu.RLock()
+	defer s.keybindMu.RUnlock()
+	if s.keySequenceTimeout <= 0 {
+		return defaultSequenceTimeout
+	}
+	return s.keySequenceTimeout
+}
+
+// matchKeySequences reports the action whose binding in scope equals strokes and
+// whether any binding continues beyond strokes.
+func (s *AppState) matchKeySequences(scope string, actions []string, strokes []keyStroke) (string, bool) {
+	s.keybindMu.RLock()
+	defer s.keybindMu.RUnlock()
+	exact := ""
+	longer := false
+	for _, action := range actions {
+		for _, seq := range s.keySequences[scope][action] {
+			if !seq.hasPrefix(strokes) {
+				continue
+			}
+			if len(seq) == len(strokes) {
+				if exact == "" {
+					exact = action
+				}
+			} else {
+				longer = true
+			}
+		}
+	}
+	return exact, longer
+}
+
+// resolveKeyAction feeds event into the pending key sequence of scope. It returns
+// the completed action, or consumed=true while a longer sequence may still
+// follow. When both a binding and a longer one match, the shorter action runs
+// after the sequence timeout, or is returned as shadowed when the next key does
+// not continue the sequence; shadowed must run before action, like an ambiguous
+// mapping in vim.
+func (s *AppState) resolveKeyAction(scope string, actions []string, event *tcell.EventKey) (shadowed, action string, consumed bool) {
+	stroke := eventToKeyStroke(event)
+	timeout := s.getSequenceTimeout()
+
+	s.keySeqMu.Lock()
+	if s.keySeq.scope != scope || time.Since(s.keySeq.at) > timeout {
+		if s.keySeq.scope == scope && len(s.keySeq.strokes) > 0 {
+			// The timeout passed but the flush has not run yet.
+			shadowed = s.keySeq.exact
+		}
+		s.keySeq.strokes = nil
+		s.keySeq.exact = ""
+	}
+	hadPending := len(s.keySeq.strokes) > 0
+	candidates := [][]keyStroke{append(append([]keyStroke(nil), s.keySeq.strokes...), stroke)}
+	if hadPending {
+		candidates = append(candidates, []keyStroke{stroke})
+	}
+	for i, strokes := range candidates {
+		if i > 0 {
+			// The pending sequence is abandoned; its own binding still runs.
+			shadowed = s.keySeq.exact
+		}
+		exact, longer := s.matchKeySequences(scope, actions, strokes)
+		if longer {
+			s.keySeq.scope = scope
+			s.keySeq.strokes = strokes
+			s.keySeq.exact = exact
+			s.keySeq.at = time.Now()
+			s.keySeq.gen++
+			gen := s.keySeq.gen
+			s.keySeqMu.Unlock()
+			s.setComposeStatus("Keys: " + keySequence(strokes).String() + " …")
+			time.AfterFunc(timeout, func() {
+				s.app.QueueUpdateDraw(func() {
+					s.flushPendingKeys(gen)
+				})
+			})
+			return shadowed, "", true
+		}
+		if exact != "" {
+			s.keySeq.strokes = nil
+			s.keySeq.exact = ""
+			s.keySeqMu.Unlock()
+			if hadPending {
+				s.updateScanStatusTitle()
+			}
+			return shadowed, exact, true
+		}
+	}
+	s.keySeq.strokes = nil
+	s.keySeq.exact = ""
+	s.keySeqMu.Unlock()
+	if hadPending {
+		s.updateScanStatusTitle()
+	}
+	return shadowed, "", false
+}
+
+// flushPendingKeys runs the action that was shadowed by a longer binding once
+// the sequence timed out without another key.
+func (s *AppState) flushPendingKeys(gen int) {
+	s.keySeqMu.Lock()
+	if s.keySeq.gen != gen || len(s.keySeq.strokes) == 0 {
+		s.keySeqMu.Unlock()
+		return
+	}
+	action := s.keySeq.exact
+	s.keySeq.strokes = nil
+	s.keySeq.exact = ""
+	s.keySeqMu.Unlock()
+	s.updateScanStatusTitle()
+	if action != "" {
+		s.runPaneAction(action, s.takeCount())
+	}
+}
+
+func (s *AppState) hasPendingKeys(scope string) bool {
+	timeout := s.getSequenceTimeout()
+	s.keySeqMu.Lock()
+	defer s.keySeqMu.Unlock()
+	return s.keySeq.scope == scope && len(s.keySeq.strokes) > 0 && time.Since(s.keySeq.at) <= timeout
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/rivo/tview"
+	"golang.org/x/net/html"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const pageMessageLinks = "pageMessageLinks"
+
+var bareURLRegex = regexp.MustCompile(`https?://[^\s<>"']+[^\s<>"'.,;:!?)\]]`)
+
+type messageLink struct {
+	url  string
+	text string
+}
+
+func isFollowableLink(link string) bool {
+	lower := strings.ToLower(strings.TrimSpace(link))
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:")
+}
+
+// textMessageWithLinks renders content like textMessage and numbers every link
+// after its text, e.g. "design doc [1]". The same URL keeps its first number.
+func textMessageWithLinks(input string) (string, []messageLink) {
+	output := ""
+	links := []messageLink{}
+	numbers := map[string]int{}
+	number := func(link, text string) int {
+		if n, ok := numbers[link]; ok {
+			return n
+		}
+		links = append(links, messageLink{url: link, text: strings.TrimSpace(text)})
+		numbers[link] = len(links)
+		return len(links)
+	}
+	appendMarker := func(n int) {
+		output = strings.TrimSuffix(output, "\n") + fmt.Sprintf(" [%d]\n", n)
+	}
+
+	href := ""
+	anchorText := ""
+	z := html.NewTokenizer(bytes.NewBufferString(input))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		switch tt {
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			href, anchorText = "", ""
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = z.TagAttr()
+				if string(key) == "href" && isFollowableLink(string(value)) {
+					href = strings.TrimSpace(string(value))
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) == "a" && href != "" {
+				appendMarker(number(href, anchorText))
+				href = ""
+			}
+		case html.TextToken:
+			text := string(z.Text())
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			if href != "" {
+				anchorText += text
+				output += fmt.Sprintf("%v\n", text)
+				continue
+			}
+			// Bare URLs outside anchors are numbered in place.
+			text = bareURLRegex.ReplaceAllStringFunc(text, func(link string) string {
+				return fmt.Sprintf("%s [%d]", link, number(link, link))
+			})
+			output += fmt.Sprintf("%v\n", text)
+		}
+	}
+	return output, links
+}
+
+func messageLinks(content string) []messageLink {
+	_, links := textMessageWithLinks(content)
+	return links
+}
+
+type teamsDeepLink struct {
+	kind           string
+	conversationID string
+	messageID      string
+}
+
+// parseTeamsDeepLink understands https://teams.microsoft.com/l/{chat,channel,message}/...
+// links, and the same paths without a host as used by feed target links. The
+// conversation id is returned unescaped and normalized.
+func parseTeamsDeepLink(link string) (teamsDeepLink, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return teamsDeepLink{}, false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host != "" && host != "teams.microsoft.com" && host != "teams.live.com" && !strings.HasSuffix(host, ".teams.microsoft.com") {
+		return teamsDeepLink{}, false
+	}
+	parts := strings.Split(strings.Trim(parsed.EscapedPath(), "/"), "/")
+	if len(parts) < 3 || parts[0] != "l" {
+		return teamsDeepLink{}, false
+	}
+	kind := strings.ToLower(parts[1])
+	if kind != "chat" && kind != "channel" && kind != "message" {
+		return teamsDeepLink{}, false
+	}
+	conversationID, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return teamsDeepLink{}, false
+	}
+	out := teamsDeepLink{kind: kind, conversationID: normalizeFavoriteKey(conversationID)}
+	if kind == "message" && len(parts) > 3 {
+		out.messageID, _ = url.PathUnescape(parts[3])
+	}
+	if out.conversationID == "" || out.conversationID == "0" {
+		return teamsDeepLink{}, false
+	}
+	return out, true
+}
+
+// findConversationNode returns the tree node of a chat or channel by
+// conversation id.
+func (s *AppState) findConversationNode(conversationID string) *tview.TreeNode {
+	// The full tree is searched, also while a filter hides parts of it.
+	if s.tree.root == nil {
+		return nil
+	}
+	want := normalizeFavoriteKey(conversationID)
+	if want == "" {
+		return nil
+	}
+	var found *tview.TreeNode
+	s.tree.root.Walk(func(node, parent *tview.TreeNode) bool {
+		if found != nil {
+			return false
+		}
+		switch ref := node.GetReference().(type) {
+		case conversationRef:
+			if normalizeFavoriteKey(ref.chatKey) == want {
+				found = node
+			}
+			for _, id := range ref.ids {
+				if normalizeFavoriteKey(id) == want {
+					found = node
+				}
+			}
+		case channelRef:
+			if normalizeFavoriteKey(ref.channel.Id) == want {
+				found = node
+			}
+		}
+		return found == nil
+	})
+	return found
+}
+
+// openInBrowser starts the first usable $BROWSER entry, or the platform
+// opener, without waiting for it.
+func openInBrowser(link string) error {
+	// $BROWSER may list several commands separated by colons.
+	for _, entry := range strings.Split(os.Getenv("BROWSER"), ":") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		args := []string{}
+		replaced := false
+		for _, field := range fields[1:] {
+			if strings.Contains(field, "%s") {
+				field = strings.ReplaceAll(field, "%s", link)
+				replaced = true
+			}
+			args = append(args, field)
+		}
+		if !replaced {
+			args = append(args, link)
+		}
+		if err := startDetached(exec.Command(fields[0], args...)); err == nil {
+			return nil
+		}
+	}
+	if runtime.GOOS == "darwin" {
+		return startDetached(exec.Command("open", link))
+	}
+	return startDetached(exec.Command("xdg-open", link))
+}
+
+// startDetached starts cmd and reaps it in the background.
+func startDetached(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		_ = cmd.Wait()
+	}()
+	return nil
+}
+
+// openMessageLink switches to known Teams conversations in the app and sends
+// every other link to the browser.
+func (s *AppState) openMessageLink(link string) {
+	if deepLink, ok := parseTeamsDeepLink(link); ok {
+		if node := s.findConversationNode(deepLink.conversationID); node != nil {
+			s.openTeamsDeepLink(deepLink, node)
+			return
+		}
+	}
+	if err := openInBrowser(link); err != nil {
+		s.setComposeStatus("Open failed: " + err.Error())
+		return
+	}
+	s.setComposeStatus("Opened " + link)
+}
+
+// pickMessageLink lets the user choose one of the selected message's links; a
+// single link is used directly.
+func (s *AppState) pickMessageLink(title string, onPick func(link string)) {
+	msg, ok := s.selectedChatMessage()
+	if !ok {
+		s.setComposeStatus("Select a message first")
+		return
+	}
+	links := messageLinks(msg.Content)
+	switch len(links) {
+	case 0:
+		s.setComposeStatus("No links in message")
+		return
+	case 1:
+		onPick(links[0].url)
+		return
+	}
+	items := make([]pickerItem, 0, len(links))
+	for i, link := range links {
+		secondary := link.text
+		if deepLink, ok := parseTeamsDeepLink(link.url); ok {
+			if node := s.findConversationNode(deepLink.conversationID); node != nil {
+				_, target, _ := treeNodeTarget(node)
+				secondary = "Teams: " + target
+			}
+		}
+		if secondary == link.url {
+			secondary = ""
+		}
+		items = append(items, pickerItem{
+			label:     fmt.Sprintf("[%d] %s", i+1, link.url),
+			secondary: secondary,
+			keywords:  []string{link.text},
+			value:     link.url,
+		})
+	}
+	s.showPicker(pageMessageLinks, title, items, func(item pickerItem) {
+		onPick(item.value.(string))
+	})
+}
+
+func (s *AppState) openSelectedMessageLink() {
+	s.pickMessageLink("Open link", s.openMessageLink)
+}
+
+func (s *AppState) copySelectedMessageLink() {
+	s.pickMessageLink("Copy link", func(link string) {
+		s.copyWithStatus(link, link)
+	})
+}
+
+// renderMessageText is the chat pane text of a message, with numbered links.
+func renderMessageText(message csa.ChatMessage) string {
+	text, _ := textMessageWithLinks(message.Content)
+	return text
+}
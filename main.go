@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/rivo/tview"
@@ -8,6 +9,29 @@ import (
 )
 
 func main() {
+	opts, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "teams-cli: %v\n\n%s\n", err, cliUsage)
+		os.Exit(2)
+	}
+	if opts.help {
+		fmt.Println(cliUsage)
+		return
+	}
+
+	// Without --profile the UI asks when there is more than one account;
+	// commands without the UI use the default profile.
+	account, _ := profileByName(opts.profile)
+	if opts.profile == "" && opts.newChat == nil && opts.login == nil {
+		if profiles := listAccountProfiles(); len(profiles) > 1 {
+			chosen, ok := chooseAccountAtStartup(profiles)
+			if !ok {
+				return
+			}
+			account = chosen
+		}
+	}
+
 	app := tview.NewApplication()
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.TextFormatter{
@@ -23,11 +47,30 @@ func main() {
 	logger.WithFields(logrus.Fields{
 		"log_file": logFile,
 		"pid":      os.Getpid(),
+		"profile":  account.displayName(),
 	}).Info("teams-cli starting")
 
 	state := AppState{
-		app:    app,
-		logger: logger,
+		app:         app,
+		logger:      logger,
+		account:     account,
+		startupLink: opts.openLink,
+	}
+
+	if opts.login != nil {
+		if err = state.runLoginCommand(*opts.login); err != nil {
+			fmt.Fprintf(os.Stderr, "teams-cli: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.newChat != nil {
+		if err = state.runNewChatCommand(*opts.newChat); err != nil {
+			fmt.Fprintf(os.Stderr, "teams-cli: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	state.createApp()
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/fossteams/teams-api/pkg/models"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+const (
+	pageMemberActions = "pageMemberActions"
+
+	membersPaneWidth = 36
+
+	// profileBatchSize bounds the MRIs sent per short profile request.
+	profileBatchSize = 50
+)
+
+// conversationMember is one row of the member pane.
+type conversationMember struct {
+	displayName string
+	mri         string
+	objectID    string
+	role        string
+	email       string
+	isGuest     bool
+}
+
+func (m conversationMember) isAdmin() bool {
+	return strings.EqualFold(m.role, string(csa.ChatMemberAdmin))
+}
+
+// roleLabel is the role shown next to a member. Thread admins of a team are its
+// owners; plain members show nothing.
+func (m conversationMember) roleLabel(isTeam bool) string {
+	switch {
+	case m.isGuest:
+		return "Guest"
+	case m.isAdmin() && isTeam:
+		return "Owner"
+	case m.isAdmin():
+		return "Admin"
+	}
+	return ""
+}
+
+// memberSource says where the members of a conversation come from: the loaded
+// chat, or the roster of the team a channel belongs to.
+type memberSource struct {
+	key    string
+	title  string
+	chat   *csa.Chat
+	teamID string
+}
+
+func (s *AppState) memberSourceForNode(node *tview.TreeNode) (memberSource, bool) {
+	if node == nil {
+		return memberSource{}, false
+	}
+	switch ref := node.GetReference().(type) {
+	case conversationRef:
+		if ref.chatKey == settingsHelpChatKey {
+			return memberSource{}, false
+		}
+		chat, ok := s.chatsByKey()[normalizeFavoriteKey(ref.chatKey)]
+		if !ok {
+			return memberSource{}, false
+		}
+		return memberSource{key: normalizeFavoriteKey(ref.chatKey), title: ref.title, chat: &chat}, true
+	case channelRef:
+		teamID := strings.TrimSpace(ref.channel.ParentTeamId)
+		if conversations := s.conversationsSnapshot(); teamID == "" && conversations != nil {
+			for _, team := range conversations.Teams {
+				for _, channel := range team.Channels {
+					if channel.Id == ref.channel.Id {
+						teamID = team.Id
+					}
+				}
+			}
+		}
+		if teamID == "" {
+			return memberSource{}, false
+		}
+		return memberSource{key: normalizeFavoriteKey(ref.channel.Id), title: ref.teamName, teamID: teamID}, true
+	}
+	return memberSource{}, false
+}
+
+// fetchThreadMembers lists the members of a chat service thread, such as the
+// general thread of a team.
+func (s *AppState) fetchThreadMembers(threadID string) ([]conversationMember, error) {
+	endpoint := csa.MessagesHost + "v1/threads/" + url.QueryEscape(threadID) + "?view=msnp24Equivalent"
+	body, err := s.chatServiceRequestBody(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	var thread struct {
+		Members []struct {
+			ID           string `json:"id"`
+			Role         string `json:"role"`
+			FriendlyName string `json:"friendlyName"`
+		} `json:"members"`
+	}
+	if err := json.Unmarshal(body, &thread); err != nil {
+		return nil, fmt.Errorf("invalid thread response: %v", err)
+	}
+	members := make([]conversationMember, 0, len(thread.Members))
+	for _, member := range thread.Members {
+		mri := strings.TrimSpace(member.ID)
+		// Bots and connectors use other MRI prefixes and have no profile.
+		if !strings.HasPrefix(mri, "8:") {
+			continue
+		}
+		members = append(members, conversationMember{
+			displayName: strings.TrimSpace(member.FriendlyName),
+			mri:         mri,
+			role:        strings.TrimSpace(member.Role),
+		})
+	}
+	return members, nil
+}
+
+// resolveMemberProfiles fills in names, object ids and email addresses from the
+// directory. Profiles are cached by MRI for the session.
+func (s *AppState) resolveMemberProfiles(members []conversationMember) []conversationMember {
+	missing := []string{}
+	s.membersMu.RLock()
+	for _, member := range members {
+		if _, ok := s.profiles[strings.ToLower(member.mri)]; !ok && member.mri != "" {
+			missing = append(missing, member.mri)
+		}
+	}
+	s.membersMu.RUnlock()
+
+	for start := 0; start < len(missing); start += profileBatchSize {
+		end := start + profileBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		users, err := s.teamsClient.FetchShortProfile(missing[start:end])
+		if err != nil {
+			s.logger.WithError(err).Warn("unable to fetch member profiles")
+			break
+		}
+		s.membersMu.Lock()
+		if s.profiles == nil {
+			s.profiles = map[string]models.User{}
+		}
+		for _, user := range users {
+			if key := strings.ToLower(strings.TrimSpace(user.Mri)); key != "" {
+				s.profiles[key] = user
+			}
+		}
+		s.membersMu.Unlock()
+	}
+
+	s.membersMu.RLock()
+	defer s.membersMu.RUnlock()
+	out := make([]conversationMember, 0, len(members))
+	for _, member := range members {
+		if profile, ok := s.profiles[strings.ToLower(member.mri)]; ok {
+			if name := strings.TrimSpace(profile.DisplayName); name != "" {
+				member.displayName = name
+			}
+			if member.objectID == "" {
+				member.objectID = strings.TrimSpace(profile.ObjectId)
+			}
+			member.email = profileEmail(profile)
+			member.isGuest = strings.EqualFold(profile.UserType, "Guest")
+		}
+		if member.displayName == "" {
+			member.displayName = member.mri
+		}
+		out = append(out, member)
+	}
+	return out
+}
+
+func profileEmail(profile models.User) string {
+	for _, candidate := range []string{profile.Email, profile.Mail, profile.UserPrincipalName} {
+		if candidate = strings.TrimSpace(candidate); strings.Contains(candidate, "@") {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// loadMembers collects the members of source with profiles and presence and
+// caches them under the conversation key.
+func (s *AppState) loadMembers(source memberSource) ([]conversationMember, error) {
+	var members []conversationMember
+	if source.chat != nil {
+		for _, member := range source.chat.Members {
+			members = append(members, conversationMember{
+				displayName: strings.TrimSpace(member.FriendlyName),
+				mri:         strings.TrimSpace(member.Mri),
+				objectID:    strings.TrimSpace(member.ObjectId),
+				role:        string(member.Role),
+			})
+		}
+	} else {
+		roster, err := s.fetchThreadMembers(source.teamID)
+		if err != nil {
+			return nil, err
+		}
+		members = roster
+	}
+	members = s.resolveMemberProfiles(members)
+
+	mris := make([]string, 0, len(members))
+	for _, member := range members {
+		mris = append(mris, member.mri)
+	}
+	if err := s.fetchPresence(mris); err != nil {
+		s.logger.WithError(err).Debug("presence unavailable for member list")
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].isAdmin() != members[j].isAdmin() {
+			return members[i].isAdmin()
+		}
+		return strings.ToLower(members[i].displayName) < strings.ToLower(members[j].displayName)
+	})
+
+	s.membersMu.Lock()
+	if s.memberCache == nil {
+		s.memberCache = map[string][]conversationMember{}
+	}
+	s.memberCache[source.key] = members
+	s.membersMu.Unlock()
+	return members, nil
+}
+
+// memberMentionCandidates offers the cached members of conversationIDs for
+// mentions, so channel members can be mentioned without having posted.
+func (s *AppState) memberMentionCandidates(conversationIDs []string) []mentionCandidate {
+	s.membersMu.RLock()
+	defer s.membersMu.RUnlock()
+	candidates := []mentionCandidate{}
+	for _, id := range conversationIDs {
+		for _, member := range s.memberCache[normalizeFavoriteKey(id)] {
+			if strings.EqualFold(member.mri, s.selfMri()) {
+				continue
+			}
+			candidates = append(candidates, mentionCandidate{
+				DisplayName: member.displayName,
+				Mri:         member.mri,
+				ObjectID:    member.objectID,
+			})
+		}
+	}
+	return candidates
+}
+
+func (s *AppState) isMembersPaneVisible() bool {
+	s.membersMu.RLock()
+	defer s.membersMu.RUnlock()
+	return s.membersVisible
+}
+
+// toggleMembersPane shows or hides the member pane right of the chat.
+func (s *AppState) toggleMembersPane() {
+	flex, ok := s.components[FlMain].(*tview.Flex)
+	membersView, listOK := s.components[ViMembers].(*tview.List)
+	if !ok || !listOK {
+		return
+	}
+	s.membersMu.Lock()
+	s.membersVisible = !s.membersVisible
+	visible := s.membersVisible
+	s.membersMu.Unlock()
+
+	if !visible {
+		flex.RemoveItem(membersView)
+		if s.app.GetFocus() == membersView {
+			s.app.SetFocus(s.components[TrChat])
+		}
+		return
+	}
+	flex.AddItem(membersView, membersPaneWidth, 0, false)
+	s.refreshMembersPane()
+}
+
+// refreshMembersPane shows the members of the active conversation, loading them
+// in the background. It must run on the UI goroutine.
+func (s *AppState) refreshMembersPane() {
+	membersView, ok := s.components[ViMembers].(*tview.List)
+	if !ok || !s.isMembersPaneVisible() {
+		return
+	}
+	_, _, node := s.getActiveConversation()
+	source, ok := s.memberSourceForNode(node)
+	s.membersMu.Lock()
+	s.membersSource = source
+	cached, hasCache := s.memberCache[source.key]
+	s.membersMu.Unlock()
+	if !ok {
+		s.resetMembersList(membersView, "Members", nil)
+		membersView.AddItem("[gray]No member list for this conversation[-]", "", 0, nil)
+		return
+	}
+	if hasCache {
+		s.renderMembersFor(membersView, source, cached)
+	} else {
+		s.resetMembersList(membersView, "Members · loading", nil)
+	}
+
+	go func() {
+		members, err := s.loadMembers(source)
+		if err != nil {
+			s.logger.WithError(err).WithFields(logrus.Fields{
+				"conversation": source.key,
+				"team_id":      source.teamID,
+			}).Warn("unable to load members")
+		}
+		s.app.QueueUpdateDraw(func() {
+			s.membersMu.RLock()
+			current := s.membersSource
+			s.membersMu.RUnlock()
+			if current.key != source.key || !s.isMembersPaneVisible() {
+				return
+			}
+			if err != nil {
+				if !hasCache {
+					s.resetMembersList(membersView, "Members", nil)
+					membersView.AddItem("[red]Unable to load members[-]", "", 0, nil)
+				}
+				return
+			}
+			s.renderMembersFor(membersView, source, members)
+		})
+	}()
+}
+
+func (s *AppState) renderMembersFor(membersView *tview.List, source memberSource, members []conversationMember) {
+	title := fmt.Sprintf("Members (%d)", len(members))
+	if source.chat == nil && source.title != "" {
+		title = fmt.Sprintf("%s (%d)", source.title, len(members))
+	}
+	selected := membersView.GetCurrentItem()
+	s.resetMembersList(membersView, title, members)
+	for _, member := range members {
+		line := presenceDot(s.presenceOf(member.mri).availability) + tview.Escape(member.displayName)
+		if strings.EqualFold(member.mri, s.selfMri()) {
+			line += " (you)"
+		}
+		details := []string{}
+		if role := member.roleLabel(source.chat == nil); role != "" {
+			details = append(details, role)
+		}
+		if presence := s.presenceOf(member.mri).label(); presence != "" {
+			details = append(details, presence)
+		}
+		if len(details) > 0 {
+			line += " [gray]" + strings.Join(details, " · ") + "[-]"
+		}
+		membersView.AddItem(line, "", 0, nil)
+	}
+	if selected < len(members) {
+		membersView.SetCurrentItem(selected)
+	}
+}
+
+// resetMembersList clears the pane and remembers members as its rows. The
+// caller adds the visible lines.
+func (s *AppState) resetMembersList(membersView *tview.List, title string, members []conversationMember) {
+	membersView.Clear()
+	membersView.SetTitle(title)
+	s.membersMu.Lock()
+	s.membersList = members
+	s.membersMu.Unlock()
+}
+
+func (s *AppState) selectedMember() (conversationMember, bool) {
+	membersView, ok := s.components[ViMembers].(*tview.List)
+	if !ok {
+		return conversationMember{}, false
+	}
+	idx := membersView.GetCurrentItem()
+	s.membersMu.RLock()
+	defer s.membersMu.RUnlock()
+	if idx < 0 || idx >= len(s.membersList) {
+		return conversationMember{}, false
+	}
+	return s.membersList[idx], true
+}
+
+// bindMembersPane wires the member pane keys. Enter offers every member action.
+func (s *AppState) bindMembersPane() {
+	membersView, ok := s.components[ViMembers].(*tview.List)
+	if !ok {
+		return
+	}
+	membersView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				s.logger.WithFields(logrus.Fields{
+					"panic": recovered,
+					"stack": string(debug.Stack()),
+				}).Error("panic in members input handler")
+			}
+		}()
+
+		return s.dispatchPaneAction(scopeMembers, event)
+	})
+	membersView.SetSelectedFunc(func(int, string, string, rune) {
+		s.showMemberActions()
+	})
+}
+
+func (s *AppState) showMemberActions() {
+	member, ok := s.selectedMember()
+	if !ok {
+		return
+	}
+	items := []pickerItem{
+		{label: "Start chat", secondary: s.formatActionBindingLine(scopeMembers, actionMemberChat), value: actionMemberChat},
+		{label: "Mention in compose", secondary: s.formatActionBindingLine(scopeMembers, actionMemberMention), value: actionMemberMention},
+	}
+	if member.email != "" {
+		items = append(items, pickerItem{label: "Copy email " + member.email, secondary: s.formatActionBindingLine(scopeMembers, actionMemberEmail), value: actionMemberEmail})
+	}
+	_, _, node := s.getActiveConversation()
+	if _, _, err := s.groupChatForNode(node); err == nil && !strings.EqualFold(member.mri, s.selfMri()) {
+		items = append(items, pickerItem{label: "Remove from chat", secondary: s.formatActionBindingLine(scopeMembers, actionRemoveMember), value: actionRemoveMember})
+	}
+	s.showPicker(pageMemberActions, member.displayName, items, func(item pickerItem) {
+		if action, ok := item.value.(string); ok {
+			s.runAction(action)
+		}
+	})
+}
+
+// startChatWithMember opens the one on one chat with member, creating it on the
+// server when there is none yet.
+func (s *AppState) startChatWithMember(member conversationMember) {
+	if strings.EqualFold(member.mri, s.selfMri()) {
+		s.setComposeStatus("That is you")
+		return
+	}
+	s.startChat([]mentionCandidate{{
+		DisplayName: member.displayName,
+		Mri:         member.mri,
+		ObjectID:    member.objectID,
+	}}, "")
+}
+
+// findOneOnOneChatNode returns the tree node of the one on one chat with mri.
+func (s *AppState) findOneOnOneChatNode(mri string) *tview.TreeNode {
+	conversations := s.conversationsSnapshot()
+	if conversations == nil {
+		return nil
+	}
+	for _, chat := range conversations.Chats {
+		if !chat.IsOneOnOne {
+			continue
+		}
+		for _, member := range chat.Members {
+			if strings.EqualFold(strings.TrimSpace(member.Mri), mri) {
+				return s.findConversationNode(chat.Id)
+			}
+		}
+	}
+	return nil
+}
+
+// mentionMember appends an @mention of member to the compose text.
+func (s *AppState) mentionMember(member conversationMember) {
+	composeView, ok := s.components[ViCompose].(*tview.InputField)
+	if !ok {
+		return
+	}
+	token := mentionTokenFromDisplayName(member.displayName)
+	if token == "" {
+		s.setComposeStatus("Cannot mention " + member.displayName)
+		return
+	}
+	text := composeView.GetText()
+	if text != "" && !strings.HasSuffix(text, " ") {
+		text += " "
+	}
+	composeView.SetText(text + "@" + token + " ")
+	s.app.SetFocus(composeView)
+	s.setComposeStatus("Mention: " + member.displayName)
+}
+
+func (s *AppState) copyMemberEmail(member conversationMember) {
+	if member.email == "" {
+		s.setComposeStatus("No email known for " + member.displayName)
+		return
+	}
+	s.copyWithStatus(member.email, member.email)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	pageSearchMessages = "pageSearchMessages"
+	pageForwardMessage = "pageForwardMessage"
+)
+
+var exportFileNameRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// selectedChatMessage returns the message under the chat pane cursor.
+func (s *AppState) selectedChatMessage() (csa.ChatMessage, bool) {
+	if s.isSettingsMode() {
+		return csa.ChatMessage{}, false
+	}
+	chatView, ok := s.components[ViChat].(*tview.List)
+	if !ok {
+		return csa.ChatMessage{}, false
+	}
+	return s.getCurrentChatMessage(chatView.GetCurrentItem())
+}
+
+func (s *AppState) currentChatMessages() []csa.ChatMessage {
+	s.chatMessagesMu.RLock()
+	defer s.chatMessagesMu.RUnlock()
+	return append([]csa.ChatMessage(nil), s.chatMessages...)
+}
+
+// selectChatMessageIndex moves the chat cursor to the first row rendered for the
+// message at messageIdx.
+func (s *AppState) selectChatMessageIndex(messageIdx int) bool {
+	chatView, ok := s.components[ViChat].(*tview.List)
+	if !ok {
+		return false
+	}
+	s.chatMessagesMu.RLock()
+	row := -1
+	if len(s.chatRowMap) == 0 {
+		if messageIdx >= 0 && messageIdx < len(s.chatMessages) {
+			row = messageIdx
+		}
+	} else {
+		for i, idx := range s.chatRowMap {
+			if idx == messageIdx {
+				row = i
+				break
+			}
+		}
+	}
+	s.chatMessagesMu.RUnlock()
+	if row < 0 || row >= chatView.GetItemCount() {
+		return false
+	}
+	chatView.SetCurrentItem(row)
+	return true
+}
+
+func (s *AppState) messageAuthor(message csa.ChatMessage) string {
+	author := strings.TrimSpace(message.ImDisplayName)
+	if author == "" {
+		author = inferMessageAuthor(message, s.me)
+	}
+	return author
+}
+
+func defaultExportDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(homeDir) == "" {
+		return "teams-cli-exports"
+	}
+	return filepath.Join(homeDir, "teams-cli-exports")
+}
+
+// exportActiveChat writes the loaded messages of the active conversation to a
+// markdown file and returns its path.
+func (s *AppState) exportActiveChat() (string, error) {
+	ids, title, _ := s.getActiveConversation()
+	messages := s.currentChatMessages()
+	if len(ids) == 0 || len(messages) == 0 {
+		return "", fmt.Errorf("no messages loaded")
+	}
+	name := strings.Trim(exportFileNameRegex.ReplaceAllString(title, "_"), "_")
+	if name == "" {
+		name = "chat"
+	}
+	dir := defaultExportDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+"-"+time.Now().Format("20060102-150405")+".md")
+
+	var b strings.Builder
+	b.WriteString("# " + title + "\n\n")
+	for _, message := range messages {
+		if !isUserVisibleMessage(message) {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("**%s** — %s\n\n", s.messageAuthor(message), time.Time(message.ComposeTime).Local().Format("2006-01-02 15:04")))
+		b.WriteString(strings.TrimSpace(textMessage(message.Content)) + "\n\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return "", err
+	}
+	s.logger.WithFields(logrus.Fields{
+		"title": title,
+		"path":  path,
+	}).Info("chat exported")
+	return path, nil
+}
+
+// searchChatMessages collects the loaded messages whose text or author contains
+// query and selects the newest match. Matches are kept for search_next/prev.
+func (s *AppState) searchChatMessages(query string) int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	matches := []int{}
+	if query != "" {
+		for i, message := range s.currentChatMessages() {
+			text := strings.ToLower(textMessage(message.Content) + " " + s.messageAuthor(message))
+			if strings.Contains(text, query) {
+				matches = append(matches, i)
+			}
+		}
+	}
+	s.searchMu.Lock()
+	s.searchQuery = query
+	s.searchMatches = matches
+	s.searchPos = len(matches) - 1
+	s.searchMu.Unlock()
+	if len(matches) > 0 {
+		s.selectChatMessageIndex(matches[len(matches)-1])
+	}
+	return len(matches)
+}
+
+// stepSearchMatch moves to the next older match, or the next newer one when
+// backwards is set, wrapping around at either end.
+func (s *AppState) stepSearchMatch(backwards bool) {
+	s.searchMu.Lock()
+	query := s.searchQuery
+	count := len(s.searchMatches)
+	if count == 0 {
+		s.searchMu.Unlock()
+		if query == "" {
+			s.setComposeStatus("No active search")
+		} else {
+			s.setComposeStatus("No matches for " + query)
+		}
+		return
+	}
+	if backwards {
+		s.searchPos = (s.searchPos + 1) % count
+	} else {
+		s.searchPos = (s.searchPos - 1 + count) % count
+	}
+	pos := s.searchPos
+	target := s.searchMatches[pos]
+	s.searchMu.Unlock()
+	s.selectChatMessageIndex(target)
+	s.setComposeStatus(fmt.Sprintf("Match %d/%d for %s", count-pos, count, query))
+}
+
+func (s *AppState) promptSearchMessages() {
+	ids, _, _ := s.getActiveConversation()
+	if len(ids) == 0 {
+		s.setComposeStatus("Open a conversation first")
+		return
+	}
+	s.searchMu.Lock()
+	initial := s.searchQuery
+	s.searchMu.Unlock()
+	s.promptText(pageSearchMessages, "Search messages", "/", initial, func(text string, ok bool) {
+		if !ok {
+			return
+		}
+		count := s.searchChatMessages(text)
+		if count == 0 {
+			s.setComposeStatus("No matches for " + strings.TrimSpace(text))
+			return
+		}
+		if chat, ok := s.components[ViChat]; ok {
+			s.app.SetFocus(chat)
+		}
+		s.setComposeStatus(fmt.Sprintf("Match 1/%d for %s", count, strings.TrimSpace(text)))
+	})
+}
+
+// treeNodeTarget returns the conversation ids and title a tree node points at.
+func treeNodeTarget(node *tview.TreeNode) ([]string, string, bool) {
+	if node == nil {
+		return nil, "", false
+	}
+	switch ref := node.GetReference().(type) {
+	case conversationRef:
+		if ref.chatKey == settingsHelpChatKey || len(ref.ids) == 0 {
+			return nil, "", false
+		}
+		return ref.ids, ref.title, true
+	case channelRef:
+		return []string{ref.channel.Id}, ref.channel.DisplayName, true
+	}
+	return nil, "", false
+}
+
+func formatForwardHTML(author, content string) string {
+	lines := strings.Split(strings.TrimSpace(textMessage(content)), "\n")
+	for i := range lines {
+		lines[i] = html.EscapeString(strings.TrimSpace(lines[i]))
+	}
+	return "<blockquote><strong>Forwarded from " + html.EscapeString(author) + ":</strong><br/>" +
+		strings.Join(lines, "<br/>") + "</blockquote>"
+}
+
+// forwardSelectedMessage asks for a target conversation and reposts the selected
+// messages there as quotes.
+func (s *AppState) forwardSelectedMessage() {
+	messages := s.selectedMessagesForAction()
+	if len(messages) == 0 {
+		s.setComposeStatus("Select a message first")
+		return
+	}
+	items := []pickerItem{}
+	for _, item := range s.buildQuickOpenItems() {
+		if node, ok := item.value.(*tview.TreeNode); ok {
+			if _, _, ok := treeNodeTarget(node); ok {
+				items = append(items, item)
+			}
+		}
+	}
+	if len(items) == 0 {
+		s.setComposeStatus("No conversations to forward to")
+		return
+	}
+	var content strings.Builder
+	for _, msg := range messages {
+		content.WriteString(formatForwardHTML(s.messageAuthor(msg), msg.Content))
+	}
+	title := "Forward message to"
+	if len(messages) > 1 {
+		title = fmt.Sprintf("Forward %d messages to", len(messages))
+	}
+	s.exitVisualSelection()
+	s.showPicker(pageForwardMessage, title, items, func(item pickerItem) {
+		ids, target, ok := treeNodeTarget(item.value.(*tview.TreeNode))
+		if !ok {
+			return
+		}
+		s.setComposeStatus("Forwarding to " + target + "...")
+		go func() {
+			err := s.postMessageHTML(ids, content.String(), nil)
+			s.app.QueueUpdateDraw(func() {
+				if err != nil {
+					s.logger.WithError(err).WithField("target", target).Warn("unable to forward message")
+					s.setComposeStatus("Forward failed")
+					return
+				}
+				s.setComposeStatus("Forwarded to " + target)
+			})
+		}()
+	})
+}
+
+func messageEndpoint(conversationID, messageID string) string {
+	return csa.MessagesHost + "v1/users/ME/conversations/" + url.QueryEscape(conversationID) + "/messages/" + url.QueryEscape(messageID)
+}
+
+// lastOwnMessage returns the newest loaded message sent by the current user.
+func (s *AppState) lastOwnMessage() (csa.ChatMessage, bool) {
+	messages := s.currentChatMessages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if isUserVisibleMessage(messages[i]) && isMessageFromUser(messages[i], s.me) {
+			return messages[i], true
+		}
+	}
+	return csa.ChatMessage{}, false
+}
+
+func (s *AppState) editMessageOnServer(message csa.ChatMessage, content string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"content":     formatOutgoingHTML(content, nil),
+		"messagetype": "RichText/Html",
+		"contenttype": "text",
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encode edited message: %v", err)
+	}
+	return s.chatServiceRequest(http.MethodPut, messageEndpoint(message.ConversationId, message.Id), body)
+}
+
+func (s *AppState) deleteMessageOnServer(message csa.ChatMessage) error {
+	return s.chatServiceRequest(http.MethodDelete, messageEndpoint(message.ConversationId, message.Id)+"?behavior=softDelete", nil)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"strings"
+)
+
+const (
+	modeNormal = "NORMAL"
+	modeInsert = "INSERT"
+	modeVisual = "VISUAL"
+
+	visualRowMarker = "[yellow]▌[-]"
+)
+
+// isModalEditing reports whether the vim preset's normal/insert/visual layer is
+// active.
+func (s *AppState) isModalEditing() bool {
+	return strings.EqualFold(strings.TrimSpace(s.getCurrentKeybindPreset()), "vim")
+}
+
+// editModeLabel is shown in the compose title, e.g. "NORMAL" or "NORMAL 5".
+func (s *AppState) editModeLabel() string {
+	if !s.isModalEditing() {
+		return ""
+	}
+	s.modeMu.Lock()
+	defer s.modeMu.Unlock()
+	mode := s.editMode
+	if mode == "" {
+		mode = modeNormal
+	}
+	if s.visualAnchor >= 0 {
+		mode = modeVisual
+	}
+	if s.pendingCount > 0 {
+		mode += fmt.Sprintf(" %d", s.pendingCount)
+	}
+	return mode
+}
+
+func (s *AppState) setEditMode(mode string) {
+	s.modeMu.Lock()
+	s.editMode = mode
+	s.pendingCount = 0
+	s.modeMu.Unlock()
+	s.updateScanStatusTitle()
+}
+
+// takeCount returns and clears the count typed before a normal mode action. It
+// is at least 1.
+func (s *AppState) takeCount() int {
+	s.modeMu.Lock()
+	count := s.pendingCount
+	s.pendingCount = 0
+	s.modeMu.Unlock()
+	if count > 0 {
+		s.updateScanStatusTitle()
+	}
+	if count < 1 {
+		return 1
+	}
+	return count
+}
+
+func (s *AppState) clearCount() {
+	s.modeMu.Lock()
+	had := s.pendingCount > 0
+	s.pendingCount = 0
+	s.modeMu.Unlock()
+	if had {
+		s.updateScanStatusTitle()
+	}
+}
+
+// handleModalKey consumes normal mode keys that are not bindings: count digits
+// and Esc to leave visual mode.
+func (s *AppState) handleModalKey(scope string, event *tcell.EventKey) bool {
+	if event.Key() == tcell.KeyEscape {
+		if s.isVisualSelection() {
+			s.exitVisualSelection()
+			return true
+		}
+		s.clearCount()
+		return false
+	}
+	if event.Key() != tcell.KeyRune || event.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0 || s.hasPendingKeys(scope) {
+		return false
+	}
+	r := event.Rune()
+	if r < '0' || r > '9' {
+		return false
+	}
+	s.modeMu.Lock()
+	if r == '0' && s.pendingCount == 0 {
+		s.modeMu.Unlock()
+		return false
+	}
+	if s.pendingCount < 10000 {
+		s.pendingCount = s.pendingCount*10 + int(r-'0')
+	}
+	s.modeMu.Unlock()
+	s.updateScanStatusTitle()
+	return true
+}
+
+// moveCursor applies a movement action count times to the focused list or
+// tree. It runs on the UI goroutine, so the cursor is set directly instead of
+// replaying keys. It returns false when the focused pane has no cursor.
+func (s *AppState) moveCursor(action string, count int) bool {
+	switch view := s.app.GetFocus().(type) {
+	case *tview.List:
+		if size := view.GetItemCount(); size > 0 {
+			view.SetCurrentItem(movedIndex(view.GetCurrentItem(), size, action, count))
+		}
+	case *tview.TreeView:
+		nodes := visibleTreeNodes(view.GetRoot())
+		if len(nodes) == 0 {
+			return true
+		}
+		current := 0
+		for i, node := range nodes {
+			if node == view.GetCurrentNode() {
+				current = i
+				break
+			}
+		}
+		view.SetCurrentNode(nodes[movedIndex(current, len(nodes), action, count)])
+	default:
+		return false
+	}
+	if s.isVisualSelection() {
+		s.refreshVisualSelection()
+	}
+	return true
+}
+
+// movedIndex is the row a movement action lands on in a pane of size rows. A
+// count on last_item jumps to that row, like 5G in vim.
+func movedIndex(current, size int, action string, count int) int {
+	target := current
+	switch action {
+	case actionMoveDown:
+		target += count
+	case actionMoveUp:
+		target -= count
+	case actionFirstItem:
+		target = 0
+	case actionLastItem:
+		target = size - 1
+		if count > 1 {
+			target = count - 1
+		}
+	}
+	if target >= size {
+		target = size - 1
+	}
+	if target < 0 {
+		target = 0
+	}
+	return target
+}
+
+// visibleTreeNodes lists the nodes of the tree under root that are shown, in
+// display order.
+func visibleTreeNodes(root *tview.TreeNode) []*tview.TreeNode {
+	nodes := []*tview.TreeNode{}
+	if root == nil {
+		return nodes
+	}
+	root.Walk(func(node, parent *tview.TreeNode) bool {
+		nodes = append(nodes, node)
+		return node.IsExpanded()
+	})
+	return nodes
+}
+
+func (s *AppState) currentChatMessageIndex() int {
+	chatView, ok := s.components[ViChat].(*tview.List)
+	if !ok {
+		return -1
+	}
+	row := chatView.GetCurrentItem()
+	s.chatMessagesMu.RLock()
+	defer s.chatMessagesMu.RUnlock()
+	if row < 0 {
+		return -1
+	}
+	if len(s.chatRowMap) == 0 {
+		if row < len(s.chatMessages) {
+			return row
+		}
+		return -1
+	}
+	if row >= len(s.chatRowMap) {
+		return -1
+	}
+	return s.chatRowMap[row]
+}
+
+func (s *AppState) isVisualSelection() bool {
+	s.modeMu.Lock()
+	defer s.modeMu.Unlock()
+	return s.visualAnchor >= 0
+}
+
+// toggleVisualSelection starts selecting messages from the chat cursor, or ends
+// an active selection.
+func (s *AppState) toggleVisualSelection() bool {
+	if s.isVisualSelection() {
+		s.exitVisualSelection()
+		return true
+	}
+	if s.app.GetFocus() != s.components[ViChat] || s.isSettingsMode() {
+		return false
+	}
+	idx := s.currentChatMessageIndex()
+	if idx < 0 {
+		return false
+	}
+	s.modeMu.Lock()
+	s.visualAnchor = idx
+	s.visualRows = map[int]string{}
+	s.modeMu.Unlock()
+	s.refreshVisualSelection()
+	s.updateScanStatusTitle()
+	return true
+}
+
+// exitVisualSelection clears the selection and restores the marked rows.
+func (s *AppState) exitVisualSelection() {
+	s.modeMu.Lock()
+	if s.visualAnchor < 0 {
+		s.modeMu.Unlock()
+		return
+	}
+	rows := s.visualRows
+	s.visualAnchor = -1
+	s.visualRows = nil
+	s.modeMu.Unlock()
+	if chatView, ok := s.components[ViChat].(*tview.List); ok {
+		for row, text := range rows {
+			if row < chatView.GetItemCount() {
+				_, secondary := chatView.GetItemText(row)
+				chatView.SetItemText(row, text, secondary)
+			}
+		}
+	}
+	s.updateScanStatusTitle()
+}
+
+// resetVisualSelection drops the selection without touching rows, for when the
+// chat list is rebuilt.
+func (s *AppState) resetVisualSelection() {
+	s.modeMu.Lock()
+	s.visualAnchor = -1
+	s.visualRows = nil
+	s.modeMu.Unlock()
+}
+
+func (s *AppState) visualRange() (int, int, bool) {
+	s.modeMu.Lock()
+	anchor := s.visualAnchor
+	s.modeMu.Unlock()
+	if anchor < 0 {
+		return 0, 0, false
+	}
+	current := s.currentChatMessageIndex()
+	if current < 0 {
+		current = anchor
+	}
+	if current < anchor {
+		return current, anchor, true
+	}
+	return anchor, current, true
+}
+
+// refreshVisualSelection marks every row belonging to a selected message.
+func (s *AppState) refreshVisualSelection() {
+	from, to, ok := s.visualRange()
+	chatView, isList := s.components[ViChat].(*tview.List)
+	if !ok || !isList {
+		return
+	}
+	s.chatMessagesMu.RLock()
+	rowMap := append([]int(nil), s.chatRowMap...)
+	s.chatMessagesMu.RUnlock()
+
+	s.modeMu.Lock()
+	defer s.modeMu.Unlock()
+	for row, text := range s.visualRows {
+		if row < chatView.GetItemCount() {
+			_, secondary := chatView.GetItemText(row)
+			chatView.SetItemText(row, text, secondary)
+		}
+	}
+	s.visualRows = map[int]string{}
+	for row := 0; row < chatView.GetItemCount(); row++ {
+		msgIdx := row
+		if len(rowMap) > 0 {
+			if row >= len(rowMap) {
+				break
+			}
+			msgIdx = rowMap[row]
+		}
+		if msgIdx < from || msgIdx > to {
+			continue
+		}
+		main, secondary := chatView.GetItemText(row)
+		s.visualRows[row] = main
+		chatView.SetItemText(row, visualRowMarker+main, secondary)
+	}
+}
+
+// selectedMessagesForAction returns the visual selection, or the message under
+// the chat cursor.
+func (s *AppState) selectedMessagesForAction() []csa.ChatMessage {
+	if from, to, ok := s.visualRange(); ok {
+		out := []csa.ChatMessage{}
+		for i, message := range s.currentChatMessages() {
+			if i >= from && i <= to && isUserVisibleMessage(message) {
+				out = append(out, message)
+			}
+		}
+		return out
+	}
+	msg, ok := s.selectedChatMessage()
+	if !ok || !isUserVisibleMessage(msg) {
+		return nil
+	}
+	return []csa.ChatMessage{msg}
+}
+
+// yankSelectedMessages copies the selected message text to the clipboard. Several
+// messages are prefixed with their author.
+func (s *AppState) yankSelectedMessages() {
+	messages := s.selectedMessagesForAction()
+	if len(messages) == 0 {
+		s.setComposeStatus("Select a message first")
+		return
+	}
+	parts := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		text := strings.TrimSpace(textMessage(msg.Content))
+		if len(messages) > 1 {
+			text = s.messageAuthor(msg) + ": " + text
+		}
+		parts = append(parts, text)
+	}
+	s.exitVisualSelection()
+	what := "message"
+	if len(messages) > 1 {
+		what = fmt.Sprintf("%d messages", len(messages))
+	}
+	s.copyWithStatus(strings.Join(parts, "\n\n"), what)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/rivo/tview"
+	"strings"
+)
+
+func formatMutedTreeTitle(title string, muted bool) string {
+	if !muted {
+		return title
+	}
+	return title + " 🔕"
+}
+
+func (s *AppState) isConversationMuted(key string) bool {
+	key = normalizeFavoriteKey(key)
+	if key == "" {
+		return false
+	}
+	s.mutedMu.RLock()
+	defer s.mutedMu.RUnlock()
+	return s.muted[key]
+}
+
+func (s *AppState) setConversationMuted(key string, muted bool) {
+	key = normalizeFavoriteKey(key)
+	if key == "" {
+		return
+	}
+	s.mutedMu.Lock()
+	if s.muted == nil {
+		s.muted = map[string]bool{}
+	}
+	if muted {
+		s.muted[key] = true
+	} else {
+		delete(s.muted, key)
+	}
+	s.mutedMu.Unlock()
+}
+
+// toggleMuteForNode flips the local mute flag of a chat or channel node. Muted
+// conversations keep their unread marker but are left out of group badges and
+// unread navigation. It returns the new state and false when node is not a
+// conversation.
+func (s *AppState) toggleMuteForNode(node *tview.TreeNode) (bool, bool) {
+	if node == nil {
+		return false, false
+	}
+	var muted bool
+	switch ref := node.GetReference().(type) {
+	case conversationRef:
+		if strings.TrimSpace(ref.chatKey) == "" || ref.chatKey == settingsHelpChatKey {
+			return false, false
+		}
+		ref.isMuted = !ref.isMuted
+		muted = ref.isMuted
+		s.setConversationMuted(ref.chatKey, muted)
+		node.SetText(ref.treeTitle())
+		node.SetReference(ref)
+	case channelRef:
+		ref.isMuted = !ref.isMuted
+		muted = ref.isMuted
+		s.setConversationMuted(ref.channel.Id, muted)
+		node.SetText(ref.treeTitle())
+		node.SetReference(ref)
+	default:
+		return false, false
+	}
+	s.syncConversationCopies(node)
+	if treeView, ok := s.components[TrChat].(*tview.TreeView); ok {
+		refreshTreeUnreadLabels(treeView.GetRoot())
+	}
+	s.persistEncryptedChatSettings()
+	return muted, true
+}
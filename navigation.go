@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/rivo/tview"
+)
+
+// expandTreePath expands every ancestor of target so it becomes visible in the
+// tree. It returns false when target is not part of the tree.
+func expandTreePath(root, target *tview.TreeNode) bool {
+	if root == nil || target == nil {
+		return false
+	}
+	if root == target {
+		return true
+	}
+	for _, child := range root.GetChildren() {
+		if expandTreePath(child, target) {
+			root.Expand()
+			return true
+		}
+	}
+	return false
+}
+
+// selectAndOpenTreeNode moves the tree cursor to node and opens it as if the
+// user had pressed Enter on it.
+func (s *AppState) selectAndOpenTreeNode(node *tview.TreeNode) {
+	if node == nil {
+		return
+	}
+	treeView, ok := s.components[TrChat].(*tview.TreeView)
+	if !ok {
+		return
+	}
+	s.revealTreeNode(node)
+	expandTreePath(treeView.GetRoot(), node)
+	treeView.SetCurrentNode(node)
+	s.handleTreeNodeSelected(node)
+}
+
+func isConversationNode(node *tview.TreeNode) bool {
+	if node == nil {
+		return false
+	}
+	switch node.GetReference().(type) {
+	case conversationRef, channelRef:
+		return true
+	}
+	return false
+}
+
+// treeNodeUnreadState reports whether node should be visited by unread
+// navigation. Muted conversations are skipped.
+func treeNodeUnreadState(node *tview.TreeNode) (bool, int) {
+	switch ref := node.GetReference().(type) {
+	case conversationRef:
+		return ref.isUnread && !ref.isMuted, ref.mentionCount
+	case channelRef:
+		return ref.isUnread && !ref.isMuted, ref.mentionCount
+	}
+	return false, 0
+}
+
+// jumpToUnread opens the next (or previous) unread conversation in tree order,
+// relative to the current tree selection. Conversations that mention the user
+// take precedence over plain unread ones.
+func (s *AppState) jumpToUnread(backwards bool) bool {
+	treeView, ok := s.components[TrChat].(*tview.TreeView)
+	if !ok {
+		return false
+	}
+	nodes := uniqueConversationNodes(flattenConversationNodes(treeView.GetRoot()))
+	current := treeView.GetCurrentNode()
+	if !isConversationNode(current) {
+		_, _, current = s.getActiveConversation()
+	}
+	currentKey := conversationNodeKey(current)
+
+	currentIdx := -1
+	unread := []int{}
+	mentioned := []int{}
+	for i, node := range nodes {
+		if node == current || (currentKey != "" && conversationNodeKey(node) == currentKey) {
+			currentIdx = i
+		}
+		isUnread, mentions := treeNodeUnreadState(node)
+		if !isUnread {
+			continue
+		}
+		unread = append(unread, i)
+		if mentions > 0 {
+			mentioned = append(mentioned, i)
+		}
+	}
+	candidates := mentioned
+	if len(candidates) == 0 {
+		candidates = unread
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+
+	target := -1
+	if backwards {
+		for i := len(candidates) - 1; i >= 0; i-- {
+			if candidates[i] < currentIdx {
+				target = candidates[i]
+				break
+			}
+		}
+		if target < 0 {
+			target = candidates[len(candidates)-1]
+		}
+	} else {
+		for _, idx := range candidates {
+			if idx > currentIdx {
+				target = idx
+				break
+			}
+		}
+		if target < 0 {
+			target = candidates[0]
+		}
+	}
+	s.selectAndOpenTreeNode(nodes[target])
+	return true
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	api "github.com/fossteams/teams-api/pkg"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/fossteams/teams-api/pkg/mt"
+	"net/url"
+	"strings"
+)
+
+const (
+	pagePeople       = "pagePeople"
+	pagePersonEmail  = "pagePersonEmail"
+	pageNewChatTopic = "pageNewChatTopic"
+
+	// Picker entries besides people.
+	peoplePickConfirm = "confirm"
+	peoplePickEmail   = "email"
+)
+
+func candidateMri(c mentionCandidate) string {
+	if mri := strings.TrimSpace(c.Mri); mri != "" {
+		return mri
+	}
+	if oid := strings.TrimSpace(c.ObjectID); oid != "" {
+		return "8:orgid:" + oid
+	}
+	return ""
+}
+
+// lookupUserByEmail finds a user in the directory by email address.
+func lookupUserByEmail(email string) (mentionCandidate, error) {
+	token, err := api.GetSkypeSpacesToken()
+	if err != nil {
+		return mentionCandidate{}, err
+	}
+	svc, err := mt.NewMiddleTierService(api.Emea, token)
+	if err != nil {
+		return mentionCandidate{}, err
+	}
+	user, err := svc.GetUser(strings.TrimSpace(email))
+	if err != nil {
+		return mentionCandidate{}, fmt.Errorf("unable to look up %s: %v", email, err)
+	}
+	candidate := mentionCandidate{
+		DisplayName: strings.TrimSpace(user.DisplayName),
+		Mri:         strings.TrimSpace(user.Mri),
+		ObjectID:    strings.TrimSpace(user.ObjectId),
+	}
+	if candidateMri(candidate) == "" {
+		return mentionCandidate{}, fmt.Errorf("no user found for %s", email)
+	}
+	if candidate.DisplayName == "" {
+		candidate.DisplayName = email
+	}
+	return candidate, nil
+}
+
+// resolvePerson turns a command line argument into a person: an MRI, an email
+// address, or a name matching exactly one contact or chat member.
+func (s *AppState) resolvePerson(arg string) (mentionCandidate, error) {
+	arg = strings.TrimSpace(arg)
+	switch {
+	case arg == "":
+		return mentionCandidate{}, fmt.Errorf("empty person")
+	case strings.HasPrefix(arg, "8:"):
+		return mentionCandidate{DisplayName: arg, Mri: arg}, nil
+	case strings.Contains(arg, "@"):
+		return lookupUserByEmail(arg)
+	}
+	for _, candidate := range s.mentionCandidatesGlobal() {
+		if strings.EqualFold(candidate.DisplayName, arg) && candidateMri(candidate) != "" {
+			return candidate, nil
+		}
+	}
+	matches := []mentionCandidate{}
+	for _, candidate := range s.findMentionSuggestions("c@", arg, nil) {
+		if candidateMri(candidate) != "" {
+			matches = append(matches, candidate)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return mentionCandidate{}, fmt.Errorf("nobody matches %q", arg)
+	case 1:
+		return matches[0], nil
+	}
+	names := []string{}
+	for i, match := range matches {
+		if i == 5 {
+			names = append(names, "...")
+			break
+		}
+		names = append(names, match.DisplayName)
+	}
+	return mentionCandidate{}, fmt.Errorf("%q matches several people: %s", arg, strings.Join(names, ", "))
+}
+
+// newChatRecord is the chat as it is added to the tree before the next refresh
+// brings the server's copy.
+func (s *AppState) newChatRecord(id string, people []mentionCandidate, topic string) csa.Chat {
+	chat := csa.Chat{
+		Id:         id,
+		IsOneOnOne: len(people) == 1 && strings.TrimSpace(topic) == "",
+		Title:      strings.TrimSpace(topic),
+	}
+	if s.me != nil {
+		chat.Members = append(chat.Members, csa.ChatMember{
+			Mri:          s.selfMri(),
+			ObjectId:     s.me.ObjectId,
+			FriendlyName: s.me.DisplayName,
+			Role:         csa.ChatMemberAdmin,
+		})
+	}
+	for _, person := range people {
+		chat.Members = append(chat.Members, csa.ChatMember{
+			Mri:          candidateMri(person),
+			ObjectId:     person.ObjectID,
+			FriendlyName: person.DisplayName,
+			Role:         csa.ChatMemberAdmin,
+		})
+	}
+	return chat
+}
+
+// startChat opens a chat with people, creating it on the server unless it is a
+// one on one chat that already exists. The new chat goes to the top of Recent
+// and compose is focused on it.
+func (s *AppState) startChat(people []mentionCandidate, topic string) {
+	if len(people) == 0 {
+		return
+	}
+	if len(people) == 1 && strings.TrimSpace(topic) == "" {
+		if node := s.findOneOnOneChatNode(candidateMri(people[0])); node != nil {
+			s.openQuickOpenNode(node)
+			s.app.SetFocus(s.components[ViCompose])
+			return
+		}
+	}
+	mris := make([]string, 0, len(people))
+	for _, person := range people {
+		mris = append(mris, candidateMri(person))
+	}
+	s.setComposeStatus("Creating chat")
+	go func() {
+		id, err := s.createChatThread(mris, topic)
+		if err != nil {
+			s.logger.WithError(err).WithField("members", strings.Join(mris, ",")).Warn("unable to create chat")
+			s.app.QueueUpdateDraw(func() {
+				s.setComposeStatus("Create chat failed")
+			})
+			return
+		}
+		s.app.QueueUpdateDraw(func() {
+			node := s.addChatNode(s.newChatRecord(id, people, topic))
+			s.openQuickOpenNode(node)
+			s.app.SetFocus(s.components[ViCompose])
+			s.setComposeStatus("")
+		})
+	}()
+}
+
+// peoplePicker describes a people search where every pick adds a person and
+// reopens the search until the confirm entry is chosen.
+type peoplePicker struct {
+	title      string
+	confirm    string
+	candidates []mentionCandidate
+	// exclude holds lower-cased MRIs that are not offered, such as current
+	// members.
+	exclude map[string]bool
+	onDone  func(people []mentionCandidate)
+}
+
+// loadPeopleCandidates fetches contacts and chat members off the UI goroutine
+// and opens picker with them.
+func (s *AppState) loadPeopleCandidates(picker peoplePicker) {
+	s.setComposeStatus("Loading people")
+	go func() {
+		candidates := []mentionCandidate{}
+		for _, candidate := range s.mentionCandidatesGlobal() {
+			mri := candidateMri(candidate)
+			if mri != "" && !picker.exclude[strings.ToLower(mri)] {
+				candidates = append(candidates, candidate)
+			}
+		}
+		s.app.QueueUpdateDraw(func() {
+			s.setComposeStatus("")
+			picker.candidates = candidates
+			s.pickPeople(picker, nil)
+		})
+	}()
+}
+
+func (s *AppState) pickPeople(picker peoplePicker, selected []mentionCandidate) {
+	chosen := map[string]bool{}
+	names := []string{}
+	for _, person := range selected {
+		chosen[strings.ToLower(candidateMri(person))] = true
+		names = append(names, person.DisplayName)
+	}
+	items := []pickerItem{}
+	if len(selected) > 0 {
+		items = append(items, pickerItem{
+			label:     picker.confirm,
+			secondary: strings.Join(names, ", "),
+			value:     peoplePickConfirm,
+		})
+	}
+	items = append(items, pickerItem{label: "Add by email…", value: peoplePickEmail})
+	for _, candidate := range picker.candidates {
+		if chosen[strings.ToLower(candidateMri(candidate))] {
+			continue
+		}
+		items = append(items, pickerItem{label: candidate.DisplayName, value: candidate})
+	}
+	title := picker.title
+	if len(selected) > 0 {
+		title = fmt.Sprintf("%s (%d selected)", picker.title, len(selected))
+	}
+	s.showPicker(pagePeople, title, items, func(item pickerItem) {
+		switch value := item.value.(type) {
+		case mentionCandidate:
+			s.pickPeople(picker, append(append([]mentionCandidate(nil), selected...), value))
+		case string:
+			if value == peoplePickEmail {
+				s.promptPersonEmail(picker, selected)
+				return
+			}
+			picker.onDone(selected)
+		}
+	})
+}
+
+func (s *AppState) promptPersonEmail(picker peoplePicker, selected []mentionCandidate) {
+	s.promptText(pagePersonEmail, "Add by email", "Email: ", "", func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			s.pickPeople(picker, selected)
+			return
+		}
+		s.setComposeStatus("Looking up " + strings.TrimSpace(text))
+		go func() {
+			person, err := lookupUserByEmail(text)
+			s.app.QueueUpdateDraw(func() {
+				switch {
+				case err != nil:
+					s.setComposeStatus(err.Error())
+				case picker.exclude[strings.ToLower(candidateMri(person))]:
+					s.setComposeStatus(person.DisplayName + " is already a member")
+				default:
+					s.setComposeStatus("")
+					selected = append(append([]mentionCandidate(nil), selected...), person)
+				}
+				s.pickPeople(picker, selected)
+			})
+		}()
+	})
+}
+
+// showNewChat searches people for a new chat. One person gives a 1:1 chat;
+// group chats ask for an optional topic first.
+func (s *AppState) showNewChat() {
+	s.loadPeopleCandidates(peoplePicker{
+		title:   "New chat",
+		confirm: "Create chat",
+		onDone: func(people []mentionCandidate) {
+			if len(people) == 1 {
+				s.startChat(people, "")
+				return
+			}
+			s.promptText(pageNewChatTopic, "Group chat topic (optional)", "Topic: ", "", func(text string, ok bool) {
+				if ok {
+					s.startChat(people, text)
+				}
+			})
+		},
+	})
+}
+
+// runNewChatCommand creates a chat without starting the UI and prints its id
+// and link.
+func (s *AppState) runNewChatCommand(opts newChatOptions) error {
+	if err := s.connectHeadless(); err != nil {
+		return err
+	}
+	people := make([]mentionCandidate, 0, len(opts.people))
+	for _, arg := range opts.people {
+		person, err := s.resolvePerson(arg)
+		if err != nil {
+			return err
+		}
+		people = append(people, person)
+	}
+	if len(people) == 1 && strings.TrimSpace(opts.topic) == "" {
+		for _, chat := range s.conversationsSnapshot().Chats {
+			if chat.IsOneOnOne && strings.EqualFold(oneOnOnePartnerMri(chat, s.me), candidateMri(people[0])) {
+				fmt.Printf("%s\n%s\n", chat.Id, chatDeepLink(chat.Id))
+				return nil
+			}
+		}
+	}
+	mris := make([]string, 0, len(people))
+	for _, person := range people {
+		mris = append(mris, candidateMri(person))
+	}
+	id, err := s.createChatThread(mris, opts.topic)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n%s\n", id, chatDeepLink(id))
+	return nil
+}
+
+func chatDeepLink(id string) string {
+	return "https://teams.microsoft.com/l/chat/" + url.PathEscape(id) + "/0"
+}
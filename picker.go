@@ -0,0 +1,249 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const pickerMaxResults = 50
+
+// pickerItem is one row of a fuzzy picker popup. Items are expected in their
+// preferred order when the query is empty; keywords are matched in addition to
+// the label but never displayed.
+type pickerItem struct {
+	label     string
+	secondary string
+	keywords  []string
+	value     interface{}
+}
+
+// fuzzyScore scores text against a subsequence query. Consecutive matches,
+// matches at word starts and plain substring hits score higher. It returns -1
+// when the query does not match.
+func fuzzyScore(query, text string) int {
+	q := []rune(strings.ToLower(strings.Join(strings.Fields(query), "")))
+	if len(q) == 0 {
+		return 0
+	}
+	t := []rune(strings.ToLower(text))
+	score := 0
+	qi := 0
+	prev := -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if ti == prev+1 {
+			score += 5
+		}
+		if ti == 0 || (!unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1])) {
+			score += 8
+		}
+		prev = ti
+		qi++
+	}
+	if qi < len(q) {
+		return -1
+	}
+	if strings.Contains(string(t), string(q)) {
+		score += 20
+	}
+	return score
+}
+
+func (p pickerItem) score(query string) int {
+	best := fuzzyScore(query, p.label)
+	for _, keyword := range p.keywords {
+		// Keyword hits rank below equivalent label hits.
+		if sc := fuzzyScore(query, keyword); sc >= 0 && sc-5 > best {
+			best = sc - 5
+		}
+	}
+	return best
+}
+
+func filterPickerItems(items []pickerItem, query string) []pickerItem {
+	if strings.TrimSpace(query) == "" {
+		if len(items) > pickerMaxResults {
+			return items[:pickerMaxResults]
+		}
+		return items
+	}
+	type scored struct {
+		item  pickerItem
+		score int
+		order int
+	}
+	matches := []scored{}
+	for i, item := range items {
+		if sc := item.score(query); sc >= 0 {
+			matches = append(matches, scored{item: item, score: sc, order: i})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].order < matches[j].order
+	})
+	out := make([]pickerItem, 0, len(matches))
+	for i, m := range matches {
+		if i >= pickerMaxResults {
+			break
+		}
+		out = append(out, m.item)
+	}
+	return out
+}
+
+// showPicker opens a modal fuzzy finder over items. onSelect runs on the UI
+// goroutine after the popup has been closed and focus has returned to the pane
+// that was focused before.
+func (s *AppState) showPicker(pageName, title string, items []pickerItem, onSelect func(item pickerItem)) {
+	previous := s.app.GetFocus()
+	input := tview.NewInputField().
+		SetLabel("> ").
+		SetFieldWidth(0)
+	input.SetFieldBackgroundColor(s.composeFieldColor())
+	list := tview.NewList().
+		ShowSecondaryText(false).
+		SetHighlightFullLine(true)
+
+	visible := items
+	render := func(query string) {
+		visible = filterPickerItems(items, query)
+		list.Clear()
+		for _, item := range visible {
+			text := tview.Escape(item.label)
+			if strings.TrimSpace(item.secondary) != "" {
+				text += " [gray]" + tview.Escape(item.secondary) + "[-]"
+			}
+			list.AddItem(text, "", 0, nil)
+		}
+	}
+	closePicker := func() {
+		s.pages.RemovePage(pageName)
+		s.pages.SwitchToPage(PageMain)
+		if previous != nil {
+			s.app.SetFocus(previous)
+		} else if tree, ok := s.components[TrChat]; ok {
+			s.app.SetFocus(tree)
+		}
+	}
+	choose := func() {
+		idx := list.GetCurrentItem()
+		closePicker()
+		if idx < 0 || idx >= len(visible) {
+			return
+		}
+		if onSelect != nil {
+			onSelect(visible[idx])
+		}
+	}
+
+	input.SetChangedFunc(func(text string) {
+		render(text)
+	})
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case s.bindingMatches(scopePopup, actionPopupNext, event):
+			if count := list.GetItemCount(); count > 0 {
+				list.SetCurrentItem((list.GetCurrentItem() + 1) % count)
+			}
+			return nil
+		case s.bindingMatches(scopePopup, actionPopupPrev, event):
+			if count := list.GetItemCount(); count > 0 {
+				list.SetCurrentItem((list.GetCurrentItem() - 1 + count) % count)
+			}
+			return nil
+		}
+		return event
+	})
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			choose()
+		case tcell.KeyEscape:
+			closePicker()
+		}
+	})
+	render("")
+
+	body := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(input, 1, 0, true).
+		AddItem(list, 0, 1, false)
+	body.SetBorder(true).SetTitle(title).SetTitleAlign(tview.AlignCenter)
+
+	modal := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(body, 20, 1, true).
+			AddItem(nil, 0, 1, false), 80, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	s.pages.AddPage(pageName, modal, true, true)
+	s.app.SetFocus(input)
+}
+
+// promptText opens a single-line input popup. Focus returns to the previously
+// focused pane before onDone runs; ok is false when the prompt was canceled.
+func (s *AppState) promptText(pageName, title, label, initial string, onDone func(text string, ok bool)) {
+	previous := s.app.GetFocus()
+	input := tview.NewInputField().
+		SetLabel(label).
+		SetText(initial).
+		SetFieldWidth(0)
+	input.SetFieldBackgroundColor(s.composeFieldColor())
+	input.SetBorder(true).SetTitle(title).SetTitleAlign(tview.AlignCenter)
+
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key != tcell.KeyEnter && key != tcell.KeyEscape {
+			return
+		}
+		s.pages.RemovePage(pageName)
+		s.pages.SwitchToPage(PageMain)
+		if previous != nil {
+			s.app.SetFocus(previous)
+		}
+		if onDone != nil {
+			onDone(input.GetText(), key == tcell.KeyEnter)
+		}
+	})
+
+	modal := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(input, 3, 1, true).
+			AddItem(nil, 0, 1, false), 70, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	s.pages.AddPage(pageName, modal, true, true)
+	s.app.SetFocus(input)
+}
+
+// confirmAction asks a yes/no question before running a destructive action.
+// onConfirm runs on the UI goroutine after focus has been restored.
+func (s *AppState) confirmAction(pageName, text string, onConfirm func()) {
+	previous := s.app.GetFocus()
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Cancel", "Confirm"}).
+		SetDoneFunc(func(_ int, label string) {
+			s.pages.RemovePage(pageName)
+			s.pages.SwitchToPage(PageMain)
+			if previous != nil {
+				s.app.SetFocus(previous)
+			}
+			if label == "Confirm" && onConfirm != nil {
+				onConfirm()
+			}
+		})
+	s.pages.AddPage(pageName, modal, true, true)
+	s.app.SetFocus(modal)
+}
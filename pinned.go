@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+const pinnedChannelsEndpoint = csa.ChatSvcAgg + "v1/teams/users/me/pinnedChannels"
+
+// pinnedChannelOrder is the server's list of pinned channels. orderVersion has
+// to be sent back with changes.
+type pinnedChannelOrder struct {
+	OrderVersion    int             `json:"orderVersion"`
+	PinChannelOrder []csa.ChannelId `json:"pinChannelOrder"`
+}
+
+// conversationNodeKey identifies the conversation behind a tree node. The same
+// channel can be listed under Pinned and under its team.
+func conversationNodeKey(node *tview.TreeNode) string {
+	if node == nil {
+		return ""
+	}
+	switch ref := node.GetReference().(type) {
+	case conversationRef:
+		return normalizeFavoriteKey(ref.chatKey)
+	case channelRef:
+		return normalizeFavoriteKey(ref.channel.Id)
+	}
+	return ""
+}
+
+// uniqueConversationNodes keeps the first node of every conversation.
+func uniqueConversationNodes(nodes []*tview.TreeNode) []*tview.TreeNode {
+	seen := map[string]bool{}
+	out := make([]*tview.TreeNode, 0, len(nodes))
+	for _, node := range nodes {
+		key := conversationNodeKey(node)
+		if key != "" && seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, node)
+	}
+	return out
+}
+
+func (s *AppState) isChannelPinned(channelID string) bool {
+	for _, id := range s.pinnedChannels {
+		if strings.EqualFold(string(id), channelID) {
+			return true
+		}
+	}
+	return false
+}
+
+// teamChannelNode returns the node of a channel under its team, which may be
+// hidden.
+func (s *AppState) teamChannelNode(channelID string) *tview.TreeNode {
+	for _, teamNode := range s.tree.teamNodes {
+		for _, node := range s.tree.channelNodes[teamNode] {
+			if ref, ok := node.GetReference().(channelRef); ok && ref.channel.Id == channelID {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+// channelShortcutRef builds the reference of a channel listed outside its team,
+// under Pinned or Favorites. Unread state is copied from the channel's node
+// under its team.
+func (s *AppState) channelShortcutRef(channelID string) (channelRef, bool) {
+	channel, ok := s.channelById[channelID]
+	if !ok || channel.Channel == nil {
+		return channelRef{}, false
+	}
+	ref := channelRef{
+		channel: *channel.Channel,
+		isMuted: s.isConversationMuted(channel.Id),
+	}
+	if channel.parent != nil {
+		ref.teamName = channel.parent.DisplayName
+	}
+	if teamNode := s.teamChannelNode(channel.Id); teamNode != nil {
+		if teamRef, ok := teamNode.GetReference().(channelRef); ok {
+			ref.isUnread = teamRef.isUnread
+			ref.unreadCount = teamRef.unreadCount
+			ref.mentionCount = teamRef.mentionCount
+		}
+	}
+	return ref, true
+}
+
+// syncConversationCopies copies the state of node to the other nodes of the
+// same conversation, such as a pinned channel and its team entry or a chat and
+// its copy in a user folder.
+func (s *AppState) syncConversationCopies(node *tview.TreeNode) {
+	key := conversationNodeKey(node)
+	if key == "" || s.tree.root == nil {
+		return
+	}
+	s.tree.root.Walk(func(other, parent *tview.TreeNode) bool {
+		if other == node || conversationNodeKey(other) != key {
+			return true
+		}
+		switch source := node.GetReference().(type) {
+		case channelRef:
+			ref, ok := other.GetReference().(channelRef)
+			if !ok {
+				return true
+			}
+			ref.isUnread = source.isUnread
+			ref.isMuted = source.isMuted
+			ref.unreadCount = source.unreadCount
+			ref.mentionCount = source.mentionCount
+			other.SetText(ref.treeTitle())
+			other.SetReference(ref)
+		case conversationRef:
+			ref, ok := other.GetReference().(conversationRef)
+			if !ok {
+				return true
+			}
+			ref.title = source.title
+			ref.isFavorite = source.isFavorite
+			ref.isUnread = source.isUnread
+			ref.isMuted = source.isMuted
+			ref.unreadCount = source.unreadCount
+			ref.mentionCount = source.mentionCount
+			other.SetText(ref.treeTitle())
+			other.SetReference(ref)
+		}
+		return true
+	})
+}
+
+// renderPinnedChannels rebuilds the Pinned group from pinnedChannels in server
+// order and shows it at the top of the tree while it has channels.
+func (s *AppState) renderPinnedChannels() {
+	if s.tree.root == nil {
+		return
+	}
+	if s.tree.pinned == nil {
+		s.tree.pinned = tview.NewTreeNode("Pinned")
+		s.tree.pinned.SetReference(groupRef{name: "Pinned"})
+		s.tree.pinned.SetColor(tcell.ColorYellow)
+	}
+	treeView, _ := s.components[TrChat].(*tview.TreeView)
+	// A selected pinned channel stays selected, or moves to its team once
+	// unpinned.
+	selectedID := ""
+	if treeView != nil && treeView.GetCurrentNode() != nil {
+		for _, child := range s.tree.pinned.GetChildren() {
+			if child == treeView.GetCurrentNode() {
+				selectedID = child.GetReference().(channelRef).channel.Id
+			}
+		}
+	}
+	var selected *tview.TreeNode
+
+	s.tree.pinned.ClearChildren()
+	for _, id := range s.pinnedChannels {
+		ref, ok := s.channelShortcutRef(string(id))
+		if !ok {
+			continue
+		}
+		ref.isPinned = true
+		node := tview.NewTreeNode(ref.treeTitle())
+		node.SetReference(ref)
+		node.SetColor(tcell.ColorGreen)
+		s.tree.pinned.AddChild(node)
+		if ref.channel.Id == selectedID {
+			selected = node
+		}
+	}
+
+	s.arrangeRoot()
+	refreshTreeUnreadLabels(s.tree.root)
+	s.refreshTreeView()
+
+	s.activeConversationMu.Lock()
+	if s.activeConversationNode != nil {
+		if active, ok := s.activeConversationNode.GetReference().(channelRef); ok && active.isPinned {
+			s.activeConversationNode = s.findConversationNode(active.channel.Id)
+		}
+	}
+	s.activeConversationMu.Unlock()
+
+	if selectedID == "" {
+		return
+	}
+	if selected == nil {
+		selected = s.teamChannelNode(selectedID)
+	}
+	if selected != nil {
+		treeView.SetCurrentNode(selected)
+	}
+}
+
+func (s *AppState) fetchPinnedChannelOrder() (pinnedChannelOrder, error) {
+	body, _, err := s.chatServiceRoundTrip(http.MethodGet, pinnedChannelsEndpoint, nil)
+	if err != nil {
+		return pinnedChannelOrder{}, err
+	}
+	var order pinnedChannelOrder
+	if err := json.Unmarshal(body, &order); err != nil {
+		return pinnedChannelOrder{}, fmt.Errorf("unable to decode pinned channels: %v", err)
+	}
+	return order, nil
+}
+
+// setChannelPinnedOnServer adds or removes a channel from the pinned list,
+// starting from the server's current order so pins made elsewhere are kept.
+func (s *AppState) setChannelPinnedOnServer(channelID string, pinned bool) ([]csa.ChannelId, error) {
+	order, err := s.fetchPinnedChannelOrder()
+	if err != nil {
+		return nil, err
+	}
+	next := []csa.ChannelId{}
+	for _, id := range order.PinChannelOrder {
+		if !strings.EqualFold(string(id), channelID) {
+			next = append(next, id)
+		}
+	}
+	if pinned {
+		next = append(next, csa.ChannelId(channelID))
+	}
+	body, err := json.Marshal(pinnedChannelOrder{
+		OrderVersion:    order.OrderVersion,
+		PinChannelOrder: next,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode pinned channels: %v", err)
+	}
+	if err := s.chatServiceRequest(http.MethodPut, pinnedChannelsEndpoint, body); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// togglePinForNode pins or unpins the channel behind node.
+func (s *AppState) togglePinForNode(node *tview.TreeNode) error {
+	if node == nil {
+		return fmt.Errorf("select a channel first")
+	}
+	ref, ok := node.GetReference().(channelRef)
+	if !ok {
+		return fmt.Errorf("only channels can be pinned")
+	}
+	channelID := ref.channel.Id
+	pin := !s.isChannelPinned(channelID)
+	if pin {
+		s.setComposeStatus("Pinning " + ref.channel.DisplayName)
+	} else {
+		s.setComposeStatus("Unpinning " + ref.channel.DisplayName)
+	}
+	go func() {
+		order, err := s.setChannelPinnedOnServer(channelID, pin)
+		if err != nil {
+			s.logger.WithError(err).WithField("channel_id", channelID).Warn("unable to update pinned channels")
+			s.app.QueueUpdateDraw(func() {
+				s.setComposeStatus("Pin update failed")
+			})
+			return
+		}
+		s.logger.WithFields(logrus.Fields{
+			"channel_id": channelID,
+			"pinned":     pin,
+		}).Info("pinned channels updated")
+		s.app.QueueUpdateDraw(func() {
+			s.pinnedChannels = order
+			s.renderPinnedChannels()
+			if pin {
+				s.setComposeStatus("Pinned " + ref.channel.DisplayName)
+			} else {
+				s.setComposeStatus("Unpinned " + ref.channel.DisplayName)
+			}
+		})
+	}()
+	return nil
+}
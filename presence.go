@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/fossteams/teams-api/pkg/models"
+	"github.com/rivo/tview"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	presenceHost = "https://presence.teams.microsoft.com/"
+
+	pageSetStatus     = "pageSetStatus"
+	pageStatusMessage = "pageStatusMessage"
+
+	// presenceBatchSize bounds the users asked about per presence request.
+	presenceBatchSize = 100
+
+	// statusMessageExpiry keeps a status message until it is cleared.
+	statusMessageExpiry = "9999-12-31T00:00:00.000Z"
+
+	// Status picker entries besides the availabilities.
+	statusPickMessage = "status_message"
+	statusPickClear   = "clear_status_message"
+)
+
+type availabilityOption struct {
+	value string
+	alias string
+}
+
+// ownAvailabilities are the statuses that can be set, in picker order. The
+// alias is accepted by /status.
+var ownAvailabilities = []availabilityOption{
+	{value: "Available", alias: "available"},
+	{value: "Busy", alias: "busy"},
+	{value: "DoNotDisturb", alias: "dnd"},
+	{value: "BeRightBack", alias: "brb"},
+	{value: "Away", alias: "away"},
+	{value: "Offline", alias: "offline"},
+}
+
+func parseAvailability(text string) (string, bool) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), ""))
+	for _, option := range ownAvailabilities {
+		if text == option.alias || text == strings.ToLower(option.value) {
+			return option.value, true
+		}
+	}
+	return "", false
+}
+
+// presenceInfo is the last known presence of a user.
+type presenceInfo struct {
+	availability string
+	activity     string
+}
+
+// label is the availability as Teams shows it, e.g. "Be right back".
+func (p presenceInfo) label() string {
+	switch strings.ToLower(p.availability) {
+	case "":
+		return ""
+	case "available":
+		return "Available"
+	case "availableidle":
+		return "Available (idle)"
+	case "away":
+		return "Away"
+	case "berightback":
+		return "Be right back"
+	case "busy":
+		return "Busy"
+	case "busyidle":
+		return "Busy (idle)"
+	case "donotdisturb":
+		return "Do not disturb"
+	case "offline", "presenceunknown":
+		return "Offline"
+	}
+	return p.availability
+}
+
+// presenceDot is a colored dot for availability, followed by a space, or ""
+// when the presence is unknown.
+func presenceDot(availability string) string {
+	color := ""
+	switch strings.ToLower(availability) {
+	case "available", "availableidle":
+		color = "green"
+	case "busy", "busyidle", "donotdisturb":
+		color = "red"
+	case "away", "berightback":
+		color = "yellow"
+	case "offline", "presenceunknown":
+		color = "gray"
+	default:
+		return ""
+	}
+	return "[" + color + "]•[-] "
+}
+
+// oneOnOnePartnerMri is the other member of a one on one chat.
+func oneOnOnePartnerMri(chat csa.Chat, me *models.User) string {
+	if !chat.IsOneOnOne {
+		return ""
+	}
+	for _, member := range chat.Members {
+		if !isCurrentUser(member, me) && strings.TrimSpace(member.Mri) != "" {
+			return strings.TrimSpace(member.Mri)
+		}
+	}
+	return ""
+}
+
+// presenceRequestBody sends a request to the presence service, which takes the
+// Skype Spaces token.
+func (s *AppState) presenceRequestBody(method, endpoint string, body []byte) ([]byte, error) {
+	respBody, _, err := s.authenticatedRoundTrip(method, endpoint, body)
+	return respBody, err
+}
+
+// fetchPresence asks the presence service about mris and caches the answers.
+func (s *AppState) fetchPresence(mris []string) error {
+	request := []map[string]string{}
+	seen := map[string]struct{}{}
+	for _, mri := range mris {
+		mri = strings.TrimSpace(mri)
+		if _, ok := seen[strings.ToLower(mri)]; ok || mri == "" {
+			continue
+		}
+		seen[strings.ToLower(mri)] = struct{}{}
+		request = append(request, map[string]string{"mri": mri})
+	}
+	for start := 0; start < len(request); start += presenceBatchSize {
+		end := start + presenceBatchSize
+		if end > len(request) {
+			end = len(request)
+		}
+		if err := s.fetchPresenceBatch(request[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AppState) fetchPresenceBatch(request []map[string]string) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("unable to encode presence request: %v", err)
+	}
+	respBody, err := s.presenceRequestBody(http.MethodPost, presenceHost+"v1/presence/getpresence/", body)
+	if err != nil {
+		return err
+	}
+	var response []struct {
+		Mri      string `json:"mri"`
+		Presence struct {
+			Availability string `json:"availability"`
+			Activity     string `json:"activity"`
+		} `json:"presence"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return fmt.Errorf("invalid presence response: %v", err)
+	}
+	s.presenceMu.Lock()
+	defer s.presenceMu.Unlock()
+	if s.presence == nil {
+		s.presence = map[string]presenceInfo{}
+	}
+	for _, entry := range response {
+		s.presence[strings.ToLower(strings.TrimSpace(entry.Mri))] = presenceInfo{
+			availability: strings.TrimSpace(entry.Presence.Availability),
+			activity:     strings.TrimSpace(entry.Presence.Activity),
+		}
+	}
+	return nil
+}
+
+func (s *AppState) presenceOf(mri string) presenceInfo {
+	s.presenceMu.RLock()
+	defer s.presenceMu.RUnlock()
+	return s.presence[strings.ToLower(strings.TrimSpace(mri))]
+}
+
+// presenceTargets lists the users whose presence is shown: one on one chat
+// partners, the member pane and the signed in user.
+func (s *AppState) presenceTargets() []string {
+	mris := []string{s.selfMri()}
+	if conversations := s.conversationsSnapshot(); conversations != nil {
+		for _, chat := range conversations.Chats {
+			if mri := oneOnOnePartnerMri(chat, s.me); mri != "" {
+				mris = append(mris, mri)
+			}
+		}
+	}
+	s.membersMu.RLock()
+	if s.membersVisible {
+		for _, member := range s.membersList {
+			mris = append(mris, member.mri)
+		}
+	}
+	s.membersMu.RUnlock()
+	return mris
+}
+
+// startPresenceLoop refreshes presence in the background every
+// presenceInterval, like the unread scan.
+func (s *AppState) startPresenceLoop() {
+	interval := s.presenceInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		s.refreshPresence()
+		for {
+			select {
+			case <-ticker.C:
+				s.refreshPresence()
+			case <-s.presenceStop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *AppState) refreshPresence() {
+	if err := s.fetchPresence(s.presenceTargets()); err != nil {
+		s.logger.WithError(err).Debug("unable to refresh presence")
+		return
+	}
+	s.app.QueueUpdateDraw(s.applyPresence)
+}
+
+// applyPresence redraws presence dots in the tree and the member pane from the
+// cache. It must run on the UI goroutine.
+func (s *AppState) applyPresence() {
+	if s.tree.root != nil {
+		s.tree.root.Walk(func(node, parent *tview.TreeNode) bool {
+			ref, ok := node.GetReference().(conversationRef)
+			if !ok || ref.presenceMri == "" {
+				return true
+			}
+			availability := s.presenceOf(ref.presenceMri).availability
+			if availability != ref.presence {
+				ref.presence = availability
+				node.SetText(ref.treeTitle())
+				node.SetReference(ref)
+			}
+			return true
+		})
+	}
+	if membersView, ok := s.components[ViMembers].(*tview.List); ok && s.isMembersPaneVisible() {
+		s.membersMu.RLock()
+		source := s.membersSource
+		members := s.membersList
+		s.membersMu.RUnlock()
+		if len(members) > 0 {
+			s.renderMembersFor(membersView, source, members)
+		}
+	}
+}
+
+// setOwnAvailability forces the signed in user's status, as picked in Teams.
+func (s *AppState) setOwnAvailability(availability string) error {
+	body, err := json.Marshal(map[string]string{"availability": availability})
+	if err != nil {
+		return fmt.Errorf("unable to encode status: %v", err)
+	}
+	if _, err := s.presenceRequestBody(http.MethodPut, presenceHost+"v1/me/forceavailability/", body); err != nil {
+		return err
+	}
+	s.presenceMu.Lock()
+	if s.presence == nil {
+		s.presence = map[string]presenceInfo{}
+	}
+	s.presence[strings.ToLower(s.selfMri())] = presenceInfo{availability: availability, activity: availability}
+	s.presenceMu.Unlock()
+	return nil
+}
+
+// setStatusMessage publishes the signed in user's status message; an empty
+// message clears it.
+func (s *AppState) setStatusMessage(message string) error {
+	body, err := json.Marshal(map[string]string{
+		"message": strings.TrimSpace(message),
+		"expiry":  statusMessageExpiry,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encode status message: %v", err)
+	}
+	_, err = s.presenceRequestBody(http.MethodPut, presenceHost+"v1/me/publishnote", body)
+	return err
+}
+
+// changeOwnAvailability sets the status in the background and reports the
+// outcome in the compose title.
+func (s *AppState) changeOwnAvailability(availability string) {
+	label := presenceInfo{availability: availability}.label()
+	s.setComposeStatus("Setting status to " + label)
+	go func() {
+		if err := s.setOwnAvailability(availability); err != nil {
+			s.logger.WithError(err).WithField("availability", availability).Warn("unable to set status")
+			s.app.QueueUpdateDraw(func() {
+				s.setComposeStatus("Set status failed")
+			})
+			return
+		}
+		s.app.QueueUpdateDraw(func() {
+			s.applyPresence()
+			s.setComposeStatus("Status: " + label)
+		})
+	}()
+}
+
+func (s *AppState) changeStatusMessage(message string) {
+	go func() {
+		if err := s.setStatusMessage(message); err != nil {
+			s.logger.WithError(err).Warn("unable to set status message")
+			s.app.QueueUpdateDraw(func() {
+				s.setComposeStatus("Set status message failed")
+			})
+			return
+		}
+		s.app.QueueUpdateDraw(func() {
+			if strings.TrimSpace(message) == "" {
+				s.setComposeStatus("Status message cleared")
+				return
+			}
+			s.setComposeStatus("Status message set")
+		})
+	}()
+}
+
+// showStatusPicker offers every status plus setting or clearing the status
+// message.
+func (s *AppState) showStatusPicker() {
+	current := s.presenceOf(s.selfMri()).availability
+	items := []pickerItem{}
+	for _, option := range ownAvailabilities {
+		info := presenceInfo{availability: option.value}
+		secondary := "/status " + option.alias
+		if strings.EqualFold(current, option.value) {
+			secondary = "current"
+		}
+		items = append(items, pickerItem{
+			label:     info.label(),
+			secondary: secondary,
+			keywords:  []string{option.alias},
+			value:     option.value,
+		})
+	}
+	items = append(items,
+		pickerItem{label: "Set status message…", secondary: "/note <text>", value: statusPickMessage},
+		pickerItem{label: "Clear status message", secondary: "/note", value: statusPickClear},
+	)
+	s.showPicker(pageSetStatus, "Set status", items, func(item pickerItem) {
+		switch value := item.value.(string); value {
+		case statusPickMessage:
+			s.promptText(pageStatusMessage, "Status message", "Message: ", "", func(text string, ok bool) {
+				if ok {
+					s.changeStatusMessage(text)
+				}
+			})
+		case statusPickClear:
+			s.changeStatusMessage("")
+		default:
+			s.changeOwnAvailability(value)
+		}
+	})
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	teams_api "github.com/fossteams/teams-api"
+	api "github.com/fossteams/teams-api/pkg"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProfileName = "default"
+	pageSwitchAccount  = "pageSwitchAccount"
+)
+
+// errProfileSignedOut means a named profile has no tokens yet.
+var errProfileSignedOut = errors.New("no tokens for this account")
+
+// profileTokenKinds are the token files teams-api reads. The teams token is
+// optional.
+var profileTokenKinds = []string{"skype", "chatsvcagg", "teams"}
+
+// inheritedTokenEnv holds the MS_TEAMS_*_TOKEN variables the process started
+// with, restored whenever the default profile is used.
+var inheritedTokenEnv = func() map[string]string {
+	env := map[string]string{}
+	for _, kind := range profileTokenKinds {
+		if v, ok := os.LookupEnv(tokenEnvName(kind)); ok {
+			env[tokenEnvName(kind)] = v
+		}
+	}
+	return env
+}()
+
+// accountProfile is one signed-in account. Every profile keeps its tokens,
+// settings file and settings key in its own directory; the default profile
+// uses ~/.config/fossteams like before profiles existed.
+type accountProfile struct {
+	name string
+	dir  string
+}
+
+// accountUnread is the last unread summary polled for an inactive profile.
+type accountUnread struct {
+	conversations int
+	err           error
+	checkedAt     time.Time
+}
+
+func tokenEnvName(kind string) string {
+	return "MS_TEAMS_" + strings.ToUpper(kind) + "_TOKEN"
+}
+
+func fossteamsConfigDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(homeDir) == "" {
+		return ""
+	}
+	return filepath.Join(homeDir, ".config", "fossteams")
+}
+
+func validProfileName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_' || r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// profileByName returns the profile called name. Named profiles live under
+// ~/.config/fossteams/profiles and need not exist yet.
+func profileByName(name string) (accountProfile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == defaultProfileName {
+		return accountProfile{name: defaultProfileName, dir: fossteamsConfigDir()}, nil
+	}
+	if !validProfileName(name) {
+		return accountProfile{}, fmt.Errorf("invalid profile name %q (use letters, digits, '-', '_' and '.')", name)
+	}
+	configDir := fossteamsConfigDir()
+	if configDir == "" {
+		return accountProfile{}, fmt.Errorf("cannot retrieve user homedir")
+	}
+	return accountProfile{name: name, dir: filepath.Join(configDir, "profiles", name)}, nil
+}
+
+// listAccountProfiles returns the default profile followed by the named
+// profiles found on disk, sorted by name.
+func listAccountProfiles() []accountProfile {
+	profiles := []accountProfile{{name: defaultProfileName, dir: fossteamsConfigDir()}}
+	if profiles[0].dir == "" {
+		return profiles
+	}
+	entries, err := os.ReadDir(filepath.Join(profiles[0].dir, "profiles"))
+	if err != nil {
+		return profiles
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && validProfileName(entry.Name()) && entry.Name() != defaultProfileName {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if p, err := profileByName(name); err == nil {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles
+}
+
+func (p accountProfile) isDefault() bool {
+	return p.name == "" || p.name == defaultProfileName
+}
+
+func (p accountProfile) displayName() string {
+	if p.name == "" {
+		return defaultProfileName
+	}
+	return p.name
+}
+
+func (p accountProfile) tokenPath(kind string) string {
+	return filepath.Join(p.dir, "token-"+kind+".jwt")
+}
+
+func (p accountProfile) settingsPaths() (string, string) {
+	if p.isDefault() {
+		return defaultSettingsPaths()
+	}
+	return filepath.Join(p.dir, "teams-cli-settings.enc"), filepath.Join(p.dir, "teams-cli-settings.key")
+}
+
+// useTokens points the teams-api token lookup at the profile. teams-api reads
+// MS_TEAMS_<KIND>_TOKEN before falling back to ~/.config/fossteams, so named
+// profiles load their files into those variables and the default profile gets
+// back whatever the process started with.
+func (p accountProfile) useTokens() error {
+	if p.isDefault() {
+		for _, kind := range profileTokenKinds {
+			name := tokenEnvName(kind)
+			if v, ok := inheritedTokenEnv[name]; ok {
+				os.Setenv(name, v)
+			} else {
+				os.Unsetenv(name)
+			}
+		}
+		return nil
+	}
+	if err := os.MkdirAll(p.dir, 0o700); err != nil {
+		return fmt.Errorf("unable to create profile directory %s: %v", p.dir, err)
+	}
+	for _, kind := range profileTokenKinds {
+		raw, err := os.ReadFile(p.tokenPath(kind))
+		if err != nil {
+			if kind == "teams" {
+				os.Unsetenv(tokenEnvName(kind))
+				continue
+			}
+			// Falling back to the default files would sign in as someone else.
+			return fmt.Errorf("profile %s: %w: sign in to write %s", p.name, errProfileSignedOut, p.tokenPath(kind))
+		}
+		os.Setenv(tokenEnvName(kind), strings.TrimSpace(string(raw)))
+	}
+	return nil
+}
+
+// importTokens copies the token files found in dir into the profile.
+func (p accountProfile) importTokens(dir string) error {
+	copied := 0
+	for _, kind := range profileTokenKinds {
+		raw, err := os.ReadFile(filepath.Join(dir, "token-"+kind+".jwt"))
+		if err != nil {
+			continue
+		}
+		if err = os.MkdirAll(p.dir, 0o700); err != nil {
+			return err
+		}
+		if err = os.WriteFile(p.tokenPath(kind), raw, 0o600); err != nil {
+			return err
+		}
+		copied++
+	}
+	if copied == 0 {
+		return fmt.Errorf("no token files were written to %s", dir)
+	}
+	return nil
+}
+
+// loadToken reads one token of the profile without touching the process
+// environment, so inactive profiles can be polled next to the active one.
+func (p accountProfile) loadToken(kind string) (*api.TeamsToken, error) {
+	raw, ok := "", false
+	if p.isDefault() {
+		raw, ok = inheritedTokenEnv[tokenEnvName(kind)]
+	}
+	if !ok || raw == "" {
+		data, err := os.ReadFile(p.tokenPath(kind))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s token: %v", kind, err)
+		}
+		raw = string(data)
+	}
+	inner, _ := jwt.Parse(strings.TrimSpace(raw), nil)
+	if inner == nil {
+		return nil, fmt.Errorf("%s token is not a JWT", kind)
+	}
+	return &api.TeamsToken{Inner: inner, Type: api.TokenBearer}, nil
+}
+
+// mutedKeys reads the muted conversations from the profile's settings. A
+// profile without settings has none.
+func (p accountProfile) mutedKeys() map[string]bool {
+	settingsPath, keyPath := p.settingsPaths()
+	data, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return nil
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil || len(key) != 32 {
+		return nil
+	}
+	settings, err := decryptChatSettings(data, key)
+	if err != nil {
+		return nil
+	}
+	return settings.Muted
+}
+
+// fetchUnreadCount counts the unread, unmuted chats and channels of an
+// inactive profile.
+func (p accountProfile) fetchUnreadCount() (int, error) {
+	chatSvcToken, err := p.loadToken("chatsvcagg")
+	if err != nil {
+		return 0, err
+	}
+	rootToken, err := p.loadToken("skype")
+	if err != nil {
+		return 0, err
+	}
+	skypeToken, err := api.New(http.DefaultClient).Authz(rootToken, api.AuthzRefresh)
+	if err != nil {
+		return 0, err
+	}
+	skypeToken.Type = api.TokenSkype
+	chatSvc, err := csa.NewCSAService(chatSvcToken, skypeToken)
+	if err != nil {
+		return 0, err
+	}
+	conversations, err := chatSvc.GetConversations()
+	if err != nil {
+		return 0, err
+	}
+
+	muted := p.mutedKeys()
+	count := 0
+	for _, chat := range conversations.Chats {
+		key := chatFavoriteKey(chat.Id, candidateConversationIds(chat, conversations.PrivateFeeds))
+		if !chat.IsRead && !muted[key] {
+			count++
+		}
+	}
+	for _, team := range conversations.Teams {
+		for _, channel := range team.Channels {
+			if channelIsUnread(channel) && !muted[normalizeFavoriteKey(channel.Id)] {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// chooseAccountAtStartup asks which profile to use before the client starts.
+// ok is false when the user quit instead.
+func chooseAccountAtStartup(profiles []accountProfile) (accountProfile, bool) {
+	app := tview.NewApplication()
+	list := tview.NewList().
+		ShowSecondaryText(false).
+		SetHighlightFullLine(true)
+	list.SetBorder(true).
+		SetTitle("Choose account (Enter to start, Esc to quit)").
+		SetTitleAlign(tview.AlignCenter)
+
+	chosen, ok := accountProfile{}, false
+	for _, p := range profiles {
+		p := p
+		list.AddItem(p.displayName(), "", 0, func() {
+			chosen, ok = p, true
+			app.Stop()
+		})
+	}
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || (event.Key() == tcell.KeyRune && event.Rune() == 'q') {
+			app.Stop()
+			return nil
+		}
+		return event
+	})
+
+	modal := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(list, len(profiles)+2, 1, true).
+			AddItem(nil, 0, 1, false), 50, 1, true).
+		AddItem(nil, 0, 1, false)
+	if err := app.SetRoot(modal, true).Run(); err != nil {
+		return accountProfile{}, false
+	}
+	return chosen, ok
+}
+
+// showAccountSwitcher lists the profiles with their unread counts and
+// switches to the chosen one.
+func (s *AppState) showAccountSwitcher() {
+	items := []pickerItem{}
+	for _, p := range listAccountProfiles() {
+		secondary := ""
+		if p.name == s.account.displayName() {
+			secondary = "current"
+		} else if unread, ok := s.getAccountUnread(p.name); ok {
+			switch {
+			case unread.err != nil:
+				secondary = "not signed in"
+			case unread.conversations > 0:
+				secondary = fmt.Sprintf("%d unread", unread.conversations)
+			}
+		}
+		items = append(items, pickerItem{label: p.name, secondary: secondary, keywords: []string{p.dir}, value: p})
+	}
+	items = append(items, pickerItem{label: "New profile...", value: nil})
+	s.showPicker(pageSwitchAccount, "Switch account", items, func(item pickerItem) {
+		p, ok := item.value.(accountProfile)
+		if !ok {
+			s.promptText(pageSwitchAccount, "New profile", "Name: ", "", func(text string, ok bool) {
+				if !ok || strings.TrimSpace(text) == "" {
+					return
+				}
+				p, err := profileByName(text)
+				if err != nil {
+					s.setComposeStatus(err.Error())
+					return
+				}
+				s.switchAccount(p)
+			})
+			return
+		}
+		s.switchAccount(p)
+	})
+}
+
+// switchAccount stops the background loops of this session and starts over
+// with p: settings, tokens and TeamsState are loaded again from the profile.
+func (s *AppState) switchAccount(p accountProfile) {
+	if p.name == s.account.displayName() {
+		s.setComposeStatus("Already using " + p.name)
+		return
+	}
+	s.logger.WithFields(logrus.Fields{
+		"from": s.account.displayName(),
+		"to":   p.name,
+	}).Info("switching account")
+	close(s.unreadScanStop)
+	close(s.presenceStop)
+
+	next := &AppState{
+		app:     s.app,
+		logger:  s.logger,
+		account: p,
+	}
+	next.createApp()
+}
+
+func (s *AppState) getAccountUnread(name string) (accountUnread, bool) {
+	s.accountUnreadMu.RLock()
+	defer s.accountUnreadMu.RUnlock()
+	unread, ok := s.accountUnread[name]
+	return unread, ok
+}
+
+// pollAccountUnread refreshes the unread counts of the inactive profiles.
+func (s *AppState) pollAccountUnread() {
+	for _, p := range listAccountProfiles() {
+		if p.name == s.account.displayName() {
+			continue
+		}
+		count, err := p.fetchUnreadCount()
+		if err != nil {
+			s.logger.WithError(err).WithField("profile", p.name).Warn("unable to poll account unread state")
+		}
+		s.accountUnreadMu.Lock()
+		s.accountUnread[p.name] = accountUnread{conversations: count, err: err, checkedAt: time.Now()}
+		s.accountUnreadMu.Unlock()
+	}
+	s.app.QueueUpdateDraw(s.updateAccountBadge)
+}
+
+// startAccountUnreadLoop polls the other profiles at the unread scan interval
+// while scanning is on. It stops with the unread scan loop.
+func (s *AppState) startAccountUnreadLoop() {
+	if len(listAccountProfiles()) < 2 {
+		return
+	}
+	interval := s.unreadScanInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		s.pollAccountUnread()
+		for {
+			select {
+			case <-ticker.C:
+				if s.isUnreadScanEnabled() {
+					s.pollAccountUnread()
+				}
+			case <-s.unreadScanStop:
+				return
+			}
+		}
+	}()
+}
+
+// ownUnreadCount counts the unread, unmuted conversations of the active
+// profile, each once however many shortcuts it has.
+func (s *AppState) ownUnreadCount() int {
+	count := 0
+	for _, teamNode := range s.tree.teamNodes {
+		for _, node := range s.tree.channelNodes[teamNode] {
+			if ref, ok := node.GetReference().(channelRef); ok && ref.isUnread && !ref.isMuted {
+				count++
+			}
+		}
+	}
+	for _, group := range []*tview.TreeNode{s.tree.favorites, s.tree.recent} {
+		if group == nil {
+			continue
+		}
+		for _, node := range group.GetChildren() {
+			if ref, ok := node.GetReference().(conversationRef); ok && ref.isUnread && !ref.isMuted {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// accountBadgeTitle is the frame title: the active profile and the unread
+// conversations summed over all accounts. With only the default profile it is
+// just the program name.
+func (s *AppState) accountBadgeTitle() string {
+	profiles := listAccountProfiles()
+	if len(profiles) < 2 && s.account.isDefault() {
+		return "teams-cli"
+	}
+	own := s.ownUnreadCount()
+	total := own
+	parts := []string{}
+	if own > 0 {
+		parts = append(parts, fmt.Sprintf("%s %d", s.account.displayName(), own))
+	}
+	for _, p := range profiles {
+		if p.name == s.account.displayName() {
+			continue
+		}
+		if unread, ok := s.getAccountUnread(p.name); ok && unread.err == nil && unread.conversations > 0 {
+			total += unread.conversations
+			parts = append(parts, fmt.Sprintf("%s %d", p.name, unread.conversations))
+		}
+	}
+	title := "teams-cli · " + s.account.displayName()
+	if total > 0 {
+		title += fmt.Sprintf(" · %d unread (%s)", total, strings.Join(parts, ", "))
+	}
+	return title
+}
+
+func (s *AppState) updateAccountBadge() {
+	if frame, ok := s.components[FrMain].(*tview.Frame); ok {
+		frame.SetTitle(s.accountBadgeTitle())
+	}
+}
+
+// reconnectTeamsClient rebuilds the Teams client from the active profile's
+// tokens after they were rewritten, and picks up their new expiry.
+func (s *AppState) reconnectTeamsClient() error {
+	if err := s.account.useTokens(); err != nil {
+		return err
+	}
+	newClient, err := teams_api.New()
+	if err != nil {
+		return fmt.Errorf("unable to reinitialize Teams client after token refresh: %v", err)
+	}
+	s.teamsClient = newClient
+
+	s.logTokenDiagnostics()
+	s.tokenMu.Lock()
+	s.tokenRetryAt = time.Time{}
+	s.tokenMu.Unlock()
+	if s.pages != nil {
+		s.app.QueueUpdateDraw(s.updateScanStatusTitle)
+	}
+	return nil
+}
+
+// tokenHelperEnv runs the token helper against a scratch home, so the files it
+// writes to ~/.config/fossteams can be moved into a named profile. Go keeps
+// using the real module and build caches.
+func tokenHelperEnv(home string) []string {
+	env := append(os.Environ(), "HOME="+home, "USERPROFILE="+home)
+	if realHome, err := os.UserHomeDir(); err == nil && os.Getenv("GOPATH") == "" {
+		env = append(env, "GOPATH="+filepath.Join(realHome, "go"))
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil && os.Getenv("GOCACHE") == "" {
+		env = append(env, "GOCACHE="+filepath.Join(cacheDir, "go-build"))
+	}
+	return env
+}
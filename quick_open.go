@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/rivo/tview"
+	"sort"
+	"strings"
+	"time"
+)
+
+const pageQuickOpen = "pageQuickOpen"
+
+type quickOpenEntry struct {
+	item       pickerItem
+	isFavorite bool
+	activity   time.Time
+}
+
+// chatsByKey indexes the loaded chats by their favorite key so tree nodes can be
+// matched back to members and last activity.
+func (s *AppState) chatsByKey() map[string]csa.Chat {
+	out := map[string]csa.Chat{}
+	conversations := s.conversationsSnapshot()
+	if conversations == nil {
+		return out
+	}
+	chats := ensurePrivateNotesChat(append([]csa.Chat(nil), conversations.Chats...), conversations.PrivateFeeds)
+	for _, chat := range chats {
+		key := chatFavoriteKey(chat.Id, candidateConversationIds(chat, conversations.PrivateFeeds))
+		if key != "" {
+			out[key] = chat
+		}
+	}
+	return out
+}
+
+func (s *AppState) buildQuickOpenItems() []pickerItem {
+	// Conversations hidden by the tree filter can still be opened.
+	if s.tree.root == nil {
+		return nil
+	}
+	chats := s.chatsByKey()
+	entries := []quickOpenEntry{}
+	// Pinned channels are also listed under their team and folder entries are
+	// copies; the first node wins.
+	listed := map[string]bool{}
+	s.tree.root.Walk(func(node, parent *tview.TreeNode) bool {
+		if key := conversationNodeKey(node); key != "" {
+			if listed[key] {
+				return true
+			}
+			listed[key] = true
+		}
+		switch ref := node.GetReference().(type) {
+		case conversationRef:
+			if ref.chatKey == settingsHelpChatKey {
+				return true
+			}
+			entry := quickOpenEntry{
+				item: pickerItem{
+					label:     ref.treeTitle(),
+					secondary: "chat",
+					value:     node,
+				},
+				isFavorite: ref.isFavorite,
+			}
+			if ref.isFavorite {
+				entry.item.secondary = "favorite"
+			}
+			if ref.folder != "" {
+				entry.item.secondary += " in " + ref.folder
+			}
+			if chat, ok := chats[normalizeFavoriteKey(ref.chatKey)]; ok {
+				entry.activity = chatLastActivity(chat)
+				entry.item.keywords = append(entry.item.keywords, buildChatDisplayName(chat, s.me))
+				for _, member := range chat.Members {
+					if name := strings.TrimSpace(member.FriendlyName); name != "" && !isCurrentUser(member, s.me) {
+						entry.item.keywords = append(entry.item.keywords, name)
+					}
+				}
+			}
+			entries = append(entries, entry)
+		case channelRef:
+			label, secondary := ref.teamName+" › "+ref.treeTitle(), "channel"
+			switch {
+			case ref.isPinned:
+				label, secondary = ref.treeTitle(), "pinned channel"
+			case ref.isFavorite:
+				label, secondary = ref.treeTitle(), "favorite channel"
+			case ref.folder != "":
+				label, secondary = ref.treeTitle(), "channel in "+ref.folder
+			}
+			entries = append(entries, quickOpenEntry{
+				item: pickerItem{
+					label:     label,
+					secondary: secondary,
+					keywords:  []string{ref.channel.DisplayName, ref.teamName},
+					value:     node,
+				},
+				activity:   channelLastActivity(ref.channel),
+				isFavorite: ref.isShortcut(),
+			})
+		case csa.Team:
+			entries = append(entries, quickOpenEntry{
+				item: pickerItem{
+					label:     ref.DisplayName,
+					secondary: "team",
+					value:     node,
+				},
+			})
+		}
+		return true
+	})
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].isFavorite != entries[j].isFavorite {
+			return entries[i].isFavorite
+		}
+		return entries[i].activity.After(entries[j].activity)
+	})
+	items := make([]pickerItem, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.item)
+	}
+	return items
+}
+
+// showQuickOpen opens the conversation switcher. Chats and channels are opened
+// right away; teams are expanded and selected in the tree.
+func (s *AppState) showQuickOpen() {
+	items := s.buildQuickOpenItems()
+	if len(items) == 0 {
+		return
+	}
+	s.showPicker(pageQuickOpen, "Go to conversation", items, func(item pickerItem) {
+		if node, ok := item.value.(*tview.TreeNode); ok {
+			s.openQuickOpenNode(node)
+		}
+	})
+}
+
+func (s *AppState) openQuickOpenNode(node *tview.TreeNode) {
+	if node == nil {
+		return
+	}
+	treeView := s.components[TrChat].(*tview.TreeView)
+	if _, isTeam := node.GetReference().(csa.Team); isTeam {
+		s.revealTreeNode(node)
+		expandTreePath(treeView.GetRoot(), node)
+		node.Expand()
+		treeView.SetCurrentNode(node)
+	} else {
+		s.selectAndOpenTreeNode(node)
+	}
+	s.app.SetFocus(treeView)
+}
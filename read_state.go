@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consumptionHorizonValue formats a message as the "messageId;timestamp;clientMessageId"
+// triple the chat service expects for the consumptionhorizon property.
+func consumptionHorizonValue(message csa.ChatMessage) string {
+	arrival := time.Time(message.OriginalArrivalTime)
+	if arrival.IsZero() {
+		arrival = time.Time(message.ComposeTime)
+	}
+	clientMessageID := strings.TrimSpace(message.ClientMessageId)
+	if clientMessageID == "" {
+		clientMessageID = "0"
+	}
+	return fmt.Sprintf("%s;%d;%s", strings.TrimSpace(message.Id), arrival.UnixMilli(), clientMessageID)
+}
+
+// unreadHorizonValue returns a horizon that sits just before the latest message,
+// which is how other clients represent "mark as unread".
+func unreadHorizonValue(messages []csa.ChatMessage) string {
+	if len(messages) >= 2 {
+		return consumptionHorizonValue(messages[len(messages)-2])
+	}
+	latest := messages[len(messages)-1]
+	arrival := time.Time(latest.OriginalArrivalTime)
+	if arrival.IsZero() {
+		arrival = time.Time(latest.ComposeTime)
+	}
+	previousID := "0"
+	if id, err := strconv.ParseInt(strings.TrimSpace(latest.Id), 10, 64); err == nil && id > 0 {
+		previousID = strconv.FormatInt(id-1, 10)
+	}
+	return fmt.Sprintf("%s;%d;0", previousID, arrival.UnixMilli()-1)
+}
+
+func (s *AppState) setConsumptionHorizon(conversationID, horizon string) error {
+	conversationID = strings.TrimSpace(conversationID)
+	if conversationID == "" {
+		return fmt.Errorf("missing conversation id for consumption horizon")
+	}
+	body, err := json.Marshal(map[string]string{
+		"consumptionhorizon": horizon,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encode consumption horizon: %v", err)
+	}
+	endpoint := csa.MessagesHost + "v1/users/ME/conversations/" + url.QueryEscape(conversationID) + "/properties?name=consumptionhorizon"
+
+	if err = s.chatServiceRequest(http.MethodPut, endpoint, body); err != nil {
+		return fmt.Errorf("consumption horizon update for %s: %v", conversationID, err)
+	}
+	return nil
+}
+
+// syncReadHorizon posts a read receipt for the newest loaded message of a
+// conversation the user opened. Reopening it only hits the server when a newer
+// message arrived.
+func (s *AppState) syncReadHorizon(messages []csa.ChatMessage) {
+	if len(messages) == 0 || s.teamsClient == nil {
+		return
+	}
+	latest := messages[len(messages)-1]
+	key := normalizeFavoriteKey(latest.ConversationId)
+	messageID := strings.TrimSpace(latest.Id)
+	if key == "" || messageID == "" {
+		return
+	}
+	s.readHorizonMu.Lock()
+	if s.readHorizonSent[key] == messageID {
+		s.readHorizonMu.Unlock()
+		return
+	}
+	s.readHorizonMu.Unlock()
+
+	if err := s.setConsumptionHorizon(latest.ConversationId, consumptionHorizonValue(latest)); err != nil {
+		s.logger.WithError(err).WithField("conversation_id", latest.ConversationId).Warn("unable to sync read state to server")
+		return
+	}
+	s.readHorizonMu.Lock()
+	if s.readHorizonSent == nil {
+		s.readHorizonSent = map[string]string{}
+	}
+	s.readHorizonSent[key] = messageID
+	s.readHorizonMu.Unlock()
+	s.logger.WithFields(logrus.Fields{
+		"conversation_id": latest.ConversationId,
+		"message_id":      messageID,
+	}).Debug("read horizon synced")
+}
+
+// markConversationUnreadOnServer moves the server consumption horizon behind the
+// latest message so the conversation shows as unread on every device.
+func (s *AppState) markConversationUnreadOnServer(displayName string, conversationIDs []string) {
+	if s.teamsClient == nil {
+		return
+	}
+	_, messages, err := s.fetchConversationMessages(displayName, conversationIDs)
+	if err != nil {
+		s.logger.WithError(err).WithField("display_name", displayName).Warn("unable to load messages for mark unread")
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	latest := messages[len(messages)-1]
+	if err = s.setConsumptionHorizon(latest.ConversationId, unreadHorizonValue(messages)); err != nil {
+		s.logger.WithError(err).WithField("conversation_id", latest.ConversationId).Warn("unable to mark conversation unread on server")
+		return
+	}
+	s.readHorizonMu.Lock()
+	delete(s.readHorizonSent, normalizeFavoriteKey(latest.ConversationId))
+	s.readHorizonMu.Unlock()
+	s.logger.WithFields(logrus.Fields{
+		"conversation_id": latest.ConversationId,
+		"display_name":    displayName,
+	}).Info("marked conversation unread on server")
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+func (s *AppState) newChannelNode(team csa.Team, channel csa.Channel) *tview.TreeNode {
+	isUnread := channelIsUnread(channel)
+	if override, ok := s.getManualUnreadOverride(channel.Id); ok {
+		isUnread = override
+	}
+	ref := channelRef{
+		channel:  channel,
+		teamName: team.DisplayName,
+		isUnread: isUnread,
+		isMuted:  s.isConversationMuted(channel.Id),
+	}
+	node := tview.NewTreeNode(ref.treeTitle())
+	node.SetReference(ref)
+	node.SetColor(tcell.ColorGreen)
+	return node
+}
+
+func (s *AppState) newChatNode(chat csa.Chat, chatKey string, candidateIDs []string, title string) *tview.TreeNode {
+	isUnread := !chat.IsRead
+	if override, ok := s.getManualUnreadOverride(chatKey); ok {
+		isUnread = override
+	}
+	ref := conversationRef{
+		ids:         candidateIDs,
+		title:       title,
+		chatKey:     chatKey,
+		isFavorite:  s.chatIsFavorite(chat, chatKey),
+		isUnread:    isUnread,
+		isMuted:     s.isConversationMuted(chatKey),
+		presenceMri: oneOnOnePartnerMri(chat, s.me),
+	}
+	ref.presence = s.presenceOf(ref.presenceMri).availability
+	node := tview.NewTreeNode(ref.treeTitle())
+	node.SetReference(ref)
+	node.SetColor(tcell.ColorGreen)
+	return node
+}
+
+// reconcileTree brings the tree in line with a fresh conversations payload:
+// new teams, channels and chats are added, ones we left are dropped, Recent is
+// sorted by last activity again and chat titles follow topic changes. Existing
+// nodes are kept, so expansion and the selection survive. It must run on the
+// UI goroutine.
+func (s *AppState) reconcileTree(conversations *csa.ConversationResponse) {
+	if conversations == nil || s.tree.root == nil {
+		return
+	}
+	s.indexConversations(conversations)
+
+	addedChannels, removedChannels := s.reconcileTeams()
+	addedChats, removedChats := s.reconcileChats()
+	if addedChannels+removedChannels+addedChats+removedChats > 0 {
+		s.logger.WithFields(logrus.Fields{
+			"added_channels":   addedChannels,
+			"removed_channels": removedChannels,
+			"added_chats":      addedChats,
+			"removed_chats":    removedChats,
+		}).Info("conversation tree reconciled")
+	}
+
+	s.renderTeams()
+	s.renderPinnedChannels()
+	s.renderFolders()
+}
+
+// reconcileTeams rebuilds the master team and channel lists, reusing the nodes
+// of teams and channels that are still there. An open channel we left is
+// closed. It returns the number of channels added and removed.
+func (s *AppState) reconcileTeams() (added, removed int) {
+	teamNodes := map[string]*tview.TreeNode{}
+	channelNodes := map[string]*tview.TreeNode{}
+	for _, teamNode := range s.tree.teamNodes {
+		if team, ok := teamNode.GetReference().(csa.Team); ok {
+			teamNodes[team.Id] = teamNode
+		}
+		for _, channelNode := range s.tree.channelNodes[teamNode] {
+			if ref, ok := channelNode.GetReference().(channelRef); ok {
+				channelNodes[ref.channel.Id] = channelNode
+			}
+		}
+	}
+
+	nextTeams := []*tview.TreeNode{}
+	nextChannels := map[*tview.TreeNode][]*tview.TreeNode{}
+	for _, team := range s.conversationsSnapshot().Teams {
+		teamNode, ok := teamNodes[team.Id]
+		if !ok {
+			teamNode = tview.NewTreeNode(team.DisplayName)
+			teamNode.SetColor(tcell.ColorBlue)
+			teamNode.Collapse()
+		}
+		teamNode.SetReference(team)
+		delete(teamNodes, team.Id)
+		for _, channel := range team.Channels {
+			channelNode, ok := channelNodes[channel.Id]
+			if ok {
+				ref := channelNode.GetReference().(channelRef)
+				ref.channel = channel
+				ref.teamName = team.DisplayName
+				channelNode.SetText(ref.treeTitle())
+				channelNode.SetReference(ref)
+				delete(channelNodes, channel.Id)
+			} else {
+				channelNode = s.newChannelNode(team, channel)
+				added++
+			}
+			nextChannels[teamNode] = append(nextChannels[teamNode], channelNode)
+		}
+		nextTeams = append(nextTeams, teamNode)
+	}
+	removed = len(channelNodes)
+	s.tree.teamNodes = nextTeams
+	s.tree.channelNodes = nextChannels
+	_, _, active := s.getActiveConversation()
+	if activeKey := conversationNodeKey(active); activeKey != "" {
+		for _, node := range channelNodes {
+			if conversationNodeKey(node) == activeKey {
+				s.closeActiveConversation()
+				break
+			}
+		}
+	}
+
+	// Favorite channel shortcuts of channels we left go as well.
+	for _, child := range s.tree.favorites.GetChildren() {
+		if ref, ok := child.GetReference().(channelRef); ok {
+			if _, exists := s.channelById[ref.channel.Id]; !exists {
+				s.tree.favorites.RemoveChild(child)
+			}
+		}
+	}
+	return added, removed
+}
+
+// reconcileChats adds new chats, drops chats that are gone, updates titles and
+// re-sorts Recent newest first. It returns the number of chats added and
+// removed.
+func (s *AppState) reconcileChats() (added, removed int) {
+	conversations := s.conversationsSnapshot()
+	chats := ensurePrivateNotesChat(append([]csa.Chat(nil), conversations.Chats...), conversations.PrivateFeeds)
+	sort.SliceStable(chats, func(i, j int) bool {
+		return chatLastActivity(chats[i]).After(chatLastActivity(chats[j]))
+	})
+
+	existing := map[string]*tview.TreeNode{}
+	for _, group := range []*tview.TreeNode{s.tree.favorites, s.tree.recent} {
+		for _, child := range group.GetChildren() {
+			if _, ok := child.GetReference().(conversationRef); ok {
+				existing[conversationNodeKey(child)] = child
+			}
+		}
+	}
+	_, _, active := s.getActiveConversation()
+
+	recent := []*tview.TreeNode{}
+	titlesChanged := false
+	for _, chat := range chats {
+		if strings.TrimSpace(chat.Id) == "" {
+			continue
+		}
+		candidateIDs := candidateConversationIds(chat, conversations.PrivateFeeds)
+		key := chatFavoriteKey(chat.Id, candidateIDs)
+		if key == "" {
+			continue
+		}
+		// A topic set on the server wins over a remembered title.
+		title := buildChatDisplayName(chat, s.me)
+		if strings.TrimSpace(chat.Title) != "" {
+			titlesChanged = s.setChatTitle(key, title) || titlesChanged
+		} else {
+			title = s.chatDisplayNameForKey(key, title)
+		}
+
+		delete(s.pendingChats, key)
+		node, ok := existing[key]
+		if !ok {
+			node = s.newChatNode(chat, key, candidateIDs, title)
+			if node.GetReference().(conversationRef).isFavorite {
+				s.tree.favorites.AddChild(node)
+			} else {
+				recent = append(recent, node)
+			}
+			added++
+			continue
+		}
+		delete(existing, key)
+		ref := node.GetReference().(conversationRef)
+		if ref.title != title {
+			ref.title = title
+			node.SetText(ref.treeTitle())
+			node.SetReference(ref)
+			s.syncConversationCopies(node)
+			if conversationNodeKey(active) == key {
+				s.components[ViChat].(*tview.List).SetTitle(title)
+				s.setActiveConversationTitle(title)
+			}
+		}
+		if !ref.isFavorite {
+			recent = append(recent, node)
+		}
+	}
+	if titlesChanged {
+		s.persistEncryptedChatSettings()
+	}
+
+	// Chats created here a moment ago may not be listed by the server yet; they
+	// stay until it does.
+	pending := []*tview.TreeNode{}
+	pendingChats := []csa.Chat{}
+	for key, node := range existing {
+		if chat, ok := s.pendingChats[key]; ok {
+			pendingChats = append(pendingChats, chat)
+			pending = append(pending, node)
+			continue
+		}
+		s.tree.favorites.RemoveChild(node)
+		removed++
+		if conversationNodeKey(active) == key {
+			s.closeActiveConversation()
+		}
+	}
+	if len(pendingChats) > 0 {
+		s.setChats(append(append([]csa.Chat(nil), conversations.Chats...), pendingChats...))
+	}
+	s.tree.recent.SetChildren(append(pending, recent...))
+	s.sortFavoriteNodes()
+	s.rebuildChatGroups()
+	return added, removed
+}
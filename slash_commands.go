@@ -0,0 +1,508 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/rivo/tview"
+	"golang.org/x/net/html"
+	"sort"
+	"strings"
+)
+
+const (
+	pageSlashHelp     = "pageSlashHelp"
+	pageConfirmDelete = "pageConfirmDelete"
+)
+
+// slashCommand is a compose command such as "/topic". run is called on the UI
+// goroutine with everything after the command name; a returned error is shown in
+// the compose title and the typed text is kept. complete, when set, offers values
+// for the first argument on Tab.
+type slashCommand struct {
+	name        string
+	usage       string
+	description string
+	run         func(s *AppState, args string) error
+	complete    func(s *AppState, prefix string) []string
+}
+
+var slashCommands = map[string]slashCommand{}
+
+var reactionKeys = []string{"like", "heart", "laugh", "surprised", "sad", "angry"}
+
+func registerSlashCommand(cmd slashCommand) {
+	slashCommands[cmd.name] = cmd
+}
+
+func sortedSlashCommandNames() []string {
+	names := make([]string, 0, len(slashCommands))
+	for name := range slashCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isSlashCommand reports whether compose text should be run as a command. Text
+// starting with "//" is sent literally without its first slash.
+func isSlashCommand(text string) bool {
+	return strings.HasPrefix(text, "/") && !strings.HasPrefix(text, "//")
+}
+
+func splitSlashCommand(text string) (string, string) {
+	text = strings.TrimPrefix(strings.TrimSpace(text), "/")
+	name, args := text, ""
+	if idx := strings.IndexAny(text, " \t"); idx >= 0 {
+		name, args = text[:idx], strings.TrimSpace(text[idx+1:])
+	}
+	return strings.ToLower(name), args
+}
+
+func (s *AppState) runSlashCommand(text string) error {
+	name, args := splitSlashCommand(text)
+	cmd, ok := slashCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command /%s (try /help)", name)
+	}
+	if err := cmd.run(s, args); err != nil {
+		return fmt.Errorf("/%s: %v", name, err)
+	}
+	return nil
+}
+
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	prefix := values[0]
+	for _, v := range values[1:] {
+		for !strings.HasPrefix(v, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// completeSlashCommand completes the command name, or the first argument of
+// commands that support it, in the compose field. It returns false when the
+// compose text is not a command so Tab keeps switching panes.
+func (s *AppState) completeSlashCommand() bool {
+	composeView, ok := s.components[ViCompose].(*tview.InputField)
+	if !ok {
+		return false
+	}
+	text := composeView.GetText()
+	if !isSlashCommand(text) {
+		return false
+	}
+
+	var head, typed string
+	var options []string
+	if idx := strings.IndexAny(text, " \t"); idx < 0 {
+		head, typed = "/", strings.ToLower(text[1:])
+		for _, name := range sortedSlashCommandNames() {
+			if strings.HasPrefix(name, typed) {
+				options = append(options, name)
+			}
+		}
+	} else {
+		name, _ := splitSlashCommand(text[:idx])
+		cmd, ok := slashCommands[name]
+		if !ok || cmd.complete == nil {
+			return true
+		}
+		head, typed = text[:idx+1], strings.TrimLeft(text[idx+1:], " \t")
+		for _, option := range cmd.complete(s, typed) {
+			if strings.HasPrefix(strings.ToLower(option), strings.ToLower(typed)) {
+				options = append(options, option)
+			}
+		}
+	}
+
+	switch len(options) {
+	case 0:
+		s.setComposeStatus("No completions")
+	case 1:
+		composeView.SetText(head + options[0] + " ")
+	default:
+		if prefix := commonPrefix(options); len(prefix) > len(typed) {
+			composeView.SetText(head + prefix)
+		}
+		s.setComposeStatus(strings.Join(options, " "))
+	}
+	return true
+}
+
+func activeConversationRequired(s *AppState) ([]string, string, *tview.TreeNode, error) {
+	ids, title, node := s.getActiveConversation()
+	if len(ids) == 0 {
+		return nil, "", nil, fmt.Errorf("open a conversation first")
+	}
+	return ids, title, node, nil
+}
+
+func (s *AppState) showSlashCommandHelp() {
+	items := []pickerItem{}
+	for _, name := range sortedSlashCommandNames() {
+		cmd := slashCommands[name]
+		label := "/" + cmd.name
+		if cmd.usage != "" {
+			label += " " + cmd.usage
+		}
+		items = append(items, pickerItem{
+			label:     label,
+			secondary: cmd.description,
+			value:     cmd.name,
+		})
+	}
+	s.showPicker(pageSlashHelp, "Compose commands", items, func(item pickerItem) {
+		composeView := s.components[ViCompose].(*tview.InputField)
+		composeView.SetText("/" + item.value.(string) + " ")
+		s.app.SetFocus(composeView)
+	})
+}
+
+func init() {
+	registerSlashCommand(slashCommand{
+		name:        "me",
+		usage:       "<text>",
+		description: "Send an action message",
+		run: func(s *AppState, args string) error {
+			if args == "" {
+				return fmt.Errorf("usage: /me <text>")
+			}
+			ids, title, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			name := "me"
+			if s.me != nil && strings.TrimSpace(s.me.DisplayName) != "" {
+				name = strings.TrimSpace(s.me.DisplayName)
+			}
+			content := "<div><em>* " + html.EscapeString(name+" "+args) + "</em></div>"
+			go func() {
+				if err := s.postMessageHTML(ids, content, nil); err != nil {
+					s.showError(err)
+					return
+				}
+				s.refreshAfterSend(ids, title, node)
+			}()
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "topic",
+		usage:       "<title>",
+		description: "Rename the current group chat",
+		run: func(s *AppState, args string) error {
+			if args == "" {
+				return fmt.Errorf("usage: /topic <title>")
+			}
+			_, _, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			return s.renameGroupChat(node, args)
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "react",
+		usage:       "<" + strings.Join(reactionKeys, "|") + ">",
+		description: "React to the selected message",
+		run: func(s *AppState, args string) error {
+			key := strings.ToLower(strings.TrimSpace(args))
+			if key == "" {
+				key = defaultReactionKey
+			}
+			msg, ok := s.selectedChatMessage()
+			if !ok || strings.TrimSpace(msg.Id) == "" || strings.TrimSpace(msg.ConversationId) == "" {
+				return fmt.Errorf("select a message first")
+			}
+			go s.reactToMessage(msg, key)
+			s.setComposeStatus("Reacted " + key)
+			return nil
+		},
+		complete: func(s *AppState, prefix string) []string {
+			return reactionKeys
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "edit",
+		usage:       "[text]",
+		description: "Edit your last message (without text: load it for editing)",
+		run: func(s *AppState, args string) error {
+			ids, title, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			msg, ok := s.lastOwnMessage()
+			if !ok {
+				return fmt.Errorf("no message of yours is loaded")
+			}
+			if args == "" {
+				loaded := strings.ReplaceAll(strings.TrimSpace(textMessage(msg.Content)), "\n", composeNewlineMarker)
+				s.components[ViCompose].(*tview.InputField).SetText("/edit " + loaded)
+				return nil
+			}
+			go func() {
+				if err := s.editMessageOnServer(msg, expandComposeNewlines(args)); err != nil {
+					s.logger.WithError(err).WithField("message_id", msg.Id).Warn("unable to edit message")
+					s.app.QueueUpdateDraw(func() {
+						s.setComposeStatus("Edit failed")
+					})
+					return
+				}
+				s.loadConversationsByIDs(node, ids, title)
+			}()
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "delete",
+		description: "Delete your last message",
+		run: func(s *AppState, args string) error {
+			ids, title, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			msg, ok := s.lastOwnMessage()
+			if !ok {
+				return fmt.Errorf("no message of yours is loaded")
+			}
+			preview := summarizeReplyPreview(msg.Content)
+			s.confirmAction(pageConfirmDelete, "Delete your message?\n\n"+preview, func() {
+				go func() {
+					if err := s.deleteMessageOnServer(msg); err != nil {
+						s.logger.WithError(err).WithField("message_id", msg.Id).Warn("unable to delete message")
+						s.app.QueueUpdateDraw(func() {
+							s.setComposeStatus("Delete failed")
+						})
+						return
+					}
+					s.loadConversationsByIDs(node, ids, title)
+				}()
+			})
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "mute",
+		description: "Toggle mute for the current conversation",
+		run: func(s *AppState, args string) error {
+			_, title, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			muted, ok := s.toggleMuteForNode(node)
+			if !ok {
+				return fmt.Errorf("this conversation cannot be muted")
+			}
+			if muted {
+				s.setComposeStatus("Muted " + title)
+			} else {
+				s.setComposeStatus("Unmuted " + title)
+			}
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "export",
+		description: "Export the current chat to a file",
+		run: func(s *AppState, args string) error {
+			path, err := s.exportActiveChat()
+			if err != nil {
+				return err
+			}
+			s.setComposeStatus("Exported to " + path)
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "search",
+		usage:       "[text]",
+		description: "Search messages in the current chat",
+		run: func(s *AppState, args string) error {
+			if _, _, _, err := activeConversationRequired(s); err != nil {
+				return err
+			}
+			if args == "" {
+				s.promptSearchMessages()
+				return nil
+			}
+			count := s.searchChatMessages(args)
+			if count == 0 {
+				return fmt.Errorf("no matches for %s", args)
+			}
+			s.app.SetFocus(s.components[ViChat])
+			s.setComposeStatus(fmt.Sprintf("Match 1/%d for %s", count, args))
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "goto",
+		usage:       "<chat|teams-link>",
+		description: "Open the best matching chat, channel or team, or a Teams link",
+		run: func(s *AppState, args string) error {
+			if args == "" {
+				s.showQuickOpen()
+				return nil
+			}
+			if _, ok := parseTeamsDeepLink(args); ok {
+				return s.openDeepLink(args)
+			}
+			matches := filterPickerItems(s.buildQuickOpenItems(), args)
+			if len(matches) == 0 {
+				return fmt.Errorf("no conversation matches %s", args)
+			}
+			s.openQuickOpenNode(matches[0].value.(*tview.TreeNode))
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "status",
+		usage:       "[available|busy|dnd|brb|away|offline]",
+		description: "Set your status (without argument: pick one)",
+		run: func(s *AppState, args string) error {
+			if args == "" {
+				s.showStatusPicker()
+				return nil
+			}
+			availability, ok := parseAvailability(args)
+			if !ok {
+				return fmt.Errorf("unknown status %s", args)
+			}
+			s.changeOwnAvailability(availability)
+			return nil
+		},
+		complete: func(s *AppState, prefix string) []string {
+			aliases := make([]string, 0, len(ownAvailabilities))
+			for _, option := range ownAvailabilities {
+				aliases = append(aliases, option.alias)
+			}
+			return aliases
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "note",
+		usage:       "[text]",
+		description: "Set your status message (without text: clear it)",
+		run: func(s *AppState, args string) error {
+			s.changeStatusMessage(args)
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "add",
+		usage:       "<person>[, <person>...]",
+		description: "Add people (email, MRI or name) to the current group chat",
+		run: func(s *AppState, args string) error {
+			if args == "" {
+				s.showAddChatMembers()
+				return nil
+			}
+			_, _, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			if _, _, err := s.groupChatForNode(node); err != nil {
+				return err
+			}
+			s.setComposeStatus("Looking up people")
+			go func() {
+				people := []mentionCandidate{}
+				for _, arg := range strings.Split(args, ",") {
+					if strings.TrimSpace(arg) == "" {
+						continue
+					}
+					person, err := s.resolvePerson(arg)
+					if err != nil {
+						s.app.QueueUpdateDraw(func() {
+							s.setComposeStatus("/add: " + err.Error())
+						})
+						return
+					}
+					people = append(people, person)
+				}
+				s.app.QueueUpdateDraw(func() {
+					if err := s.addGroupChatMembers(node, people); err != nil {
+						s.setComposeStatus("/add: " + err.Error())
+					}
+				})
+			}()
+			return nil
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "remove",
+		usage:       "<name>",
+		description: "Remove someone from the current group chat",
+		run: func(s *AppState, args string) error {
+			if args == "" {
+				s.showRemoveChatMember()
+				return nil
+			}
+			_, _, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			_, chat, err := s.groupChatForNode(node)
+			if err != nil {
+				return err
+			}
+			matches := []csa.ChatMember{}
+			for _, member := range chat.Members {
+				name := strings.TrimSpace(member.FriendlyName)
+				if isCurrentUser(member, s.me) {
+					continue
+				}
+				if strings.EqualFold(name, args) || strings.EqualFold(member.Mri, args) {
+					matches = []csa.ChatMember{member}
+					break
+				}
+				if strings.Contains(strings.ToLower(name), strings.ToLower(args)) {
+					matches = append(matches, member)
+				}
+			}
+			switch len(matches) {
+			case 0:
+				return fmt.Errorf("nobody in this chat matches %q", args)
+			case 1:
+				return s.removeGroupChatMember(node, matches[0].Mri, matches[0].FriendlyName)
+			}
+			return fmt.Errorf("%q matches %d members", args, len(matches))
+		},
+		complete: func(s *AppState, prefix string) []string {
+			_, _, node := s.getActiveConversation()
+			_, chat, err := s.groupChatForNode(node)
+			if err != nil {
+				return nil
+			}
+			names := []string{}
+			for _, member := range chat.Members {
+				if !isCurrentUser(member, s.me) && strings.TrimSpace(member.FriendlyName) != "" {
+					names = append(names, strings.TrimSpace(member.FriendlyName))
+				}
+			}
+			return names
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "leave",
+		description: "Leave the current group chat",
+		run: func(s *AppState, args string) error {
+			_, _, node, err := activeConversationRequired(s)
+			if err != nil {
+				return err
+			}
+			return s.leaveGroupChat(node)
+		},
+	})
+	registerSlashCommand(slashCommand{
+		name:        "help",
+		description: "List compose commands",
+		run: func(s *AppState, args string) error {
+			s.showSlashCommandHelp()
+			return nil
+		},
+	})
+}
@@ -7,17 +7,21 @@ import (
 	"github.com/fossteams/teams-api/pkg/models"
 	"github.com/sirupsen/logrus"
 	"sort"
+	"sync"
 )
 
 type TeamsState struct {
 	teamsClient *teams_api.TeamsClient
 	logger      *logrus.Logger
 
-	conversations  *csa.ConversationResponse
-	me             *models.User
-	pinnedChannels []csa.ChannelId
-	channelById    map[string]Channel
-	teamById       map[string]*csa.Team
+	// conversations is replaced as a whole, never changed in place, so a
+	// snapshot taken under conversationsMu stays valid without the lock.
+	conversationsMu sync.RWMutex
+	conversations   *csa.ConversationResponse
+	me              *models.User
+	pinnedChannels  []csa.ChannelId
+	channelById     map[string]Channel
+	teamById        map[string]*csa.Team
 }
 
 type Channel struct {
@@ -54,31 +58,61 @@ func (s *TeamsState) init(client *teams_api.TeamsClient) error {
 		s.logger.WithField("pinned_channels_count", len(s.pinnedChannels)).Debug("loaded pinned channels")
 	}
 
-	s.conversations, err = client.GetConversations()
+	conversations, err := client.GetConversations()
 	if err != nil {
 		return fmt.Errorf("unable to get conversations: %v", err)
 	}
 	if s.logger != nil {
 		s.logger.WithFields(logrus.Fields{
-			"teams_count":         len(s.conversations.Teams),
-			"chats_count":         len(s.conversations.Chats),
-			"private_feeds_count": len(s.conversations.PrivateFeeds),
+			"teams_count":         len(conversations.Teams),
+			"chats_count":         len(conversations.Chats),
+			"private_feeds_count": len(conversations.PrivateFeeds),
 		}).Info("loaded conversations payload")
 	}
 
+	s.indexConversations(conversations)
+	return nil
+}
+
+// conversationsSnapshot returns the loaded conversations, or nil before they
+// are loaded. The result must not be modified.
+func (s *TeamsState) conversationsSnapshot() *csa.ConversationResponse {
+	s.conversationsMu.RLock()
+	defer s.conversationsMu.RUnlock()
+	return s.conversations
+}
+
+// setChats publishes a copy of the loaded conversations with chats as its
+// chat list.
+func (s *TeamsState) setChats(chats []csa.Chat) {
+	s.conversationsMu.Lock()
+	defer s.conversationsMu.Unlock()
+	if s.conversations == nil {
+		return
+	}
+	next := *s.conversations
+	next.Chats = chats
+	s.conversations = &next
+}
+
+// indexConversations sorts the teams of conversations by name, rebuilds the
+// team and channel indexes and publishes conversations.
+func (s *TeamsState) indexConversations(conversations *csa.ConversationResponse) {
 	// Sort Teams by Name
-	sort.Sort(csa.TeamsByName(s.conversations.Teams))
+	sort.Sort(csa.TeamsByName(conversations.Teams))
 
 	// Create maps
 	s.teamById = map[string]*csa.Team{}
 	s.channelById = map[string]Channel{}
 
-	for _, t := range s.conversations.Teams {
-		s.teamById[t.Id] = &t
-		for _, c := range t.Channels {
+	for i := range conversations.Teams {
+		t := &conversations.Teams[i]
+		s.teamById[t.Id] = t
+		for j := range t.Channels {
+			c := &t.Channels[j]
 			s.channelById[c.Id] = Channel{
-				Channel: &c,
-				parent:  &t,
+				Channel: c,
+				parent:  t,
 			}
 		}
 	}
@@ -89,5 +123,7 @@ func (s *TeamsState) init(client *teams_api.TeamsClient) error {
 		}).Debug("conversation indexes built")
 	}
 
-	return nil
+	s.conversationsMu.Lock()
+	s.conversations = conversations
+	s.conversationsMu.Unlock()
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/fossteams/teams-api/pkg"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// tokenRefreshLead is how long before the earliest expiry tokens are
+	// renewed; a failed attempt is repeated after tokenRefreshRetry.
+	tokenRefreshLead  = 5 * time.Minute
+	tokenRefreshRetry = 5 * time.Minute
+
+	requestMaxAttempts   = 3
+	requestRetryDelay    = 500 * time.Millisecond
+	requestMaxRetryDelay = 10 * time.Second
+)
+
+// refreshRequestAuth renews the tokens after a request was rejected with 401.
+var refreshRequestAuth = (*AppState).refreshAuthFromTeamsToken
+
+// recordTokenExpiry remembers when token runs out. The earliest expiry drives
+// the scheduled refresh and the status bar.
+func (s *AppState) recordTokenExpiry(name string, token *api.TeamsToken) {
+	exp, ok := tokenExpiry(token)
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	if s.tokenExpiries == nil {
+		s.tokenExpiries = map[string]time.Time{}
+	}
+	if !ok {
+		delete(s.tokenExpiries, name)
+		return
+	}
+	s.tokenExpiries[name] = exp
+}
+
+func (s *AppState) earliestTokenExpiry() (string, time.Time, bool) {
+	s.tokenMu.RLock()
+	defer s.tokenMu.RUnlock()
+	name, earliest := "", time.Time{}
+	for candidate, exp := range s.tokenExpiries {
+		if earliest.IsZero() || exp.Before(earliest) {
+			name, earliest = candidate, exp
+		}
+	}
+	return name, earliest, !earliest.IsZero()
+}
+
+func formatTokenValidity(left time.Duration) string {
+	switch {
+	case left <= 0:
+		return "expired"
+	case left < time.Minute:
+		return "<1m"
+	case left < time.Hour:
+		return fmt.Sprintf("%dm", int(left.Minutes()))
+	default:
+		return fmt.Sprintf("%dh%02dm", int(left.Hours()), int(left.Minutes())%60)
+	}
+}
+
+// tokenStatus is the remaining validity of the earliest expiring token, as
+// shown in the compose title.
+func (s *AppState) tokenStatus() string {
+	_, exp, ok := s.earliestTokenExpiry()
+	if !ok {
+		return ""
+	}
+	return "Token: " + formatTokenValidity(time.Until(exp))
+}
+
+// startTokenManager checks the token expiry every minute, renews the tokens
+// tokenRefreshLead before the earliest one runs out and keeps the status bar
+// current. It stops with the unread scan loop.
+func (s *AppState) startTokenManager() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.refreshTokensIfDue()
+				s.app.QueueUpdateDraw(s.updateScanStatusTitle)
+			case <-s.unreadScanStop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *AppState) refreshTokensIfDue() {
+	name, exp, ok := s.earliestTokenExpiry()
+	if !ok {
+		return
+	}
+	now := time.Now()
+	s.tokenMu.RLock()
+	retryAt := s.tokenRetryAt
+	s.tokenMu.RUnlock()
+	// Expired tokens are left to the refresh on 401.
+	if now.After(exp) || exp.Sub(now) > tokenRefreshLead || now.Before(retryAt) {
+		return
+	}
+	s.logger.WithFields(logrus.Fields{
+		"token_name": name,
+		"expires_at": exp.Format(time.RFC3339),
+	}).Info("refreshing tokens ahead of expiry")
+	if err := s.refreshAuthAhead(); err != nil {
+		s.logger.WithError(err).Warn("scheduled token refresh failed")
+		s.tokenMu.Lock()
+		s.tokenRetryAt = now.Add(tokenRefreshRetry)
+		s.tokenMu.Unlock()
+	}
+}
+
+// refreshAuthAhead renews tokens before they expire: silently with the stored
+// native sign-in, or through the teams-token helper when native sign-in is not
+// set up. It never starts an interactive native sign-in.
+func (s *AppState) refreshAuthAhead() error {
+	s.authRefreshMu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	err := s.nativeSignIn(ctx, signInOptions{silent: true})
+	cancel()
+	if err == nil {
+		err = s.reconnectTeamsClient()
+		s.authRefreshMu.Unlock()
+		return err
+	}
+	s.authRefreshMu.Unlock()
+	if errors.Is(err, errNativeAuthNotConfigured) {
+		return s.refreshAuthFromTeamsToken()
+	}
+	return err
+}
+
+// newAuthenticatedRequest builds a request carrying the token endpoint
+// expects: chat service and messages hosts go through the chat service
+// client, everything else gets the skype spaces bearer token.
+func (s *AppState) newAuthenticatedRequest(method, endpoint string, body []byte) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if strings.HasPrefix(endpoint, csa.ChatSvcAgg) || strings.HasPrefix(endpoint, csa.MessagesHost) {
+		req, err = s.teamsClient.ChatSvc().AuthenticatedRequest(method, endpoint, bytes.NewReader(body))
+	} else {
+		token, tokenErr := api.GetSkypeSpacesToken()
+		if tokenErr != nil {
+			return nil, tokenErr
+		}
+		req, err = http.NewRequest(method, endpoint, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Authorization", api.AuthString(token))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// retryDelay honors Retry-After and otherwise backs off exponentially.
+func retryDelay(attempt int, header http.Header) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header.Get("Retry-After"))); err == nil && seconds >= 0 {
+		delay := time.Duration(seconds) * time.Second
+		if delay > requestMaxRetryDelay {
+			delay = requestMaxRetryDelay
+		}
+		return delay
+	}
+	return requestRetryDelay << (attempt - 1)
+}
+
+// authenticatedRoundTrip sends an authenticated request and returns the body
+// and headers of a 2xx answer. Every caller gets the same retries: auth is
+// refreshed once on 401, and 429 answers are tried up to requestMaxAttempts
+// times. 5xx answers and network errors are retried the same way, except for
+// POST, which the server may already have acted on.
+func (s *AppState) authenticatedRoundTrip(method, endpoint string, body []byte) ([]byte, http.Header, error) {
+	refreshed := false
+	retryFailures := method != http.MethodPost
+	for attempt := 1; ; attempt++ {
+		req, err := s.newAuthenticatedRequest(method, endpoint, body)
+		if err == nil {
+			resp, doErr := http.DefaultClient.Do(req)
+			if doErr != nil {
+				if retryFailures && attempt < requestMaxAttempts {
+					time.Sleep(retryDelay(attempt, http.Header{}))
+					continue
+				}
+				return nil, nil, doErr
+			}
+			respBody, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return respBody, resp.Header, nil
+			}
+			err = fmt.Errorf("%s %s status=%d body=%s", method, endpoint, resp.StatusCode, strings.TrimSpace(string(respBody)))
+			retryable := resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode >= 500 && retryFailures)
+			if retryable && attempt < requestMaxAttempts {
+				time.Sleep(retryDelay(attempt, resp.Header))
+				continue
+			}
+			if resp.StatusCode != http.StatusUnauthorized {
+				return nil, nil, err
+			}
+		}
+		if refreshed || !isUnauthorizedError(err) {
+			return nil, nil, err
+		}
+		refreshed = true
+		if refreshErr := refreshRequestAuth(s); refreshErr != nil {
+			return nil, nil, fmt.Errorf("%v (auth refresh failed: %v)", err, refreshErr)
+		}
+		// The refresh does not use up a retry.
+		attempt--
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func testBearerToken(t *testing.T, subject string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": subject,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// scriptedServer answers request n with statuses[n], repeating the last one,
+// and records the method, body and Authorization header of every request.
+type scriptedServer struct {
+	*httptest.Server
+	statuses   []int
+	retryAfter string
+
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+type recordedRequest struct {
+	method string
+	body   string
+	auth   string
+	at     time.Time
+}
+
+func newScriptedServer(t *testing.T, retryAfter string, statuses ...int) *scriptedServer {
+	srv := &scriptedServer{statuses: statuses, retryAfter: retryAfter}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		srv.mu.Lock()
+		n := len(srv.requests)
+		srv.requests = append(srv.requests, recordedRequest{
+			method: r.Method,
+			body:   string(body),
+			auth:   r.Header.Get("Authorization"),
+			at:     time.Now(),
+		})
+		srv.mu.Unlock()
+		if n >= len(srv.statuses) {
+			n = len(srv.statuses) - 1
+		}
+		status := srv.statuses[n]
+		if status >= 300 && srv.retryAfter != "" {
+			w.Header().Set("Retry-After", srv.retryAfter)
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "answer %d", status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *scriptedServer) recorded() []recordedRequest {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]recordedRequest(nil), srv.requests...)
+}
+
+// stubRequestAuth replaces the 401 refresh with refresh and counts the calls.
+func stubRequestAuth(t *testing.T, refresh func() error) *int {
+	calls := 0
+	old := refreshRequestAuth
+	refreshRequestAuth = func(*AppState) error {
+		calls++
+		return refresh()
+	}
+	t.Cleanup(func() { refreshRequestAuth = old })
+	return &calls
+}
+
+func TestAuthenticatedRoundTripRetries(t *testing.T) {
+	t.Setenv("MS_TEAMS_SKYPE_TOKEN", testBearerToken(t, "current"))
+	stubRequestAuth(t, func() error {
+		t.Error("auth refreshed without a 401")
+		return nil
+	})
+
+	for _, tc := range []struct {
+		name     string
+		method   string
+		statuses []int
+		requests int
+		ok       bool
+	}{
+		{name: "429 is retried for POST", method: http.MethodPost, statuses: []int{429, 200}, requests: 2, ok: true},
+		{name: "429 gives up after the last attempt", method: http.MethodPost, statuses: []int{429}, requests: requestMaxAttempts},
+		{name: "5xx is retried for PUT", method: http.MethodPut, statuses: []int{503, 502, 200}, requests: 3, ok: true},
+		{name: "5xx gives up after the last attempt", method: http.MethodGet, statuses: []int{500}, requests: requestMaxAttempts},
+		{name: "5xx is not retried for POST", method: http.MethodPost, statuses: []int{502, 200}, requests: 1},
+		{name: "4xx is not retried", method: http.MethodDelete, statuses: []int{404, 200}, requests: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newScriptedServer(t, "0", tc.statuses...)
+			s := &AppState{}
+			body, _, err := s.authenticatedRoundTrip(tc.method, srv.URL, []byte(`{"n":1}`))
+			requests := srv.recorded()
+			if len(requests) != tc.requests {
+				t.Fatalf("%d requests, want %d", len(requests), tc.requests)
+			}
+			for i, req := range requests {
+				if req.method != tc.method || req.body != `{"n":1}` {
+					t.Errorf("request %d = %s %q", i, req.method, req.body)
+				}
+			}
+			if tc.ok {
+				if err != nil || string(body) != "answer 200" {
+					t.Fatalf("body = %q, err = %v", body, err)
+				}
+				return
+			}
+			last := tc.statuses[len(tc.statuses)-1]
+			if len(requests) <= len(tc.statuses) {
+				last = tc.statuses[len(requests)-1]
+			}
+			if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("status=%d", last)) {
+				t.Fatalf("err = %v, want status=%d", err, last)
+			}
+		})
+	}
+}
+
+func TestAuthenticatedRoundTripHonorsRetryAfter(t *testing.T) {
+	t.Setenv("MS_TEAMS_SKYPE_TOKEN", testBearerToken(t, "current"))
+	srv := newScriptedServer(t, "1", 429, 200)
+	s := &AppState{}
+	if _, _, err := s.authenticatedRoundTrip(http.MethodGet, srv.URL, nil); err != nil {
+		t.Fatalf("authenticatedRoundTrip: %v", err)
+	}
+	requests := srv.recorded()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+	if gap := requests[1].at.Sub(requests[0].at); gap < time.Second {
+		t.Fatalf("retried after %v, want at least the 1s Retry-After", gap)
+	}
+}
+
+func TestAuthenticatedRoundTripRefreshesOnceOn401(t *testing.T) {
+	stale, fresh := testBearerToken(t, "stale"), testBearerToken(t, "fresh")
+	t.Setenv("MS_TEAMS_SKYPE_TOKEN", stale)
+	refreshes := stubRequestAuth(t, func() error {
+		return os.Setenv("MS_TEAMS_SKYPE_TOKEN", fresh)
+	})
+
+	// The refresh does not use up an attempt: two 503s may still follow.
+	srv := newScriptedServer(t, "0", 401, 503, 503, 200)
+	s := &AppState{}
+	body, _, err := s.authenticatedRoundTrip(http.MethodGet, srv.URL, nil)
+	if err != nil || string(body) != "answer 200" {
+		t.Fatalf("body = %q, err = %v", body, err)
+	}
+	if *refreshes != 1 {
+		t.Fatalf("%d refreshes, want 1", *refreshes)
+	}
+	requests := srv.recorded()
+	if len(requests) != 4 {
+		t.Fatalf("%d requests, want 4", len(requests))
+	}
+	if requests[0].auth != "Bearer "+stale || requests[1].auth != "Bearer "+fresh {
+		t.Fatalf("authorization before and after refresh = %q, %q", requests[0].auth, requests[1].auth)
+	}
+}
+
+func TestAuthenticatedRoundTripStopsAfterSecond401(t *testing.T) {
+	t.Setenv("MS_TEAMS_SKYPE_TOKEN", testBearerToken(t, "current"))
+	refreshes := stubRequestAuth(t, func() error { return nil })
+	srv := newScriptedServer(t, "", 401)
+	s := &AppState{}
+	_, _, err := s.authenticatedRoundTrip(http.MethodPost, srv.URL, []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "status=401") {
+		t.Fatalf("err = %v, want the 401", err)
+	}
+	if *refreshes != 1 || len(srv.recorded()) != 2 {
+		t.Fatalf("%d refreshes and %d requests, want 1 and 2", *refreshes, len(srv.recorded()))
+	}
+}
+
+func TestAuthenticatedRoundTripReportsFailedRefresh(t *testing.T) {
+	t.Setenv("MS_TEAMS_SKYPE_TOKEN", testBearerToken(t, "current"))
+	stubRequestAuth(t, func() error { return fmt.Errorf("no stored sign-in") })
+	srv := newScriptedServer(t, "", 401)
+	s := &AppState{}
+	_, _, err := s.authenticatedRoundTrip(http.MethodGet, srv.URL, nil)
+	if err == nil || !strings.Contains(err.Error(), "auth refresh failed: no stored sign-in") {
+		t.Fatalf("err = %v", err)
+	}
+	if got := len(srv.recorded()); got != 1 {
+		t.Fatalf("%d requests, want 1", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	header := func(value string) http.Header {
+		h := http.Header{}
+		if value != "" {
+			h.Set("Retry-After", value)
+		}
+		return h
+	}
+	for _, tc := range []struct {
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{attempt: 1, want: requestRetryDelay},
+		{attempt: 2, want: 2 * requestRetryDelay},
+		{attempt: 3, want: 4 * requestRetryDelay},
+		{attempt: 1, retryAfter: "3", want: 3 * time.Second},
+		{attempt: 1, retryAfter: "0", want: 0},
+		{attempt: 1, retryAfter: "3600", want: requestMaxRetryDelay},
+		{attempt: 2, retryAfter: "soon", want: 2 * requestRetryDelay},
+	} {
+		if got := retryDelay(tc.attempt, header(tc.retryAfter)); got != tc.want {
+			t.Errorf("retryDelay(%d, %q) = %v, want %v", tc.attempt, tc.retryAfter, got, tc.want)
+		}
+	}
+}
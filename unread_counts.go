@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/fossteams/teams-api/pkg/csa"
+	"github.com/fossteams/teams-api/pkg/models"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+type unreadState struct {
+	unread   bool
+	count    int
+	mentions int
+}
+
+func (u unreadState) matches(unread bool, count, mentions int) bool {
+	return u.unread == unread && u.count == count && u.mentions == mentions
+}
+
+type unreadCountEntry struct {
+	lastMessageID string
+	horizon       time.Time
+	count         int
+	mentions      int
+}
+
+// unreadMessageStats returns the number of unread messages and mentions of the
+// current user newer than horizon. Results are cached per conversation until its
+// last message or horizon changes, so a scan only refetches active conversations.
+func (s *AppState) unreadMessageStats(key, displayName string, conversationIDs []string, horizon time.Time, lastMessageID string) (int, int) {
+	if horizon.IsZero() || s.teamsClient == nil {
+		return 0, 0
+	}
+	lastMessageID = strings.TrimSpace(lastMessageID)
+	s.unreadCountsMu.Lock()
+	if cached, ok := s.unreadCounts[key]; ok && cached.lastMessageID == lastMessageID && cached.horizon.Equal(horizon) {
+		s.unreadCountsMu.Unlock()
+		return cached.count, cached.mentions
+	}
+	s.unreadCountsMu.Unlock()
+
+	_, messages, err := s.fetchConversationMessages(displayName, conversationIDs)
+	if err != nil {
+		s.logger.WithError(err).WithField("display_name", displayName).Debug("unable to count unread messages")
+		return 0, 0
+	}
+	count, mentions := countUnreadMessages(messages, horizon, s.me)
+
+	s.unreadCountsMu.Lock()
+	if s.unreadCounts == nil {
+		s.unreadCounts = map[string]unreadCountEntry{}
+	}
+	s.unreadCounts[key] = unreadCountEntry{
+		lastMessageID: lastMessageID,
+		horizon:       horizon,
+		count:         count,
+		mentions:      mentions,
+	}
+	s.unreadCountsMu.Unlock()
+	s.logger.WithFields(logrus.Fields{
+		"display_name": displayName,
+		"unread":       count,
+		"mentions":     mentions,
+	}).Debug("unread message stats computed")
+	return count, mentions
+}
+
+func countUnreadMessages(messages []csa.ChatMessage, horizon time.Time, me *models.User) (int, int) {
+	count, mentions := 0, 0
+	for _, message := range messages {
+		if !isUserVisibleMessage(message) || isMessageFromUser(message, me) {
+			continue
+		}
+		arrival := time.Time(message.OriginalArrivalTime)
+		if arrival.IsZero() {
+			arrival = time.Time(message.ComposeTime)
+		}
+		if !arrival.After(horizon) {
+			continue
+		}
+		count++
+		if messageMentionsUser(message, me) {
+			mentions++
+		}
+	}
+	return count, mentions
+}
+
+func isUserVisibleMessage(message csa.ChatMessage) bool {
+	switch strings.ToLower(strings.TrimSpace(message.MessageType)) {
+	case "text", "richtext", "richtext/html", "richtext/media_genericfile", "richtext/uriobject":
+		return message.Properties.DeleteTime == 0
+	default:
+		return false
+	}
+}
+
+func isMessageFromUser(message csa.ChatMessage, me *models.User) bool {
+	if me == nil {
+		return false
+	}
+	from := strings.ToLower(strings.TrimSpace(message.From))
+	if from == "" {
+		return false
+	}
+	if oid := strings.ToLower(strings.TrimSpace(me.ObjectId)); oid != "" && strings.Contains(from, oid) {
+		return true
+	}
+	if mri := strings.ToLower(strings.TrimSpace(me.Mri)); mri != "" && strings.Contains(from, mri) {
+		return true
+	}
+	return false
+}
+
+// messageMentionsUser checks the mentions property attached by the chat service
+// for the current user's MRI or object id.
+func messageMentionsUser(message csa.ChatMessage, me *models.User) bool {
+	if me == nil || strings.TrimSpace(message.Properties.Mentions) == "" {
+		return false
+	}
+	var mentions []map[string]interface{}
+	if err := json.Unmarshal([]byte(message.Properties.Mentions), &mentions); err != nil {
+		return false
+	}
+	oid := strings.ToLower(strings.TrimSpace(me.ObjectId))
+	mri := strings.ToLower(strings.TrimSpace(me.Mri))
+	for _, mention := range mentions {
+		target := strings.ToLower(firstString(mention, "mri", "id", "objectId"))
+		if target == "" {
+			continue
+		}
+		if (mri != "" && target == mri) || (oid != "" && strings.HasSuffix(target, oid)) {
+			return true
+		}
+	}
+	return false
+}